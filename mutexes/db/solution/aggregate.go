@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AggType is the kind of an aggregation
+type AggType int
+
+const (
+	// AggCount counts the matching documents
+	AggCount AggType = iota + 1
+	// AggTerms counts the matching documents per value of Field, or per token of their text when Field is empty. The text of a JSON document is made of its text values only, its keyword, number and bool values are metadata and are left out. The top Size buckets are returned, most documents first.
+	AggTerms
+	// AggHistogram counts the matching documents per Interval wide range of the numeric Field. When Field is empty the value of a document is the number of times Term occurs in its text, as for AggTerms, or its number of tokens when Term is empty as well.
+	AggHistogram
+)
+
+// defaultAggSize is the number of buckets returned by a terms aggregation without a Size
+const defaultAggSize = 10
+
+// Aggregation describes a summary computed over the documents matching a query
+type Aggregation struct {
+	Name     string
+	Type     AggType
+	Field    string
+	Term     string
+	Size     int
+	Interval float64
+}
+
+// Bucket is a group of matching documents. Key is the field value or token for terms and the lower bound of the range for histograms, which is also held in From.
+type Bucket struct {
+	Key   string
+	From  float64
+	Count int
+}
+
+// AggResult is the result of a single aggregation. Count is the number of documents the aggregation saw a value for.
+type AggResult struct {
+	Count   int
+	Buckets []Bucket
+}
+
+// Aggregate runs the query string and computes every aggregation over the matching documents, returning the results by aggregation name. An empty query matches every document. Terms aggregations over the text leave out the tokens of the query itself, so they return the terms co-occurring with it.
+func (d *DB) Aggregate(query string, aggs ...Aggregation) (map[string]AggResult, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Aggregate(query, aggs...)
+}
+
+// Aggregate runs the aggregations against the snapshot, see DB.Aggregate
+func (s *Snapshot) Aggregate(query string, aggs ...Aggregation) (map[string]AggResult, error) {
+	for _, a := range aggs {
+		if err := a.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []docVersion
+	var queryTokens map[string]struct{}
+	if strings.TrimSpace(query) == "" {
+		s.db.mu.RLock()
+		for id := range s.db.data {
+			if v, exists := s.lookup(id); exists {
+				matches = append(matches, v)
+			}
+		}
+		s.db.mu.RUnlock()
+	} else {
+		docs, err := s.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		s.db.mu.RLock()
+		for _, doc := range docs {
+			if v, exists := s.lookup(doc.ID); exists {
+				matches = append(matches, v)
+			}
+		}
+		s.db.mu.RUnlock()
+
+		queryTokens = make(map[string]struct{})
+		for _, t := range s.db.queryTokens(query) {
+			queryTokens[t] = struct{}{}
+		}
+	}
+
+	res := make(map[string]AggResult, len(aggs))
+	for _, a := range aggs {
+		switch a.Type {
+		case AggCount:
+			res[a.Name] = AggResult{Count: len(matches)}
+		case AggTerms:
+			res[a.Name] = s.aggregateTerms(a, matches, queryTokens)
+		case AggHistogram:
+			res[a.Name] = s.aggregateHistogram(a, matches)
+		}
+	}
+	return res, nil
+}
+
+// validate checks the aggregation settings
+func (a Aggregation) validate() error {
+	switch a.Type {
+	case AggCount, AggTerms:
+	case AggHistogram:
+		if !(a.Interval > 0) || math.IsInf(a.Interval, 1) {
+			return fmt.Errorf("aggregate %s: histogram interval must be positive, got %v", a.Name, a.Interval)
+		}
+	default:
+		return fmt.Errorf("aggregate %s: unknown aggregation type %d", a.Name, a.Type)
+	}
+	return nil
+}
+
+// aggregateTerms counts documents per field value or text token from the postings of the index, a posting counts when it refers to the version of a matching document. The text tokens of a JSON document are the values of its field terms under the paths holding text.
+func (s *Snapshot) aggregateTerms(a Aggregation, matches []docVersion, exclude map[string]struct{}) AggResult {
+	byID := make(map[int]docVersion, len(matches))
+	for _, v := range matches {
+		byID[v.doc.ID] = v
+	}
+
+	type docKey struct {
+		key string
+		id  int
+	}
+	counts := make(map[string]int)
+	seen := make(map[docKey]struct{})
+	counted := make(map[int]struct{})
+	s.db.mu.RLock()
+	for t, list := range s.db.index {
+		key, path := t, ""
+		if a.Field != "" {
+			var found bool
+			// a path with a space is the prefix of the terms of a longer path, values never hold one
+			if key, found = strings.CutPrefix(t, a.Field+fieldSep); !found || isFieldTerm(key) {
+				continue
+			}
+		} else {
+			if i := strings.LastIndex(t, fieldSep); i >= 0 {
+				path, key = t[:i], t[i+len(fieldSep):]
+			}
+			if _, excluded := exclude[key]; excluded {
+				continue
+			}
+		}
+
+		for _, p := range list {
+			v, exists := byID[p.id]
+			if !exists || v.created != p.created || a.Field == "" && !textTerm(v, path) {
+				continue
+			}
+			// several text fields of a JSON document may hold the same token
+			if path != "" {
+				if _, exists := seen[docKey{key, p.id}]; exists {
+					continue
+				}
+				seen[docKey{key, p.id}] = struct{}{}
+			}
+			counts[key]++
+			counted[p.id] = struct{}{}
+		}
+	}
+	s.db.mu.RUnlock()
+
+	res := AggResult{Count: len(counted)}
+	for k, n := range counts {
+		res.Buckets = append(res.Buckets, Bucket{Key: k, Count: n})
+	}
+	sort.Slice(res.Buckets, func(i, j int) bool {
+		if res.Buckets[i].Count != res.Buckets[j].Count {
+			return res.Buckets[i].Count > res.Buckets[j].Count
+		}
+		return res.Buckets[i].Key < res.Buckets[j].Key
+	})
+	size := a.Size
+	if size <= 0 {
+		size = defaultAggSize
+	}
+	if len(res.Buckets) > size {
+		res.Buckets = res.Buckets[:size]
+	}
+	return res
+}
+
+// textTerm reports whether the terms under path are text tokens of the document version. Plain tokens, with an empty path, are the text of a plain document only, the text of a JSON document also holds its keyword values.
+func textTerm(v docVersion, path string) bool {
+	if path == "" {
+		return v.fields == nil
+	}
+	for _, fv := range v.fields[path] {
+		if fv.Type == FieldText {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateText returns the text the histogram aggregations tokenize for a document version, the text values of the fields of a JSON document
+func aggregateText(v docVersion) string {
+	if v.fields == nil {
+		return v.doc.Text
+	}
+	var text []string
+	for _, path := range v.fields.Paths() {
+		for _, fv := range v.fields[path] {
+			if fv.Type == FieldText {
+				text = append(text, fv.String)
+			}
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// aggregateHistogram counts documents per range of a numeric value. A document with several values of the field is counted once in every range it has a value in. The index keeps neither term frequencies nor document lengths, so the text histograms re-analyze the text of the documents they count, but a term histogram only does so for the documents the postings of the term point to and counts the others as zero.
+func (s *Snapshot) aggregateHistogram(a Aggregation, matches []docVersion) AggResult {
+	var term string
+	if a.Field == "" && a.Term != "" {
+		if tokens := s.db.docTokens(a.Term); len(tokens) > 0 {
+			term = tokens[0]
+		}
+	}
+
+	// only the documents with a posting of the term can hold it
+	var holding map[int]struct{}
+	if a.Field == "" && a.Term != "" {
+		holding = make(map[int]struct{})
+		s.db.mu.RLock()
+		for _, p := range s.db.index[term] {
+			holding[p.id] = struct{}{}
+		}
+		s.db.mu.RUnlock()
+	}
+
+	counts := make(map[float64]int)
+	var res AggResult
+	for _, v := range matches {
+		var values []float64
+		if a.Field == "" {
+			var n int
+			if a.Term == "" {
+				n = len(s.db.docTokens(aggregateText(v)))
+			} else if _, exists := holding[v.doc.ID]; exists {
+				for _, t := range s.db.docTokens(aggregateText(v)) {
+					if t == term {
+						n++
+					}
+				}
+			}
+			values = append(values, float64(n))
+		} else {
+			for _, fv := range v.fields[a.Field] {
+				if fv.Type == FieldNumber {
+					values = append(values, fv.Number)
+				}
+			}
+		}
+		if len(values) > 0 {
+			res.Count++
+		}
+
+		seen := make(map[float64]struct{}, len(values))
+		for _, x := range values {
+			from := math.Floor(x/a.Interval) * a.Interval
+			if _, exists := seen[from]; !exists {
+				seen[from] = struct{}{}
+				counts[from]++
+			}
+		}
+	}
+
+	for from, n := range counts {
+		res.Buckets = append(res.Buckets, Bucket{Key: strconv.FormatFloat(from, 'g', -1, 64), From: from, Count: n})
+	}
+	sort.Slice(res.Buckets, func(i, j int) bool { return res.Buckets[i].From < res.Buckets[j].From })
+	return res
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	db := NewDB()
+	docs := []string{
+		`{"chapter": 1, "source": "alice.txt", "text": "alice follows the white rabbit"}`,
+		`{"chapter": 1, "source": "alice.txt", "text": "the rabbit is late, the rabbit is very late"}`,
+		`{"chapter": 2, "source": "alice.txt", "text": "alice grows and the rabbit runs"}`,
+		`{"chapter": 12, "source": "glass.txt", "text": "alice meets the queen"}`,
+	}
+	for i, src := range docs {
+		if err := db.IndexJSON(i, []byte(src)); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", i, err)
+		}
+	}
+	db.Index(Document{ID: 4, Text: "a rabbit without a chapter"})
+
+	res, err := db.Aggregate("rabbit",
+		Aggregation{Name: "count", Type: AggCount},
+		Aggregation{Name: "cooccurring", Type: AggTerms, Size: 3},
+		Aggregation{Name: "sources", Type: AggTerms, Field: "source"},
+		Aggregation{Name: "chapters", Type: AggHistogram, Field: "chapter", Interval: 10},
+		Aggregation{Name: "tf", Type: AggHistogram, Term: "Rabbit", Interval: 1},
+	)
+	if err != nil {
+		t.Fatalf("Failed to aggregate, %v", err)
+	}
+
+	testData := []struct {
+		name     string
+		count    int
+		expected []Bucket
+	}{
+		{"count", 4, nil},
+		{"cooccurring", 4, []Bucket{{Key: "the", Count: 3}, {Key: "alice", Count: 2}, {Key: "a", Count: 1}}},
+		{"sources", 3, []Bucket{{Key: "alice.txt", Count: 3}}},
+		{"chapters", 3, []Bucket{{Key: "0", From: 0, Count: 3}}},
+		{"tf", 4, []Bucket{{Key: "1", From: 1, Count: 3}, {Key: "2", From: 2, Count: 1}}},
+	}
+	for _, d := range testData {
+		r := res[d.name]
+		if r.Count != d.count || len(r.Buckets) != len(d.expected) {
+			t.Errorf("Expected count %d and buckets %v for %s, but got %v", d.count, d.expected, d.name, r)
+			continue
+		}
+		for i := range r.Buckets {
+			if r.Buckets[i] != d.expected[i] {
+				t.Errorf("Expected buckets %v for %s, but got %v", d.expected, d.name, r.Buckets)
+			}
+		}
+	}
+
+	// an empty query aggregates over every document
+	res, _ = db.Aggregate("", Aggregation{Name: "chapters", Type: AggHistogram, Field: "chapter", Interval: 5}, Aggregation{Name: "count", Type: AggCount})
+	if r := res["chapters"]; len(r.Buckets) != 2 || r.Buckets[1] != (Bucket{Key: "10", From: 10, Count: 1}) {
+		t.Errorf("Expected 2 chapter buckets, but got %v", r)
+	}
+	if res["count"].Count != 5 {
+		t.Errorf("Expected 5 documents, but got %v", res["count"])
+	}
+
+	// a token in several text fields of a document counts once, postings of replaced versions not at all
+	db.Index(Document{ID: 5, Source: `{"title": "the queen", "text": "the queen rules"}`})
+	db.Delete(3)
+	db.IndexJSON(3, []byte(`{"text": "queen alice"}`))
+	res, _ = db.Aggregate("queen", Aggregation{Name: "cooccurring", Type: AggTerms})
+	expected := []Bucket{{Key: "alice", Count: 1}, {Key: "rules", Count: 1}, {Key: "the", Count: 1}}
+	if r := res["cooccurring"]; r.Count != 2 || len(r.Buckets) != len(expected) || r.Buckets[0] != expected[0] || r.Buckets[1] != expected[1] || r.Buckets[2] != expected[2] {
+		t.Errorf("Expected count 2 and buckets %v, but got %v", expected, r)
+	}
+
+	invalid := []Aggregation{{Name: "h", Type: AggHistogram}, {Name: "x"}}
+	for _, a := range invalid {
+		if _, err := db.Aggregate("rabbit", a); err == nil {
+			t.Errorf("Should have returned an error for %v", a)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TokenFilter rewrites a stream of tokens. A filter may transform, drop or add tokens and receives them in the order they appear in the text.
+type TokenFilter func(tokens []string) []string
+
+// Analyzer breaks text into tokens with its Tokenizer and then passes the tokens through each of its Filters in order
+type Analyzer struct {
+	Tokenizer func(text string) []string
+	Filters   []TokenFilter
+}
+
+// WhitespaceAnalyzer splits on whitespace and lowercases each token, like the analyze function of the exercise.
+var WhitespaceAnalyzer = Analyzer{
+	Tokenizer: strings.Fields,
+	Filters:   []TokenFilter{MapFilter(strings.ToLower)},
+}
+
+// NewUnicodeAnalyzer creates an analyzer that splits text on Unicode word boundaries, normalizes tokens to NFC and applies full case folding. When foldAccents is set accents are also stripped so that "café" and "cafe" produce the same token.
+func NewUnicodeAnalyzer(foldAccents bool) Analyzer {
+	a := Analyzer{
+		Tokenizer: UnicodeTokenize,
+		Filters:   []TokenFilter{MapFilter(NFC), MapFilter(FoldCase)},
+	}
+	if foldAccents {
+		a.Filters = append(a.Filters, MapFilter(FoldAccents))
+	}
+	return a
+}
+
+// Tokens returns the analyzed tokens in the order they appear in the text, keeping duplicates
+func (a Analyzer) Tokens(text string) []string {
+	tokens := a.Tokenizer(text)
+	for _, f := range a.Filters {
+		tokens = f(tokens)
+	}
+	return textTokens(tokens)
+}
+
+// textTokens drops the tokens no text term may be. Filters may blank out tokens, and a custom tokenizer or filter may emit a token holding fieldSep, e.g. "new york", which would read as a field term. This is where the index relies on plain tokens never containing fieldSep.
+func textTokens(tokens []string) []string {
+	res := tokens[:0]
+	for _, t := range tokens {
+		if t != "" && !strings.Contains(t, fieldSep) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Analyze returns the unique analyzed tokens of the text in sorted lexical order
+func (a Analyzer) Analyze(text string) []string {
+	return uniqueTokens(a.Tokens(text))
+}
+
+// uniqueTokens drops duplicate tokens and sorts the rest in lexical order
+func uniqueTokens(all []string) []string {
+	var tokens []string
+	tokenMap := make(map[string]struct{})
+
+	for _, s := range all {
+		if _, exists := tokenMap[s]; !exists {
+			tokenMap[s] = struct{}{}
+			tokens = append(tokens, s)
+		}
+	}
+
+	sort.Strings(tokens)
+	return tokens
+}
+
+// docTokens returns the tokens of a document's text as they are written to the index, in order and keeping duplicates
+func (d *DB) docTokens(text string) []string {
+	tokens := d.analyzer.Tokens(text)
+	if d.synonyms != nil && d.synonymMode == SynonymsAtIndex {
+		tokens = textTokens(d.synonyms.Filter(tokens))
+	}
+	return tokens
+}
+
+// indexTokens returns the unique tokens of a document's text as they are written to the index
+func (d *DB) indexTokens(text string) []string {
+	return uniqueTokens(d.docTokens(text))
+}
+
+// queryTokens returns the unique tokens of a query string as they are looked up in the index
+func (d *DB) queryTokens(text string) []string {
+	tokens := d.analyzer.Tokens(text)
+	if d.synonyms != nil && d.synonymMode == SynonymsAtQuery {
+		tokens = textTokens(d.synonyms.Filter(tokens))
+	}
+	return uniqueTokens(tokens)
+}
+
+// MapFilter creates a TokenFilter that applies f to every token
+func MapFilter(f func(string) string) TokenFilter {
+	return func(tokens []string) []string {
+		for i, t := range tokens {
+			tokens[i] = f(t)
+		}
+		return tokens
+	}
+}
+
+// wordClass is the word break property of a rune, loosely following UAX #29
+type wordClass int
+
+const (
+	wbOther wordClass = iota
+	wbLetter
+	wbNumeric
+	wbKatakana
+	wbIdeographic
+	wbExtend
+	wbExtendNumLet
+	wbMidLetter
+	wbMidNum
+	wbMidNumLet
+)
+
+// classifyRune returns the word break class of r
+func classifyRune(r rune) wordClass {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Mc, unicode.Me), r == '\u200d':
+		return wbExtend
+	case unicode.Is(unicode.Katakana, r), r == '\u30fc':
+		return wbKatakana
+	case unicode.In(r, unicode.Han, unicode.Hiragana):
+		// ideographs and kana carry no spacing so each one is treated as its own word
+		return wbIdeographic
+	case unicode.IsLetter(r):
+		return wbLetter
+	case unicode.Is(unicode.Nd, r):
+		return wbNumeric
+	case unicode.Is(unicode.Pc, r):
+		return wbExtendNumLet
+	}
+
+	switch r {
+	case '\'', '.', '\u2018', '\u2019', '\u2024', '\ufe52', '\uff07', '\uff0e':
+		return wbMidNumLet
+	case '\u00b7', '\u0387', '\u05f4', '\u2027':
+		return wbMidLetter
+	case ',', ';', '\u037e', '\u0589', '\u060c', '\u060d', '\u066c', '\u07f8', '\u2044', '\ufe10', '\ufe14', '\ufe50', '\ufe54', '\uff0c', '\uff1b':
+		return wbMidNum
+	}
+	return wbOther
+}
+
+// UnicodeTokenize splits text into words using the word boundary rules of UAX #29. Letters and digits form words, apostrophes and periods are kept inside words ("don't", "3.14"), combining marks stay attached to their base letter and CJK ideographs become one token per character. Punctuation, symbols and whitespace are dropped.
+func UnicodeTokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var hasAlnum bool
+	last := wbOther
+
+	flush := func() {
+		if hasAlnum {
+			tokens = append(tokens, string(word))
+		}
+		word = word[:0]
+		hasAlnum = false
+		last = wbOther
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		class := classifyRune(r)
+
+		switch class {
+		case wbExtend:
+			// marks never start a word, they extend whatever precedes them
+			if len(word) > 0 {
+				word = append(word, r)
+			}
+			continue
+		case wbLetter, wbNumeric:
+			if last != wbLetter && last != wbNumeric && last != wbExtendNumLet {
+				flush()
+			}
+			hasAlnum = true
+		case wbKatakana:
+			if last != wbKatakana && last != wbExtendNumLet {
+				flush()
+			}
+			hasAlnum = true
+		case wbIdeographic:
+			flush()
+			hasAlnum = true
+		case wbExtendNumLet:
+			// underscores join letters, digits and katakana
+		case wbMidLetter, wbMidNum, wbMidNumLet:
+			if joinsWord(last, class, nextClass(runes, i+1)) {
+				word = append(word, r)
+				continue
+			}
+			flush()
+			continue
+		default:
+			flush()
+			continue
+		}
+
+		word = append(word, r)
+		last = class
+	}
+	flush()
+
+	return tokens
+}
+
+// nextClass returns the word break class of the first rune at or after i that is not a combining mark
+func nextClass(runes []rune, i int) wordClass {
+	for ; i < len(runes); i++ {
+		if c := classifyRune(runes[i]); c != wbExtend {
+			return c
+		}
+	}
+	return wbOther
+}
+
+// joinsWord reports whether a mid word punctuation rune of class mid should be kept inside a word when it sits between runes of class prev and next
+func joinsWord(prev, mid, next wordClass) bool {
+	switch {
+	case prev == wbLetter && next == wbLetter:
+		return mid == wbMidLetter || mid == wbMidNumLet
+	case prev == wbNumeric && next == wbNumeric:
+		return mid == wbMidNum || mid == wbMidNumLet
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnicodeTokenize(t *testing.T) {
+	testData := []struct {
+		text     string
+		expected []string
+	}{
+		{"hello my name is blargh", []string{"hello", "my", "name", "is", "blargh"}},
+		{"what is going    on?", []string{"what", "is", "going", "on"}},
+		{"“Curiouser and curiouser!” cried Alice", []string{"Curiouser", "and", "curiouser", "cried", "Alice"}},
+		{"one—two–three", []string{"one", "two", "three"}},
+		{"don't can’t 'quoted'", []string{"don't", "can’t", "quoted"}},
+		{"pi is 3.14, not 1,000.5!", []string{"pi", "is", "3.14", "not", "1,000.5"}},
+		{"snake_case and abc123", []string{"snake_case", "and", "abc123"}},
+		{"我爱北京", []string{"我", "爱", "北", "京"}},
+		{"コンピュータを使う", []string{"コンピュータ", "を", "使", "う"}},
+		{"한국어 텍스트", []string{"한국어", "텍스트"}},
+		{"Привет, мир", []string{"Привет", "мир"}},
+		{"café crème", []string{"café", "crème"}},
+		{"... --- !!!", nil},
+	}
+
+	for _, d := range testData {
+		if res := UnicodeTokenize(d.text); !reflect.DeepEqual(res, d.expected) {
+			t.Errorf("Expected tokens %q for %q, but got %q", d.expected, d.text, res)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	testData := []struct {
+		name     string
+		f        func(string) string
+		in       string
+		expected string
+	}{
+		{"NFC", NFC, "cafe\u0301", "caf\u00e9"},
+		{"NFC", NFC, "Tie\u0302\u0301ng Vie\u0323\u0302t", "Ti\u1ebfng Vi\u1ec7t"},
+		{"NFC", NFC, "Vie\u0302\u0323t", "Vi\u1ec7t"},
+		{"NFC", NFC, "a\u0328\u0301", "\u0105\u0301"},
+		{"NFD", NFD, "Vi\u1ec7t", "Vie\u0323\u0302t"},
+		{"NFKD", NFKD, "ﬁne ＡＢＣ", "fine ABC"},
+		{"FoldCase", FoldCase, "Straße", "strasse"},
+		{"FoldCase", FoldCase, "ΣΊΣΥΦΟΣ σίσυφος", "σίσυφοσ σίσυφοσ"},
+		{"FoldCase", FoldCase, "ÉCOLE", "école"},
+		{"FoldAccents", FoldAccents, "Élève naïve", "Eleve naive"},
+		{"FoldAccents", FoldAccents, "Łódź Ørsted", "Lodz Orsted"},
+		{"FoldAccents", FoldAccents, "Nguyễn", "Nguyen"},
+	}
+
+	for _, d := range testData {
+		if res := d.f(d.in); res != d.expected {
+			t.Errorf("Expected %s(%q) to be %q, but got %q", d.name, d.in, d.expected, res)
+		}
+	}
+}
+
+func TestUnicodeAnalyzer(t *testing.T) {
+	testData := []struct {
+		analyzer Analyzer
+		text     string
+		expected []string
+	}{
+		{WhitespaceAnalyzer, "What what whaT", []string{"what"}},
+		{WhitespaceAnalyzer, "hey hey hey!!!", []string{"hey", "hey!!!"}},
+		{NewUnicodeAnalyzer(false), "hey hey hey!!!", []string{"hey"}},
+		{NewUnicodeAnalyzer(false), "Cafe\u0301 CAFÉ café", []string{"café"}},
+		{NewUnicodeAnalyzer(false), "Die Straße, die STRASSE", []string{"die", "strasse"}},
+		{NewUnicodeAnalyzer(false), "café cafe", []string{"cafe", "café"}},
+		{NewUnicodeAnalyzer(true), "café cafe CAFÉ", []string{"cafe"}},
+		{NewUnicodeAnalyzer(true), "Αλίκη ΑΛΊΚΗ", []string{"αλικη"}},
+		{NewUnicodeAnalyzer(true), "東京タワー", []string{"タワー", "京", "東"}},
+	}
+
+	for _, d := range testData {
+		if res := d.analyzer.Analyze(d.text); !reflect.DeepEqual(res, d.expected) {
+			t.Errorf("Expected tokens %q for %q, but got %q", d.expected, d.text, res)
+		}
+	}
+}
+
+func TestQueryNonEnglish(t *testing.T) {
+	db := NewDB(WithAnalyzer(NewUnicodeAnalyzer(true)))
+	docs := []Document{
+		{ID: 0, Text: "Alice était assise près de sa sœur"},
+		{ID: 1, Text: "“Qui êtes-vous ?” demanda la Chenille"},
+		{ID: 2, Text: "爱丽丝梦游仙境"},
+		{ID: 3, Text: "Алиса в Стране чудес"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	testData := []struct {
+		query    string
+		expected int
+	}{
+		{"etait", 0},
+		{"ÉTAIT", 0},
+		{"chenille", 1},
+		{"vous", 1},
+		{"仙", 2},
+		{"алиса", 3},
+	}
+
+	for _, d := range testData {
+		res, err := db.Query(d.query)
+		if err != nil {
+			t.Errorf("Got an error while querying %s, %v", d.query, err)
+			continue
+		}
+		if len(res) != 1 || res[0].ID != d.expected {
+			t.Errorf("Expected doc ID %d for query %s, but got %v", d.expected, d.query, res)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// opError is the error of an invalid operation of a commit. Its message is the one of the underlying error so single operation writes read as before.
+type opError struct {
+	index int
+	err   error
+}
+
+func (e *opError) Error() string {
+	return e.err.Error()
+}
+
+func (e *opError) Unwrap() error {
+	return e.err
+}
+
+// BatchError is returned by Batch.Commit when an operation of the batch is invalid. Op is the position of the operation in the batch, none of the operations were applied.
+type BatchError struct {
+	Op  int
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch: operation %d: %v", e.Op, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batch stages Index and Delete operations that are validated together and committed atomically: readers see either none or all of them, at a single version. Operations are validated in order, so a batch may delete a document and index a new version of it. A Batch is not safe for concurrent use.
+type Batch struct {
+	db    *DB
+	ops   []Op
+	added []int
+	ids   []int
+}
+
+// NewBatch creates an empty batch for the db
+func (d *DB) NewBatch() *Batch {
+	return &Batch{db: d}
+}
+
+// Index stages indexing the document
+func (b *Batch) Index(doc Document) {
+	b.ops = append(b.ops, Op{Type: OpIndex, Doc: doc})
+}
+
+// Add stages indexing the document under a doc ID assigned at commit time, see DB.Add. The assigned IDs are returned by IDs after the commit.
+func (b *Batch) Add(doc Document) {
+	b.added = append(b.added, len(b.ops))
+	b.ops = append(b.ops, Op{Type: OpIndex, Doc: doc, assign: true})
+}
+
+// IDs returns the doc IDs assigned to the documents staged with Add, in staging order, by the last successful commit
+func (b *Batch) IDs() []int {
+	return b.ids
+}
+
+// IndexJSON stages indexing a JSON object, see DB.IndexJSON. An error is returned and nothing is staged if src is not a valid JSON object.
+func (b *Batch) IndexJSON(id int, src []byte) error {
+	doc, _, err := ParseJSON(id, src)
+	if err != nil {
+		return fmt.Errorf("index json: %v", err)
+	}
+	b.Index(doc)
+	return nil
+}
+
+// Delete stages removing the document with the specified doc ID
+func (b *Batch) Delete(id int) {
+	b.ops = append(b.ops, Op{Type: OpDelete, ID: id})
+}
+
+// Len returns the number of staged operations
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset drops every staged operation
+func (b *Batch) Reset() {
+	b.ops = nil
+	b.added = nil
+}
+
+// Commit validates the staged operations against the db and applies all of them at a single new version. The batch is emptied on success. When an operation is invalid nothing is applied, a *BatchError naming it is returned and the operations stay staged.
+func (b *Batch) Commit() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if err := b.db.commit(b.ops); err != nil {
+		var oe *opError
+		if errors.As(err, &oe) {
+			return &BatchError{Op: oe.index, Err: oe.err}
+		}
+		return err
+	}
+	b.ids = make([]int, len(b.added))
+	for i, pos := range b.added {
+		b.ids[i] = b.ops[pos].Doc.ID
+	}
+	// the committed operations are kept by the write log, so the slice is not reused
+	b.Reset()
+	return nil
+}
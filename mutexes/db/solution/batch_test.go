@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	db := NewDB(WithWriteLog(10))
+	db.Index(Document{ID: 0, Text: "the queen of hearts"})
+
+	b := db.NewBatch()
+	b.Index(Document{ID: 1, Text: "the knave of hearts"})
+	b.Delete(0)
+	b.Index(Document{ID: 0, Text: "the queen of hearts rewritten"})
+	b.Index(Document{ID: 1, Text: "a duplicate in the same batch"})
+	b.Index(Document{ID: 2, Text: "never indexed"})
+
+	err := b.Commit()
+	var be *BatchError
+	if !errors.As(err, &be) || be.Op != 3 {
+		t.Fatalf("Expected the duplicate at operation 3 to fail the batch, but got %v", err)
+	}
+	if res, _ := db.Query("hearts knave indexed"); len(res) != 1 || res[0].ID != 0 {
+		t.Errorf("Expected nothing of the batch to be applied, but got %v", res)
+	}
+	if len(db.index["knave"]) != 0 {
+		t.Errorf("Expected no postings of the failed batch, but got %v", db.index["knave"])
+	}
+	if b.Len() != 5 {
+		t.Errorf("Expected the operations to stay staged, but got %d", b.Len())
+	}
+
+	b.Reset()
+	b.Index(Document{ID: 1, Text: "the knave of hearts"})
+	b.Delete(0)
+	b.Index(Document{ID: 0, Text: "the queen of hearts rewritten"})
+	if err := b.IndexJSON(2, []byte(`{"title": "the king of hearts"}`)); err != nil {
+		t.Fatalf("Failed to stage json, %v", err)
+	}
+	if err := b.IndexJSON(3, []byte(`[]`)); err == nil {
+		t.Error("Should have returned an error staging invalid json")
+	}
+
+	s := db.Snapshot()
+	defer s.Release()
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Failed to commit, %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Expected an empty batch after the commit, but got %d", b.Len())
+	}
+	if err := b.Commit(); err != nil {
+		t.Errorf("Expected an empty commit to succeed, but got %v", err)
+	}
+
+	// the whole batch is a single version
+	if db.Snapshot().Version() != s.Version()+1 {
+		t.Errorf("Expected the batch at version %d", s.Version()+1)
+	}
+	if res, _ := s.Query("hearts"); len(res) != 1 {
+		t.Errorf("Expected the earlier snapshot to see none of the batch, but got %v", res)
+	}
+	if res, _ := db.Query("hearts"); len(res) != 3 {
+		t.Errorf("Expected 3 documents, but got %v", res)
+	}
+	if entries, _, _ := db.log.since(s.Version()); len(entries) != 1 || len(entries[0].Ops) != 4 {
+		t.Errorf("Expected a single log entry with 4 operations, but got %v", entries)
+	}
+
+	// single operations keep their errors
+	if err := db.Index(Document{ID: 1}); err == nil || err.Error() != "Document id 1 already present in db" {
+		t.Errorf("Expected the duplicate error, but got %v", err)
+	}
+}
+
+func TestBatchConcurrent(t *testing.T) {
+	db := NewDB()
+	numBatches, batchSize := 8, 50
+
+	var wg sync.WaitGroup
+	wg.Add(numBatches)
+	for i := 0; i < numBatches; i++ {
+		go func(i int) {
+			defer wg.Done()
+			b := db.NewBatch()
+			for j := 0; j < batchSize; j++ {
+				b.Index(Document{ID: i*batchSize + j, Text: "common"})
+			}
+			b.Commit()
+		}(i)
+	}
+
+	// a reader only ever sees whole batches
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if res, _ := db.Query("common"); len(res)%batchSize != 0 {
+				t.Errorf("Expected whole batches, but got %d documents", len(res))
+			}
+		}
+	}()
+	wg.Wait()
+	<-done
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// benchSizes are the corpus sizes the benchmarks run at
+var benchSizes = []int{1000, 10000, 100000}
+
+var (
+	benchMu  sync.Mutex
+	benchDBs = make(map[int]*DB)
+)
+
+// benchDB returns a db holding a generated corpus of n documents. It is shared between benchmarks and must not be written to.
+func benchDB(b *testing.B, n int) *DB {
+	b.Helper()
+	benchMu.Lock()
+	defer benchMu.Unlock()
+	if db, ok := benchDBs[n]; ok {
+		return db
+	}
+	db := NewDB()
+	bt := db.NewBatch()
+	for _, doc := range NewCorpus(CorpusOptions{Docs: n}).Docs() {
+		bt.Index(doc)
+	}
+	if err := bt.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	benchDBs[n] = db
+	return db
+}
+
+func BenchmarkIndex(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("docs=%d", n), func(b *testing.B) {
+			db := NewDB()
+			c := NewCorpus(CorpusOptions{Docs: n})
+			bt := db.NewBatch()
+			for _, doc := range c.Docs() {
+				bt.Index(doc)
+			}
+			if err := bt.Commit(); err != nil {
+				b.Fatal(err)
+			}
+			docs := make([]Document, b.N)
+			for i := range docs {
+				docs[i] = Document{ID: n + i, Text: c.Text()}
+			}
+			b.ResetTimer()
+			for _, doc := range docs {
+				if err := db.Index(doc); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndexBatch(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("docs=%d", n), func(b *testing.B) {
+			docs := NewCorpus(CorpusOptions{Docs: n}).Docs()
+			b.ReportAllocs()
+			for b.Loop() {
+				db := NewDB()
+				bt := db.NewBatch()
+				for _, doc := range docs {
+					bt.Index(doc)
+				}
+				if err := bt.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(n*b.N)/b.Elapsed().Seconds(), "docs/s")
+		})
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	for _, n := range benchSizes {
+		for _, words := range []int{1, 3} {
+			b.Run(fmt.Sprintf("docs=%d/words=%d", n, words), func(b *testing.B) {
+				db := benchDB(b, n)
+				c := NewCorpus(CorpusOptions{Seed: 2})
+				queries := make([]string, 1024)
+				for i := range queries {
+					queries[i] = c.Query(words)
+				}
+				b.ReportAllocs()
+				var i int
+				for b.Loop() {
+					if _, err := db.Query(queries[i%len(queries)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("docs=%d", n), func(b *testing.B) {
+			db := benchDB(b, n)
+			b.ReportAllocs()
+			var i int
+			for b.Loop() {
+				if _, err := db.Get(i * 7919 % n); err != nil {
+					b.Fatal(err)
+				}
+				i++
+			}
+		})
+	}
+}
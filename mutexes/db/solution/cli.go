@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// usage describes the subcommands accepted by run
+const usage = `usage:
+  solution                        index alice-in-wonderland.txt and query it
+  solution import -o SEG FILE     import FILE into the segment file SEG and print the number of records imported
+  solution query [flags] FILE Q   load FILE, or open it in place when it is a .seg segment, and print the documents matching Q
+  solution export [flags] FILE    load FILE and write every document to stdout or -o
+  solution stats [flags] FILE     load FILE and write word statistics of its documents to stdout as CSV
+  solution gen [flags]            write a synthetic corpus to stdout, one document per line
+  solution load [flags]           run a mixed read and write workload and print its latencies
+
+Run a subcommand with -h to list its flags.`
+
+// run executes the subcommand in args and writes its output to stdout
+func run(args []string, stdout io.Writer) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch args[0] {
+	case "import":
+		return runImport(ctx, args[1:], stdout)
+	case "query":
+		return runQuery(ctx, args[1:], stdout)
+	case "export":
+		return runExport(ctx, args[1:], stdout)
+	case "stats":
+		return runStats(ctx, args[1:], stdout)
+	case "gen":
+		return runGen(args[1:], stdout)
+	case "load":
+		return runLoad(ctx, args[1:], stdout)
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
+
+// importFlags registers the flags shared by the subcommands that load a file to describe it
+func importFlags(fs *flag.FlagSet) (format *string, opts *ImportOptions) {
+	opts = &ImportOptions{}
+	format = fs.String("format", "", "format of the input file: text, jsonl, csv or segment, guessed from the file extension by default")
+	fs.StringVar(&opts.IDField, "id", "", "field path holding the integer doc ID, the record number is used when empty")
+	fs.StringVar(&opts.TextField, "text", "", "field path to import as the text of plain text documents, records are imported as JSON documents when empty")
+	fs.StringVar(&opts.KeyField, "key", "", "field path holding the external key of a record, no keys are read when empty")
+	fs.StringVar(&opts.ExpiresField, "expires", "", "field path holding the RFC 3339 expiry time of a record, no expiry is read when empty")
+	fs.Func("map", "comma separated CSV column mappings of the form column=path, an empty path drops the column", func(s string) error {
+		opts.Columns = make(map[string]string)
+		for _, m := range strings.Split(s, ",") {
+			col, path, ok := strings.Cut(m, "=")
+			if !ok {
+				return fmt.Errorf("expected column=path, got %q", m)
+			}
+			opts.Columns[col] = path
+		}
+		return nil
+	})
+	return format, opts
+}
+
+// fileFormat returns the format of the file, guessed from its extension when format is empty
+func fileFormat(filename, format string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	case ".csv":
+		return "csv"
+	case ".seg":
+		return "segment"
+	}
+	return "text"
+}
+
+// load imports the file into db according to its format. Text files are imported line by line, keyed by the file name and line number. Every document of a segment written by import is loaded, options do not apply to segments.
+func load(ctx context.Context, db *DB, filename, format string, opts ImportOptions) (int, error) {
+	format = fileFormat(filename, format)
+	if format == "segment" {
+		return loadSegment(ctx, db, filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "jsonl":
+		return db.ImportJSONL(ctx, f, opts)
+	case "csv":
+		return db.ImportCSV(ctx, f, opts)
+	case "text":
+		return db.ImportText(ctx, f, filename, opts)
+	}
+	return 0, fmt.Errorf("unknown format %q", format)
+}
+
+// loadSegment indexes every document of the segment file into db in batches
+func loadSegment(ctx context.Context, db *DB, filename string) (int, error) {
+	seg, err := OpenSegment(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer seg.Close()
+
+	var n int
+	b := db.NewBatch()
+	for doc, err := range seg.Docs() {
+		if err != nil {
+			return n, err
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if b.Index(doc); b.Len() >= defaultImportBatch {
+			if err := b.Commit(); err != nil {
+				return n, err
+			}
+			n += defaultImportBatch
+		}
+	}
+	n += b.Len()
+	return n, b.Commit()
+}
+
+// runImport imports a file into a segment file and optionally queries the imported documents. Documents already in the segment are kept, so an import that stopped early is resumed into the same segment with -skip.
+func runImport(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format, opts := importFlags(fs)
+	fs.IntVar(&opts.Skip, "skip", 0, "number of records to skip, to resume an earlier import")
+	out := fs.String("o", "", "segment file to import into, created if needed")
+	query := fs.String("query", "", "query to run against the imported documents")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import: expected a single file\n%s", usage)
+	}
+	if *out == "" {
+		return fmt.Errorf("import: expected a segment file to import into with -o\n%s", usage)
+	}
+
+	db := NewDB()
+	if _, err := os.Stat(*out); err == nil {
+		if _, err := loadSegment(ctx, db, *out); err != nil {
+			return fmt.Errorf("import: %v", err)
+		}
+	}
+	n, err := load(ctx, db, fs.Arg(0), *format, *opts)
+	// the records committed before an error are written as well, so the import can be resumed
+	if werr := db.WriteSegment(*out); werr != nil {
+		return werr
+	}
+	if err != nil {
+		return fmt.Errorf("%v, resume with -skip %d", err, n)
+	}
+	fmt.Fprintf(stdout, "Imported %d records into %s\n", n-opts.Skip, *out)
+
+	if *query != "" {
+		return printQuery(stdout, db.Query, *query)
+	}
+	return nil
+}
+
+// runQuery queries a file. Segment files are queried in place without loading them.
+func runQuery(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	format, opts := importFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("query: expected a file and a query string\n%s", usage)
+	}
+
+	if fileFormat(fs.Arg(0), *format) == "segment" {
+		seg, err := OpenSegment(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer seg.Close()
+		return printQuery(stdout, seg.Query, fs.Arg(1))
+	}
+	db := NewDB()
+	if _, err := load(ctx, db, fs.Arg(0), *format, *opts); err != nil {
+		return err
+	}
+	return printQuery(stdout, db.Query, fs.Arg(1))
+}
+
+// printQuery runs the query and writes the matching documents
+func printQuery(stdout io.Writer, query func(string) ([]Document, error), term string) error {
+	res, err := query(term)
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		fmt.Fprintf(stdout, "Doc ID: %d with Text: %s\n", r.ID, r.Text)
+	}
+	fmt.Fprintf(stdout, "Found %d documents with query string %s\n", len(res), term)
+	return nil
+}
+
+// runExport loads a file and writes its documents in another format
+func runExport(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	inFormat, in := importFlags(fs)
+	var opts ExportOptions
+	to := fs.String("to", "jsonl", "format to export to: jsonl or csv")
+	out := fs.String("o", "", "output file, stdout when empty")
+	fs.StringVar(&opts.IDField, "id-out", "", `key or column to write the doc ID to, "id" when empty`)
+	fs.StringVar(&opts.TextField, "text-out", "", `key or column to write the text of plain text documents to, "text" when empty`)
+	fs.StringVar(&opts.KeyField, "key-out", "", `key or column to write the external key to, "key" when empty`)
+	fs.StringVar(&opts.ExpiresField, "expires-out", "", `key or column to write the expiry time to, "expires" when empty`)
+	fs.IntVar(&opts.Skip, "skip", 0, "number of documents to skip, to resume an earlier export appending to -o")
+	columns := fs.String("columns", "", "comma separated field paths to export to csv, the text followed by every field when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("export: expected a single file\n%s", usage)
+	}
+	if *columns != "" {
+		opts.Columns = strings.Split(*columns, ",")
+	}
+
+	db := NewDB()
+	if _, err := load(ctx, db, fs.Arg(0), *inFormat, *in); err != nil {
+		return err
+	}
+
+	w := stdout
+	if *out != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if opts.Skip > 0 {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(*out, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var n int
+	var err error
+	switch *to {
+	case "jsonl":
+		n, err = db.ExportJSONL(ctx, w, opts)
+	case "csv":
+		n, err = db.ExportCSV(ctx, w, opts)
+	default:
+		return fmt.Errorf("export: unknown format %q", *to)
+	}
+	if err != nil {
+		return fmt.Errorf("%v, resume with -skip %d", err, n)
+	}
+	return nil
+}
+
+// runStats loads a file and writes one table of its word statistics
+func runStats(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	format, in := importFlags(fs)
+	var opts StatsOptions
+	table := fs.String("table", "words", "table to write: words, bigrams, trigrams or growth")
+	fs.IntVar(&opts.MinCount, "min-count", defaultMinCollocationCount, "number of times an n-gram must occur to be a collocation")
+	fs.IntVar(&opts.Size, "size", 0, "number of top words or collocations to write, all when 0")
+	fs.IntVar(&opts.GrowthStep, "step", defaultGrowthStep, "number of tokens between two points of the growth curve")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("stats: expected a single file\n%s", usage)
+	}
+
+	db := NewDB()
+	if _, err := load(ctx, db, fs.Arg(0), *format, *in); err != nil {
+		return err
+	}
+	switch *table {
+	case "words":
+		return WriteWordsCSV(stdout, db.Stats(opts).Words)
+	case "bigrams":
+		return WriteCollocationsCSV(stdout, db.Stats(opts).Bigrams)
+	case "trigrams":
+		return WriteCollocationsCSV(stdout, db.Stats(opts).Trigrams)
+	case "growth":
+		return WriteGrowthCSV(stdout, db.Stats(opts).Growth)
+	}
+	return fmt.Errorf("stats: unknown table %q", *table)
+}
+
+// corpusFlags registers the flags describing a synthetic corpus
+func corpusFlags(fs *flag.FlagSet) *CorpusOptions {
+	opts := DefaultCorpusOptions
+	fs.IntVar(&opts.Docs, "docs", opts.Docs, "number of documents")
+	fs.IntVar(&opts.Vocabulary, "vocab", opts.Vocabulary, "number of distinct words")
+	fs.IntVar(&opts.MinWords, "min-words", opts.MinWords, "minimum number of words per document")
+	fs.IntVar(&opts.MaxWords, "max-words", opts.MaxWords, "maximum number of words per document")
+	fs.Float64Var(&opts.Skew, "skew", opts.Skew, "exponent of the Zipf distribution of the words, greater than 1")
+	fs.Int64Var(&opts.Seed, "seed", opts.Seed, "seed of the generator")
+	return &opts
+}
+
+// runGen writes a synthetic corpus as a text file that import can read back
+func runGen(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	opts := corpusFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(stdout)
+	c := NewCorpus(*opts)
+	for i := 0; i < c.Options().Docs; i++ {
+		fmt.Fprintln(w, c.Text())
+	}
+	return w.Flush()
+}
+
+// runLoad preloads a synthetic corpus and runs a workload against it
+func runLoad(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	opts := LoadOptions{Corpus: *corpusFlags(fs), Mix: DefaultLoadMix}
+	fs.IntVar(&opts.Workers, "workers", 8, "number of concurrent workers")
+	fs.DurationVar(&opts.Duration, "duration", 10*time.Second, "duration of the run")
+	fs.IntVar(&opts.Ops, "ops", 0, "stop after this many operations, unbounded when 0")
+	fs.IntVar(&opts.QueryWords, "query-words", 3, "maximum number of words per query")
+	fs.Func("mix", "comma separated weights of query,get,index,delete operations, 45,45,8,2 by default", func(s string) error {
+		var m LoadMix
+		if _, err := fmt.Sscanf(s, "%d,%d,%d,%d", &m.Query, &m.Get, &m.Index, &m.Delete); err != nil {
+			return fmt.Errorf("expected four weights, got %q", s)
+		}
+		opts.Mix = m
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := RunLoad(ctx, NewDB(), opts)
+	if err != nil {
+		return err
+	}
+	_, err = report.WriteTo(stdout)
+	return err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// completionCacheSize is the number of top completions cached on every trie node
+const completionCacheSize = 10
+
+// Completion is a term or phrase from the index that starts with the requested prefix. Freq is the number of documents containing it.
+type Completion struct {
+	Text string
+	Freq int
+}
+
+// completionNode is a node of the completion trie. Every node caches the most frequent completions below it so that lookups never have to walk the subtree for common requests.
+type completionNode struct {
+	children map[rune]*completionNode
+	freq     int
+	top      []Completion
+	stale    bool
+}
+
+// completionTrie maps indexed terms and phrases to their document frequency. Nodes whose cache may be out of date after a delete are tracked in stale until they are refreshed.
+type completionTrie struct {
+	root      *completionNode
+	maxPhrase int
+	stale     []*completionNode
+}
+
+// WithCompletion keeps a completion trie of indexed terms up to date for Complete. When maxPhrase is greater than 1 runs of up to maxPhrase consecutive tokens are added as phrases too.
+func WithCompletion(maxPhrase int) Option {
+	return func(d *DB) {
+		d.completions = &completionTrie{
+			root:      &completionNode{},
+			maxPhrase: max(maxPhrase, 1),
+		}
+	}
+}
+
+// add registers one more document containing the text
+func (c *completionTrie) add(text string) {
+	path := []*completionNode{c.root}
+	n := c.root
+	for _, r := range text {
+		child, exists := n.children[r]
+		if !exists {
+			if n.children == nil {
+				n.children = make(map[rune]*completionNode)
+			}
+			child = &completionNode{}
+			n.children[r] = child
+		}
+		n = child
+		path = append(path, n)
+	}
+	n.freq++
+
+	entry := Completion{Text: text, Freq: n.freq}
+	for _, p := range path {
+		p.top = updateTop(p.top, entry)
+	}
+}
+
+// remove registers one less document containing the text. A node whose full cache loses an entry is marked stale since an entry that was not cached may now rank higher.
+func (c *completionTrie) remove(text string) {
+	path := []*completionNode{c.root}
+	n := c.root
+	for _, r := range text {
+		if n = n.children[r]; n == nil {
+			return
+		}
+		path = append(path, n)
+	}
+	if n.freq == 0 {
+		return
+	}
+	n.freq--
+
+	for _, p := range path {
+		for i, e := range p.top {
+			if e.Text != text {
+				continue
+			}
+			if len(p.top) == completionCacheSize && !p.stale {
+				p.stale = true
+				c.stale = append(c.stale, p)
+			}
+			p.top = append(p.top[:i], p.top[i+1:]...)
+			if n.freq > 0 {
+				p.top = updateTop(p.top, Completion{Text: text, Freq: n.freq})
+			}
+			break
+		}
+	}
+}
+
+// refresh rebuilds the cache of every stale node
+func (c *completionTrie) refresh() {
+	for _, n := range c.stale {
+		all := n.walk(nil)
+		sort.Slice(all, func(i, j int) bool { return lessCompletion(all[i], all[j]) })
+		n.top = all[:min(len(all), completionCacheSize)]
+		n.stale = false
+	}
+	c.stale = nil
+}
+
+// walk appends every completion in the subtree of the node to res. text holds the runes leading to the node.
+func (n *completionNode) walk(text []rune) []Completion {
+	var res []Completion
+	if n.freq > 0 {
+		res = append(res, Completion{Text: string(text), Freq: n.freq})
+	}
+	for r, child := range n.children {
+		res = append(res, child.walk(append(text, r))...)
+	}
+	return res
+}
+
+// updateTop inserts or refreshes entry in a top completions list kept sorted by frequency. Entries only move up while documents are added so an entry that drops out of a list does not belong back in it until a delete happens.
+func updateTop(top []Completion, entry Completion) []Completion {
+	i := 0
+	for ; i < len(top); i++ {
+		if top[i].Text == entry.Text {
+			break
+		}
+	}
+	if i == len(top) {
+		if len(top) == completionCacheSize && !lessCompletion(entry, top[len(top)-1]) {
+			return top
+		}
+		top = append(top, entry)
+	}
+	top[i] = entry
+
+	// bubble the entry up to its place
+	for ; i > 0 && lessCompletion(top[i], top[i-1]); i-- {
+		top[i], top[i-1] = top[i-1], top[i]
+	}
+	if len(top) > completionCacheSize {
+		top = top[:completionCacheSize]
+	}
+	return top
+}
+
+// lessCompletion orders completions by descending frequency and then alphabetically
+func lessCompletion(a, b Completion) bool {
+	if a.Freq != b.Freq {
+		return a.Freq > b.Freq
+	}
+	return a.Text < b.Text
+}
+
+// phrases returns the unique terms and phrases of a document's ordered tokens
+func (c *completionTrie) phrases(tokens []string) []string {
+	var res []string
+	seen := make(map[string]struct{})
+	for i := range tokens {
+		for n := 1; n <= c.maxPhrase && i+n <= len(tokens); n++ {
+			text := strings.Join(tokens[i:i+n], " ")
+			if _, exists := seen[text]; !exists {
+				seen[text] = struct{}{}
+				res = append(res, text)
+			}
+		}
+	}
+	return res
+}
+
+// addDocument adds the terms and phrases of a document's ordered tokens
+func (c *completionTrie) addDocument(tokens []string) {
+	for _, p := range c.phrases(tokens) {
+		c.add(p)
+	}
+}
+
+// removeDocument removes the terms and phrases of a document's ordered tokens
+func (c *completionTrie) removeDocument(tokens []string) {
+	for _, p := range c.phrases(tokens) {
+		c.remove(p)
+	}
+}
+
+// complete returns up to n completions of prefix. The cached list on the prefix node answers most lookups, larger requests and stale nodes walk the subtree.
+func (c *completionTrie) complete(prefix string, n int) []Completion {
+	node := c.root
+	for _, r := range prefix {
+		node = node.children[r]
+		if node == nil {
+			return []Completion{}
+		}
+	}
+
+	if !node.stale && (n <= len(node.top) || len(node.top) < completionCacheSize) {
+		res := make([]Completion, min(n, len(node.top)))
+		copy(res, node.top)
+		return res
+	}
+
+	res := node.walk([]rune(prefix))
+	sort.Slice(res, func(i, j int) bool { return lessCompletion(res[i], res[j]) })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// Complete returns up to n indexed terms, or phrases when enabled, starting with prefix ordered by the number of documents containing them. The prefix is normalized with the db analyzer so "ALI" completes to "alice". Completions reflect the latest version rather than a snapshot. An empty slice is returned when completion is not enabled.
+func (d *DB) Complete(prefix string, n int) []Completion {
+	if d.completions == nil || n <= 0 {
+		return []Completion{}
+	}
+
+	tokens := d.analyzer.Tokens(prefix)
+	if len(tokens) == 0 {
+		return []Completion{}
+	}
+	p := strings.Join(tokens, " ")
+	if strings.HasSuffix(prefix, " ") {
+		// the last word is complete, only suggest phrases that continue it
+		p += " "
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.completions.complete(p, n)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	db := NewDB(WithCompletion(2))
+	docs := []Document{
+		{ID: 0, Text: "Alice was beginning to get very tired"},
+		{ID: 1, Text: "Alice in Wonderland"},
+		{ID: 2, Text: "the White Rabbit with pink eyes ran close by Alice"},
+		{ID: 3, Text: "the white rabbit"},
+		{ID: 4, Text: "the Queen of Hearts"},
+		{ID: 5, Text: "all alone"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	testData := []struct {
+		prefix   string
+		n        int
+		expected []Completion
+	}{
+		{"al", 3, []Completion{{"alice", 3}, {"alice in", 1}, {"alice was", 1}}},
+		{"AL", 1, []Completion{{"alice", 3}}},
+		{"white ", 5, []Completion{{"white rabbit", 2}}},
+		{"the w", 5, []Completion{{"the white", 2}}},
+		{"que", 5, []Completion{{"queen", 1}, {"queen of", 1}}},
+		{"xyz", 5, []Completion{}},
+		{"", 5, []Completion{}},
+	}
+
+	for _, d := range testData {
+		res := db.Complete(d.prefix, d.n)
+		if len(res) != len(d.expected) {
+			t.Errorf("Expected %d completions for %q, but got %d, res: %v", len(d.expected), d.prefix, len(res), res)
+			continue
+		}
+		for i, c := range res {
+			if c != d.expected[i] {
+				t.Errorf("Expected completion %v for %q, but got %v", d.expected[i], d.prefix, c)
+			}
+		}
+	}
+
+	if res := NewDB().Complete("al", 5); len(res) != 0 {
+		t.Errorf("Expected no completions when completion is not enabled, but got %v", res)
+	}
+}
+
+func TestCompleteBeyondCache(t *testing.T) {
+	db := NewDB(WithCompletion(1))
+	lines, err := splitTextFile("../alice-in-wonderland.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range lines[0] {
+		if _, err := db.Add(doc); err != nil {
+			t.Fatalf("Failed to add doc %s, %v", doc.Key, err)
+		}
+	}
+
+	cached := db.Complete("s", completionCacheSize)
+	walked := db.Complete("s", completionCacheSize+5)
+	if len(walked) != completionCacheSize+5 {
+		t.Fatalf("Expected %d completions, but got %d", completionCacheSize+5, len(walked))
+	}
+	for i, c := range cached {
+		if walked[i] != c {
+			t.Errorf("Expected cached completion %v to match walked completion %v", c, walked[i])
+		}
+	}
+	for i := 1; i < len(walked); i++ {
+		if walked[i].Freq > walked[i-1].Freq {
+			t.Errorf("Expected completions ordered by frequency, but got %v", walked)
+		}
+	}
+}
+
+func BenchmarkComplete(b *testing.B) {
+	db := NewDB(WithCompletion(2))
+	lines, err := splitTextFile("../alice-in-wonderland.txt", 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, doc := range lines[0] {
+		db.Add(doc)
+	}
+
+	prefixes := []string{"a", "al", "the ", "wh", "rab", "q"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Complete(prefixes[i%len(prefixes)], 5)
+	}
+}
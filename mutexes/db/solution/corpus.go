@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// CorpusOptions describes a synthetic corpus generated by a Corpus
+type CorpusOptions struct {
+	// Docs is the number of documents of the corpus
+	Docs int
+	// Vocabulary is the number of distinct words
+	Vocabulary int
+	// MinWords and MaxWords bound the number of words of a document
+	MinWords, MaxWords int
+	// Skew is the exponent of the Zipf distribution words are drawn from and must be greater than 1. The word of rank k is drawn with a probability proportional to (k+1)^-Skew, so higher values concentrate the text on fewer words.
+	Skew float64
+	// Seed makes the corpus reproducible, the same options always generate the same documents
+	Seed int64
+}
+
+// DefaultCorpusOptions generates short documents with a word distribution close to that of English text
+var DefaultCorpusOptions = CorpusOptions{
+	Docs:       10000,
+	Vocabulary: 20000,
+	MinWords:   5,
+	MaxWords:   20,
+	Skew:       1.1,
+	Seed:       1,
+}
+
+// corpusSyllables are the digits of the bijective base the words of a corpus are spelled in
+var corpusSyllables = [...]string{"ka", "lo", "mi", "ne", "ru", "sa", "ti", "vo", "ze", "pa", "do", "fi", "gu", "ha", "je", "bo"}
+
+// corpusWord spells the word of the rank. Every rank has its own word and frequent words are short.
+func corpusWord(rank int) string {
+	var b strings.Builder
+	for {
+		b.WriteString(corpusSyllables[rank%len(corpusSyllables)])
+		rank /= len(corpusSyllables)
+		if rank == 0 {
+			return b.String()
+		}
+		rank--
+	}
+}
+
+// Corpus generates synthetic documents and queries whose words follow a Zipf distribution, to test the db at sizes beyond the sample text. A Corpus is not safe for concurrent use.
+type Corpus struct {
+	opts CorpusOptions
+	rng  *rand.Rand
+	zipf *rand.Zipf
+}
+
+// NewCorpus creates a corpus generator. Zero options take their value from DefaultCorpusOptions.
+func NewCorpus(opts CorpusOptions) *Corpus {
+	if opts.Docs <= 0 {
+		opts.Docs = DefaultCorpusOptions.Docs
+	}
+	if opts.Vocabulary <= 0 {
+		opts.Vocabulary = DefaultCorpusOptions.Vocabulary
+	}
+	if opts.MinWords <= 0 {
+		opts.MinWords = DefaultCorpusOptions.MinWords
+	}
+	if opts.MaxWords < opts.MinWords {
+		opts.MaxWords = max(opts.MinWords, DefaultCorpusOptions.MaxWords)
+	}
+	if opts.Skew <= 1 {
+		opts.Skew = DefaultCorpusOptions.Skew
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	return &Corpus{
+		opts: opts,
+		rng:  rng,
+		zipf: rand.NewZipf(rng, opts.Skew, 1, uint64(opts.Vocabulary-1)),
+	}
+}
+
+// Options returns the options of the corpus with defaults filled in
+func (c *Corpus) Options() CorpusOptions {
+	return c.opts
+}
+
+// Word draws a word
+func (c *Corpus) Word() string {
+	return corpusWord(int(c.zipf.Uint64()))
+}
+
+// Text draws the text of a document
+func (c *Corpus) Text() string {
+	n := c.opts.MinWords + c.rng.Intn(c.opts.MaxWords-c.opts.MinWords+1)
+	words := make([]string, n)
+	for i := range words {
+		words[i] = c.Word()
+	}
+	return strings.Join(words, " ")
+}
+
+// Query draws a query of up to n words
+func (c *Corpus) Query(n int) string {
+	words := make([]string, 1+c.rng.Intn(max(n, 1)))
+	for i := range words {
+		words[i] = c.Word()
+	}
+	return strings.Join(words, " ")
+}
+
+// Docs generates the documents of the corpus with doc IDs starting at 0
+func (c *Corpus) Docs() []Document {
+	docs := make([]Document, c.opts.Docs)
+	for i := range docs {
+		docs[i] = Document{ID: i, Text: c.Text()}
+	}
+	return docs
+}
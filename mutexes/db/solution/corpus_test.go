@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	opts := CorpusOptions{Docs: 2000, Vocabulary: 5000, MinWords: 3, MaxWords: 9, Skew: 1.2, Seed: 7}
+	docs := NewCorpus(opts).Docs()
+	again := NewCorpus(opts).Docs()
+	if !slices.Equal(docs, again) {
+		t.Error("Expected the same options to generate the same corpus")
+	}
+	opts.Seed++
+	if other := NewCorpus(opts).Docs(); slices.Equal(docs, other) {
+		t.Error("Expected another seed to generate another corpus")
+	}
+
+	counts := make(map[string]int)
+	for i, doc := range docs {
+		if doc.ID != i {
+			t.Errorf("Expected doc ID %d, but got %d", i, doc.ID)
+		}
+		words := strings.Fields(doc.Text)
+		if len(words) < 3 || len(words) > 9 {
+			t.Errorf("Expected 3 to 9 words, but got %d in %q", len(words), doc.Text)
+		}
+		for _, w := range words {
+			counts[w]++
+		}
+	}
+
+	// the most frequent word is the word of rank 0 and the distribution has a long tail
+	if counts[corpusWord(0)] <= counts[corpusWord(1)] || counts[corpusWord(1)] <= counts[corpusWord(10)] {
+		t.Errorf("Expected frequencies decreasing with rank, but got %d, %d and %d", counts[corpusWord(0)], counts[corpusWord(1)], counts[corpusWord(10)])
+	}
+	if len(counts) < 500 {
+		t.Errorf("Expected a long tail of words, but got %d distinct words", len(counts))
+	}
+
+	seen := make(map[string]int)
+	for rank := 0; rank < 20000; rank++ {
+		w := corpusWord(rank)
+		if prev, exists := seen[w]; exists {
+			t.Fatalf("Expected rank %d and %d to spell different words, but both are %q", prev, rank, w)
+		}
+		seen[w] = rank
+	}
+	if tokens := NewUnicodeAnalyzer(false).Tokens(corpusWord(12345)); len(tokens) != 1 || tokens[0] != corpusWord(12345) {
+		t.Errorf("Expected words to be single tokens, but got %q", tokens)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"time"
+)
+
+// WithClock sets the clock the db checks document expiry against. The default is time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(d *DB) {
+		d.now = now
+	}
+}
+
+// expiredAt reports whether the document has an expiry time that is not after t
+func (d Document) expiredAt(t time.Time) bool {
+	return !d.Expires.IsZero() && !t.Before(d.Expires)
+}
+
+// expiry is an entry of the expiry queue, the document version created at version created expires at time at
+type expiry struct {
+	at      time.Time
+	id      int
+	created uint64
+}
+
+// expiryQueue is a min-heap of expiring document versions ordered by expiry time. Entries of versions that were deleted or reclaimed in the meantime are skipped when they are popped.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x any) {
+	*q = append(*q, x.(expiry))
+}
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Expire deletes every document whose expiry time has passed, reclaims them and returns how many documents expired. Reads hide a document from its expiry time on, but until Expire runs the document keeps its doc ID and key, so indexing the same ID or key fails. The deletes are committed like any other write and reach followers through the write log, so Expire must only run on the leader.
+func (d *DB) Expire() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	var ops []Op
+	for len(d.expiries) > 0 && !d.expiries[0].at.After(now) {
+		e := heap.Pop(&d.expiries).(expiry)
+		if v, exists := d.latest(e.id); exists && v.created == e.created {
+			ops = append(ops, Op{Type: OpDelete, ID: e.id})
+		}
+	}
+	if len(ops) == 0 {
+		return 0
+	}
+	// every operation deletes a distinct live document, so the commit cannot fail
+	d.commitLocked(ops)
+	d.vacuum()
+	return len(ops)
+}
+
+// RunExpiry calls Expire every interval until the context is done and returns ctx.Err(). It is meant to run in its own goroutine.
+func (d *DB) RunExpiry(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			d.Expire()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+	db := NewDB(WithClock(clock), WithWriteLog(10))
+
+	docs := []Document{
+		{ID: 1, Text: "log line one", Expires: start.Add(10 * time.Second)},
+		{ID: 2, Text: "log line two"},
+		{ID: 3, Key: "line-three", Text: "log line three", Expires: start.Add(20 * time.Second)},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	s := db.Snapshot()
+	now = start.Add(15 * time.Second)
+
+	testData := []struct {
+		query    string
+		expected []int
+	}{
+		{"log", []int{2, 3}},
+		{"one", nil},
+		{"three", []int{3}},
+	}
+	for _, d := range testData {
+		res, _ := db.Query(d.query)
+		if len(res) != len(d.expected) {
+			t.Errorf("Expected %v for %q, but got %v", d.expected, d.query, res)
+			continue
+		}
+		for i, doc := range res {
+			if doc.ID != d.expected[i] {
+				t.Errorf("Expected %v for %q, but got %v", d.expected, d.query, res)
+			}
+		}
+	}
+	if _, err := db.Get(1); err == nil {
+		t.Error("Should have returned an error for an expired document")
+	}
+	if res, _ := s.Query("one"); len(res) != 1 {
+		t.Errorf("Expected the snapshot to see doc ID 1 as of when it was taken, but got %v", res)
+	}
+
+	// the expired document holds on to its ID until it is removed
+	if err := db.Index(Document{ID: 1, Text: "too early"}); err == nil {
+		t.Error("Should have returned an error indexing the ID of an unexpired document")
+	}
+	if n := db.Expire(); n != 1 {
+		t.Errorf("Expected 1 expired document, but got %d", n)
+	}
+	if n := db.Expire(); n != 0 {
+		t.Errorf("Expected no expired document, but got %d", n)
+	}
+
+	s.Release()
+	db.Vacuum()
+	if _, exists := db.data[1]; exists {
+		t.Errorf("Expected doc ID 1 to be reclaimed, but got %v", db.data[1])
+	}
+	for _, p := range db.index["one"] {
+		t.Errorf("Expected no postings for the expired document, but got %v", p)
+	}
+	if err := db.Index(Document{ID: 1, Text: "log line one again"}); err != nil {
+		t.Errorf("Failed to reuse the ID of an expired document, %v", err)
+	}
+
+	// a follower receives the expiry time and the deletes of expired documents through the log
+	follower := NewDB(WithClock(clock))
+	entries, _, _ := db.log.since(0)
+	for _, e := range entries {
+		if err := follower.commit(e.Ops); err != nil {
+			t.Fatalf("Failed to apply entry %d, %v", e.Version, err)
+		}
+	}
+	compareDBs(t, db, follower)
+	now = start.Add(25 * time.Second)
+	if _, err := follower.GetByKey("line-three"); err == nil {
+		t.Error("Should have returned an error for an expired document on the follower")
+	}
+}
+
+func TestExpireSegment(t *testing.T) {
+	expires := time.Unix(1700000000, 42)
+	now := expires.Add(-time.Hour)
+	db := NewDB(WithClock(func() time.Time { return now }))
+	db.Index(Document{ID: 1, Text: "log line", Expires: expires})
+	db.Index(Document{ID: 2, Text: "log line"})
+
+	path := filepath.Join(t.TempDir(), "expire.seg")
+	if err := db.WriteSegment(path); err != nil {
+		t.Fatalf("Failed to write segment, %v", err)
+	}
+	seg, err := OpenSegment(path)
+	if err != nil {
+		t.Fatalf("Failed to open segment, %v", err)
+	}
+	defer seg.Close()
+	seg.Clock = func() time.Time { return now }
+
+	for _, id := range []int{1, 2} {
+		doc, err := seg.Get(id)
+		expected, _ := db.Get(id)
+		if err != nil || !doc.Expires.Equal(expected.Expires) {
+			t.Errorf("Expected expiry %v for doc ID %d, but got %v, %v", expected.Expires, id, doc, err)
+		}
+	}
+
+	// the segment hides the document once it expired
+	now = expires
+	if _, err := seg.Get(1); err == nil {
+		t.Error("Should have returned an error for an expired document")
+	}
+	if res, _ := seg.Query("log"); len(res) != 1 || res[0].ID != 2 {
+		t.Errorf("Expected only doc ID 2, but got %v", res)
+	}
+	if !seg.Has(1) {
+		t.Error("Expected the segment to still hold the expired document")
+	}
+}
+
+func TestSegmentedDBExpire(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var clock atomic.Int64
+	clock.Store(start.UnixNano())
+	now := func() time.Time { return time.Unix(0, clock.Load()) }
+
+	dir := t.TempDir()
+	l, err := OpenSegmentedDB(dir, 100, WithClock(now))
+	if err != nil {
+		t.Fatalf("Failed to open, %v", err)
+	}
+	expires := start.Add(time.Hour)
+	for id := 1; id <= 3; id++ {
+		l.Index(Document{ID: id, Text: fmt.Sprintf("old version %d", id)})
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Failed to flush, %v", err)
+	}
+
+	// doc ID 1 gets an expiring version in a segment, doc ID 2 in the memtable, doc ID 4 only lives in a segment and expires
+	for _, id := range []int{1, 2} {
+		l.Delete(id)
+		l.Index(Document{ID: id, Text: fmt.Sprintf("new version %d", id), Expires: expires})
+		if id == 1 {
+			l.Index(Document{ID: 4, Text: "new version 4", Expires: expires})
+			if err := l.Flush(); err != nil {
+				t.Fatalf("Failed to flush, %v", err)
+			}
+		}
+	}
+	if res, _ := l.Query("new"); len(res) != 3 {
+		t.Errorf("Expected 3 documents before the expiry, but got %v", res)
+	}
+
+	check := func(stage string) {
+		t.Helper()
+		for _, id := range []int{1, 2, 4} {
+			if doc, err := l.Get(id); err == nil {
+				t.Errorf("Expected doc ID %d to be expired %s, but got %v", id, stage, doc)
+			}
+		}
+		if res, _ := l.Query("version"); len(res) != 1 || res[0].ID != 3 {
+			t.Errorf("Expected only doc ID 3 %s, but got %v", stage, res)
+		}
+	}
+	clock.Store(expires.UnixNano())
+	check("in the memtable and segments")
+
+	// expired memtable documents are flushed as tombstones and merges drop expired documents
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Failed to flush, %v", err)
+	}
+	check("after the flush")
+	l.work.Lock()
+	merged, err := l.mergeParts(l.segments, true)
+	l.work.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to merge, %v", err)
+	}
+	if merged.seg.Has(1) || merged.seg.Has(4) || !merged.seg.Has(3) {
+		t.Error("Expected the merge to drop the expired documents")
+	}
+	merged.seg.Close()
+	removeSegmentFiles(merged.path)
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to close, %v", err)
+	}
+	l, err = OpenSegmentedDB(dir, 100, WithClock(now))
+	if err != nil {
+		t.Fatalf("Failed to reopen, %v", err)
+	}
+	defer l.Close()
+	check("after a restart")
+
+	if err := l.Index(Document{ID: 2, Text: "indexed again"}); err != nil {
+		t.Errorf("Should have accepted the doc ID of an expired document, %v", err)
+	}
+	if doc, err := l.Get(2); err != nil || doc.Text != "indexed again" {
+		t.Errorf("Expected the new document, but got %v, %v", doc, err)
+	}
+}
+
+func TestRunExpiry(t *testing.T) {
+	db := NewDB()
+	db.Index(Document{ID: 1, Text: "short lived", Expires: time.Now().Add(-time.Second)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- db.RunExpiry(ctx, time.Millisecond) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.mu.RLock()
+		_, exists := db.data[1]
+		db.mu.RUnlock()
+		if !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the expired document to be removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected %v, but got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fuzzTexts seed the fuzz targets that take free text
+var fuzzTexts = []string{
+	"",
+	"hello my name is blargh",
+	"what is going    on?",
+	"hey hey hey!!!",
+	"What what whaT",
+	"Alice was beginning to get very tired of sitting by her sister on the bank",
+	"café CAFÉ café Straße STRASSE",
+	"don't 3.14 e-mail foo_bar über-cool",
+	"日本語のテキスト と 中文",
+	"\t\n  ",
+	"\xff\xfe invalid utf-8",
+}
+
+func FuzzIndexQuery(f *testing.F) {
+	for _, text := range fuzzTexts {
+		f.Add(text)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		db := NewDB()
+		if err := db.Index(Document{ID: 1, Text: text}); err != nil {
+			t.Fatalf("Failed to index %q, %v", text, err)
+		}
+		tokens := db.indexTokens(text)
+		for i, tok := range tokens {
+			if tok == "" || strings.IndexFunc(tok, unicode.IsSpace) >= 0 {
+				t.Errorf("Expected tokens without whitespace, but got %q", tok)
+			}
+			if i > 0 && tokens[i-1] >= tok {
+				t.Errorf("Expected sorted unique tokens, but got %q", tokens)
+			}
+		}
+		// queries are analyzed like documents, so the text and each of its tokens find the document
+		if q := db.queryTokens(text); !slices.Equal(q, tokens) {
+			t.Errorf("Expected query tokens %q, but got %q", tokens, q)
+		}
+		for _, tok := range tokens {
+			if res, err := db.Query(tok); err != nil || len(res) != 1 || res[0].ID != 1 {
+				t.Errorf("Expected the document for token %q of %q, but got %v, %v", tok, text, res, err)
+			}
+		}
+	})
+}
+
+func FuzzUnicodeAnalyzer(f *testing.F) {
+	for _, text := range fuzzTexts {
+		f.Add(text, false)
+		f.Add(text, true)
+	}
+	f.Fuzz(func(t *testing.T, text string, foldAccents bool) {
+		a := NewUnicodeAnalyzer(foldAccents)
+		tokens := a.Tokens(text)
+		for _, tok := range tokens {
+			if tok == "" {
+				t.Errorf("Expected no empty tokens for %q, but got %q", text, tokens)
+			}
+			if utf8.ValidString(text) && !utf8.ValidString(tok) {
+				t.Errorf("Expected valid UTF-8 tokens for %q, but got %q", text, tok)
+			}
+		}
+
+		// analyzing the tokens again gives them back
+		again := a.Tokens(strings.Join(tokens, " "))
+		if utf8.ValidString(text) && !slices.Equal(again, tokens) {
+			t.Errorf("Expected analyzing %q to be idempotent, but got %q then %q", text, tokens, again)
+		}
+
+		analyzed := a.Analyze(text)
+		if !slices.IsSorted(analyzed) || len(slices.Compact(slices.Clone(analyzed))) != len(analyzed) {
+			t.Errorf("Expected sorted unique tokens, but got %q", analyzed)
+		}
+	})
+}
+
+func FuzzParseBool(f *testing.F) {
+	seeds := []string{
+		"alice",
+		"alice AND rabbit",
+		"alice rabbit OR queen",
+		"(alice OR queen) AND NOT rabbit",
+		"-rabbit alice",
+		"((a)",
+		"a AND",
+		"NOT NOT NOT a",
+		")(",
+	}
+	for _, q := range seeds {
+		f.Add(q, "alice and the white rabbit")
+	}
+	db := NewDB()
+	f.Fuzz(func(t *testing.T, query, text string) {
+		n, err := parseBool(query, db.queryTokens)
+		if err != nil {
+			return
+		}
+		tokens := make(map[string]struct{})
+		for _, tok := range db.docTokens(text) {
+			tokens[tok] = struct{}{}
+		}
+		matched := n.match(tokens)
+
+		// a document that matches contains at least one of the trigger tokens, unless the query matches without any
+		triggers := n.triggers()
+		if matched && triggers != nil && !containsAny(db.indexTokens(text), triggers) {
+			t.Errorf("Expected %q matching %q to contain one of %q", text, query, triggers)
+		}
+	})
+}
+
+func FuzzQueryJSON(f *testing.F) {
+	seeds := []string{
+		`{"term": {"value": "alice"}}`,
+		`{"match": {"field": "title", "query": "alice wonderland", "operator": "and"}}`,
+		`{"phrase": {"query": "white rabbit"}}`,
+		`{"bool": {"must": [{"term": {"value": "a"}}], "should": {"prefix": {"value": "r"}}, "must_not": [], "minimum_should_match": 1}}`,
+		`{"range": {"field": "year", "gte": 1860, "lt": 1.9e3}}`,
+		`{"fuzzy": {"field": "author", "value": "caroll", "fuzziness": 2}}`,
+		`{"bool": {}}`,
+		`[]`,
+		`{"term": {"value": 1}}`,
+	}
+	for _, q := range seeds {
+		f.Add([]byte(q))
+	}
+	db := NewDB()
+	db.Index(Document{ID: 1, Text: "Alice follows the white rabbit"})
+	db.IndexJSON(2, []byte(`{"title": "Alice in Wonderland", "author": "Carroll", "year": 1865, "tags": ["rabbit"], "public": true}`))
+	f.Fuzz(func(t *testing.T, src []byte) {
+		res, err := db.QueryJSON(src)
+		var qe *QueryError
+		if err != nil && !errors.As(err, &qe) {
+			t.Errorf("Expected a *QueryError for %s, but got %v", src, err)
+		}
+		seen := make(map[int]struct{})
+		for _, doc := range res {
+			if _, exists := seen[doc.ID]; exists {
+				t.Errorf("Expected unique results for %s, but got %v", src, res)
+			}
+			seen[doc.ID] = struct{}{}
+		}
+	})
+}
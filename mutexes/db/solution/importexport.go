@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultImportBatch is the number of records committed together when ImportOptions.BatchSize is not set
+const defaultImportBatch = 256
+
+// ImportOptions controls how records are turned into documents by ImportJSONL and ImportCSV
+type ImportOptions struct {
+	// IDField is the field path holding the integer doc ID of a record. When empty the zero based record number is used as the ID.
+	IDField string
+	// TextField turns every record into a plain text document holding the string value of this field path. When empty records are indexed as JSON documents.
+	TextField string
+	// KeyField is the field path holding the external key of a record, records without it have no key. When empty no key is read.
+	KeyField string
+	// ExpiresField is the field path holding the expiry time of a record in RFC 3339 format, records without it never expire. When empty no expiry is read.
+	ExpiresField string
+	// Columns maps CSV header names to field paths. Columns that are not mapped keep their header name as path, columns mapped to "" are dropped.
+	Columns map[string]string
+	// Skip is the number of records to skip before importing, used to resume an import that stopped early
+	Skip int
+	// BatchSize is the number of records committed together at a single version
+	BatchSize int
+}
+
+// ImportJSONL streams JSON objects, one per line, from r into the db. Records are committed in batches so a batch is either fully imported or not at all. The number of records consumed is returned, including skipped ones, so an import that failed or was cancelled can be resumed by passing it as ImportOptions.Skip.
+func (d *DB) ImportJSONL(ctx context.Context, r io.Reader, opts ImportOptions) (int, error) {
+	dec := json.NewDecoder(r)
+	return d.importRecords(ctx, opts, false, func() ([]byte, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}, opts.document)
+}
+
+// ImportText streams lines from r into the db as plain text documents keyed by name and the one based line number, e.g. "alice-in-wonderland.txt:42", like splitTextFile. Doc IDs are assigned as by DB.Add, so several files can be imported into the same db. Lines may be of any length. Only Skip and BatchSize of the options apply. See ImportJSONL for batching and resuming.
+func (d *DB) ImportText(ctx context.Context, r io.Reader, name string, opts ImportOptions) (int, error) {
+	rd := bufio.NewReader(r)
+	return d.importRecords(ctx, opts, true, func() ([]byte, error) {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		return bytes.TrimSuffix(line, []byte("\r")), nil
+	}, func(n int, line []byte) (Document, error) {
+		return Document{Key: fmt.Sprintf("%s:%d", name, n+1), Text: string(line)}, nil
+	})
+}
+
+// ImportCSV streams CSV rows from r into the db. The first row is the header naming the columns, every following row is a record. Cells holding a JSON number or true or false are typed as such, empty cells are left out and everything else is a string. See ImportJSONL for batching and resuming, the header is not counted as a record.
+func (d *DB) ImportCSV(ctx context.Context, r io.Reader, opts ImportOptions) (int, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("import csv: header: %v", err)
+	}
+	paths := make([]string, len(header))
+	for i, col := range header {
+		paths[i] = col
+		if p, exists := opts.Columns[col]; exists {
+			paths[i] = p
+		}
+	}
+
+	return d.importRecords(ctx, opts, false, func() ([]byte, error) {
+		row, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		obj := make(map[string]any)
+		for i, cell := range row {
+			if i >= len(paths) || paths[i] == "" || cell == "" {
+				continue
+			}
+			if err := setPath(obj, paths[i], csvValue(cell)); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(obj)
+	}, opts.document)
+}
+
+// importRecords reads records with next until io.EOF, turns those that are not skipped into documents with convert and commits them in batches. With assign the documents get a doc ID at commit time, as by DB.Add.
+func (d *DB) importRecords(ctx context.Context, opts ImportOptions, assign bool, next func() ([]byte, error), convert func(n int, rec []byte) (Document, error)) (int, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatch
+	}
+
+	// done is the number of records consumed up to the last committed batch
+	var done, n int
+	var batch []Op
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := d.commit(batch); err != nil {
+				return fmt.Errorf("import: records %d to %d: %v", done, n-1, err)
+			}
+		}
+		// the committed ops are kept by the write log, so the batch is not reused
+		batch = nil
+		done = n
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+		rec, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return done, fmt.Errorf("import: record %d: %v", n, err)
+		}
+		n++
+		if n <= opts.Skip {
+			done = n
+			continue
+		}
+
+		doc, err := convert(n-1, rec)
+		if err != nil {
+			return done, fmt.Errorf("import: record %d: %v", n-1, err)
+		}
+		batch = append(batch, Op{Type: OpIndex, Doc: doc, assign: assign})
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return done, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return done, err
+	}
+	return done, nil
+}
+
+// document builds the document of a single record
+func (opts ImportOptions) document(n int, rec []byte) (Document, error) {
+	doc, fields, err := ParseJSON(n, rec)
+	if err != nil {
+		return Document{}, err
+	}
+
+	if opts.IDField != "" {
+		values := fields[opts.IDField]
+		if len(values) != 1 || values[0].Type != FieldNumber || values[0].Number != math.Trunc(values[0].Number) {
+			return Document{}, fmt.Errorf("id field %s must hold a single integer", opts.IDField)
+		}
+		doc.ID = int(values[0].Number)
+	}
+
+	if values, exists := fields[opts.KeyField]; opts.KeyField != "" && exists {
+		if len(values) != 1 || !values[0].Type.compatible(FieldText) {
+			return Document{}, fmt.Errorf("key field %s must hold a single string", opts.KeyField)
+		}
+		doc.Key = values[0].String
+	}
+	if values, exists := fields[opts.ExpiresField]; opts.ExpiresField != "" && exists {
+		if len(values) != 1 || !values[0].Type.compatible(FieldText) {
+			return Document{}, fmt.Errorf("expires field %s must hold a single string", opts.ExpiresField)
+		}
+		if doc.Expires, err = time.Parse(time.RFC3339Nano, values[0].String); err != nil {
+			return Document{}, fmt.Errorf("expires field %s: %v", opts.ExpiresField, err)
+		}
+	}
+
+	if opts.TextField != "" {
+		values := fields[opts.TextField]
+		if len(values) != 1 || !values[0].Type.compatible(FieldText) {
+			return Document{}, fmt.Errorf("text field %s must hold a single string", opts.TextField)
+		}
+		return Document{ID: doc.ID, Key: doc.Key, Text: values[0].String, Expires: doc.Expires}, nil
+	}
+	return doc, nil
+}
+
+// csvValue types a CSV cell
+func csvValue(cell string) any {
+	switch cell {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if _, err := strconv.ParseFloat(cell, 64); err == nil && json.Valid([]byte(cell)) {
+		return json.Number(cell)
+	}
+	return cell
+}
+
+// setPath stores v in obj under the dotted path, creating nested objects on the way
+func setPath(obj map[string]any, path string, v any) error {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		child, exists := obj[k]
+		if !exists {
+			child = make(map[string]any)
+			obj[k] = child
+		}
+		m, ok := child.(map[string]any)
+		if !ok {
+			return fmt.Errorf("column %s conflicts with column %s", path, k)
+		}
+		obj = m
+	}
+	last := keys[len(keys)-1]
+	if _, exists := obj[last]; exists {
+		return fmt.Errorf("column %s is set twice", path)
+	}
+	obj[last] = v
+	return nil
+}
+
+// ExportOptions controls how documents are written by ExportJSONL and ExportCSV
+type ExportOptions struct {
+	// IDField is the top level key or column the doc ID is written to, "id" by default
+	IDField string
+	// TextField is the key or column the text of plain text documents is written to, "text" by default
+	TextField string
+	// KeyField is the key or column the external key of a document is written to, "key" by default
+	KeyField string
+	// ExpiresField is the key or column the expiry time of a document is written to in RFC 3339 format, "expires" by default
+	ExpiresField string
+	// Columns are the field paths written after the ID, key and expires columns by ExportCSV. By default TextField is followed by every other field path in the schema.
+	Columns []string
+	// Skip is the number of documents in ID order to skip before exporting, used to resume an export that stopped early
+	Skip int
+}
+
+// withDefaults fills in the default field names
+func (opts ExportOptions) withDefaults() ExportOptions {
+	if opts.IDField == "" {
+		opts.IDField = "id"
+	}
+	if opts.TextField == "" {
+		opts.TextField = "text"
+	}
+	if opts.KeyField == "" {
+		opts.KeyField = "key"
+	}
+	if opts.ExpiresField == "" {
+		opts.ExpiresField = "expires"
+	}
+	return opts
+}
+
+// expiresValue formats the expiry time of a document for export, empty when it never expires
+func expiresValue(doc Document) string {
+	if doc.Expires.IsZero() {
+		return ""
+	}
+	return doc.Expires.Format(time.RFC3339Nano)
+}
+
+// ExportJSONL writes every document to w as one JSON object per line in doc ID order. JSON documents are written as their original source with the doc ID, key and expiry time added under IDField, KeyField and ExpiresField unless the source already has those keys, plain text documents as an object holding the ID, key, expiry time and text. The key and expiry time are left out of documents without them. All documents are read from a single snapshot. The number of documents consumed is returned, including skipped ones, so an export that failed or was cancelled can be resumed by passing it as ExportOptions.Skip.
+func (d *DB) ExportJSONL(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	opts = opts.withDefaults()
+	textKey, _ := json.Marshal(opts.TextField)
+
+	bw := bufio.NewWriter(w)
+	var buf bytes.Buffer
+	n, err := d.exportDocs(ctx, opts.Skip, bw, func(doc Document, _ Fields) error {
+		// the members added in front of the source or the text
+		var head []string
+		member := func(key string, v any) {
+			k, _ := json.Marshal(key)
+			value, _ := json.Marshal(v)
+			head = append(head, string(k)+":"+string(value))
+		}
+		var top map[string]json.RawMessage
+		buf.Reset()
+		if doc.Source != "" {
+			if err := json.Compact(&buf, []byte(doc.Source)); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(buf.Bytes(), &top); err != nil {
+				return err
+			}
+		}
+		if _, exists := top[opts.IDField]; !exists {
+			member(opts.IDField, doc.ID)
+		}
+		if _, exists := top[opts.KeyField]; !exists && doc.Key != "" {
+			member(opts.KeyField, doc.Key)
+		}
+		if _, exists := top[opts.ExpiresField]; !exists && !doc.Expires.IsZero() {
+			member(opts.ExpiresField, expiresValue(doc))
+		}
+
+		if doc.Source == "" {
+			text, _ := json.Marshal(doc.Text)
+			head = append(head, string(textKey)+":"+string(text))
+			buf.WriteString("{" + strings.Join(head, ",") + "}")
+		} else if len(head) > 0 {
+			rest := bytes.TrimSpace(buf.Bytes()[1:])
+			sep := ","
+			if len(rest) == 1 {
+				sep = ""
+			}
+			rec := "{" + strings.Join(head, ",") + sep + string(rest)
+			buf.Reset()
+			buf.WriteString(rec)
+		}
+		buf.WriteByte('\n')
+		_, err := bw.Write(buf.Bytes())
+		return err
+	})
+	return n, err
+}
+
+// ExportCSV writes every document to w as a CSV row in doc ID order, preceded by a header row unless the export is resumed. The ID, key and expiry time come first, then the columns. A column holds the values of the field path joined by "|", the TextField column of a plain text document holds its text. See ExportJSONL for snapshots and resuming.
+func (d *DB) ExportCSV(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	opts = opts.withDefaults()
+	columns := opts.Columns
+	if columns == nil {
+		columns = []string{opts.TextField}
+		schema := Fields{}
+		for p := range d.Schema() {
+			schema[p] = nil
+		}
+		for _, p := range schema.Paths() {
+			if p != opts.IDField && p != opts.TextField && p != opts.KeyField && p != opts.ExpiresField {
+				columns = append(columns, p)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if opts.Skip == 0 {
+		if err := cw.Write(append([]string{opts.IDField, opts.KeyField, opts.ExpiresField}, columns...)); err != nil {
+			return 0, err
+		}
+	}
+
+	const fixed = 3
+	row := make([]string, len(columns)+fixed)
+	n, err := d.exportDocs(ctx, opts.Skip, nil, func(doc Document, fields Fields) error {
+		row[0], row[1], row[2] = strconv.Itoa(doc.ID), doc.Key, expiresValue(doc)
+		for i, col := range columns {
+			row[i+fixed] = ""
+			if doc.Source == "" {
+				if col == opts.TextField {
+					row[i+fixed] = doc.Text
+				}
+				continue
+			}
+			var values []string
+			for _, v := range fields[col] {
+				values = append(values, v.String)
+				if v.Type == FieldNumber || v.Type == FieldBool {
+					values[len(values)-1] = v.term()
+				}
+			}
+			row[i+fixed] = strings.Join(values, "|")
+		}
+		return cw.Write(row)
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	return n, err
+}
+
+// exportDocs calls write for every document of a snapshot in ID order after skipping the first skip documents. bw is flushed before returning when set.
+func (d *DB) exportDocs(ctx context.Context, skip int, bw *bufio.Writer, write func(Document, Fields) error) (n int, err error) {
+	s := d.Snapshot()
+	defer s.Release()
+
+	if bw != nil {
+		defer func() {
+			if ferr := bw.Flush(); err == nil {
+				err = ferr
+			}
+		}()
+	}
+
+	ids := s.ids()
+	n = min(max(skip, 0), len(ids))
+	for _, id := range ids[n:] {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		s.db.mu.RLock()
+		v, exists := s.lookup(id)
+		s.db.mu.RUnlock()
+		if exists {
+			if err := write(v.doc, v.fields); err != nil {
+				return n, fmt.Errorf("export: id %d: %v", id, err)
+			}
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportJSONL(t *testing.T) {
+	input := `{"id": 3, "title": "Alice in Wonderland", "year": 1865}
+{"id": 1, "title": "Through the Looking-Glass", "year": 1871}
+{"id": 2, "title": "The Hunting of the Snark", "year": 1876}
+{"id": 3, "title": "a duplicate id"}
+{"id": 4, "title": "never reached"}
+`
+	db := NewDB()
+	n, err := db.ImportJSONL(context.Background(), strings.NewReader(input), ImportOptions{IDField: "id", BatchSize: 2})
+	if err == nil || n != 2 {
+		t.Errorf("Expected the second batch to fail after 2 records, but got %d, %v", n, err)
+	}
+	if res, _ := db.QueryField("year", "1876"); len(res) != 0 {
+		t.Errorf("Expected the failed batch to be rolled back, but got %v", res)
+	}
+
+	// resume after fixing the duplicate
+	input = strings.Replace(input, `{"id": 3, "title": "a duplicate id"}`, `{"id": 5, "title": "a fixed id"}`, 1)
+	n, err = db.ImportJSONL(context.Background(), strings.NewReader(input), ImportOptions{IDField: "id", Skip: n, BatchSize: 2})
+	if err != nil || n != 5 {
+		t.Errorf("Expected 5 records consumed, but got %d, %v", n, err)
+	}
+	if res, _ := db.Query("looking glass reached"); len(res) != 2 {
+		t.Errorf("Expected 2 documents, but got %v", res)
+	}
+	if raw, err := db.GetJSON(3); err != nil || string(raw) != `{"id": 3, "title": "Alice in Wonderland", "year": 1865}` {
+		t.Errorf("Expected the original json of doc ID 3, but got %s, %v", raw, err)
+	}
+
+	// plain text documents with the record number as ID
+	db = NewDB()
+	input = "{\"line\": \"down the rabbit hole\"}\n{\"line\": \"the pool of tears\"}\n"
+	if n, err := db.ImportJSONL(context.Background(), strings.NewReader(input), ImportOptions{TextField: "line"}); err != nil || n != 2 {
+		t.Errorf("Expected 2 records, but got %d, %v", n, err)
+	}
+	if doc, err := db.Get(1); err != nil || doc != (Document{ID: 1, Text: "the pool of tears"}) {
+		t.Errorf("Expected a plain text document, but got %v, %v", doc, err)
+	}
+
+	bad := []string{`{"id": "one"}`, `{"id": 1.5}`, `{"id": 1, "line": 7}`, `not json`}
+	for _, input := range bad {
+		if _, err := NewDB().ImportJSONL(context.Background(), strings.NewReader(input), ImportOptions{IDField: "id", TextField: "line"}); err == nil {
+			t.Errorf("Should have returned an error for %s", input)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := NewDB().ImportJSONL(ctx, strings.NewReader(input), ImportOptions{}); err != context.Canceled || n != 0 {
+		t.Errorf("Expected %v before any record, but got %d, %v", context.Canceled, n, err)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	input := `ID,Title,Year,Author,Notes
+1,Alice in Wonderland,1865,Lewis Carroll,
+2,The Hunting of the Snark,1876,Lewis Carroll,"a poem, in eight fits"
+`
+	db := NewDB()
+	opts := ImportOptions{IDField: "id", Columns: map[string]string{"ID": "id", "Author": "author.name", "Notes": ""}}
+	if n, err := db.ImportCSV(context.Background(), strings.NewReader(input), opts); err != nil || n != 2 {
+		t.Fatalf("Expected 2 records, but got %d, %v", n, err)
+	}
+
+	raw, _ := db.GetJSON(2)
+	expected := `{"Title":"The Hunting of the Snark","Year":1876,"author":{"name":"Lewis Carroll"},"id":2}`
+	if string(raw) != expected {
+		t.Errorf("Expected %s, but got %s", expected, raw)
+	}
+	if res, _ := db.QueryField("Year", "1865"); len(res) != 1 || res[0].ID != 1 {
+		t.Errorf("Expected doc ID 1 for Year 1865, but got %v", res)
+	}
+	if res, _ := db.Query("poem"); len(res) != 0 {
+		t.Errorf("Expected the dropped column not to be indexed, but got %v", res)
+	}
+
+	if _, err := NewDB().ImportCSV(context.Background(), strings.NewReader("a,a.b\n1,2\n"), ImportOptions{}); err == nil {
+		t.Error("Should have returned an error for conflicting columns")
+	}
+}
+
+func TestImportText(t *testing.T) {
+	db := NewDB()
+	input := "down the rabbit hole\nthe pool of tears\r\na caucus race"
+	if n, err := db.ImportText(context.Background(), strings.NewReader(input), "alice.txt", ImportOptions{Skip: 1, BatchSize: 1}); err != nil || n != 3 {
+		t.Errorf("Expected 3 lines consumed, but got %d, %v", n, err)
+	}
+	if _, err := db.GetByKey("alice.txt:1"); err == nil {
+		t.Error("Expected the skipped line to be left out")
+	}
+	if doc, err := db.GetByKey("alice.txt:3"); err != nil || doc.Text != "a caucus race" {
+		t.Errorf("Expected the third line under alice.txt:3, but got %v, %v", doc, err)
+	}
+	if doc, err := db.GetByKey("alice.txt:2"); err != nil || doc.Text != "the pool of tears" {
+		t.Errorf("Expected the second line without its line ending, but got %q, %v", doc.Text, err)
+	}
+	if n, err := db.ImportText(context.Background(), strings.NewReader(input), "alice.txt", ImportOptions{Skip: 1, BatchSize: 2}); err == nil || n != 1 {
+		t.Errorf("Expected the first batch to fail on a duplicate key, but got %d, %v", n, err)
+	}
+
+	// a second file gets its own keys and doc IDs, and lines may be longer than a scanner token
+	long := strings.Repeat("tweedle ", 20000)
+	if n, err := db.ImportText(context.Background(), strings.NewReader("through the looking glass\n"+long+"\n"), "glass.txt", ImportOptions{}); err != nil || n != 2 {
+		t.Fatalf("Expected 2 lines of the second file, but got %d, %v", n, err)
+	}
+	if doc, err := db.GetByKey("glass.txt:2"); err != nil || doc.Text != long {
+		t.Errorf("Expected the long line, but got %d bytes, %v", len(doc.Text), err)
+	}
+	if res, _ := db.Query("race glass"); len(res) != 2 || res[0].ID == res[1].ID {
+		t.Errorf("Expected a line of each file, but got %v", res)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db := NewDB()
+	db.Index(Document{ID: 0, Text: `a "quoted" line`})
+	db.IndexJSON(1, []byte("{\n  \"title\": \"Alice\",\n  \"tags\": [\"a\", \"b\"],\n  \"year\": 1865\n}"))
+	db.IndexJSON(2, []byte(`{"id": 2, "title": "Snark"}`))
+	db.IndexJSON(3, []byte(`{}`))
+
+	var buf bytes.Buffer
+	n, err := db.ExportJSONL(context.Background(), &buf, ExportOptions{})
+	expected := `{"id":0,"text":"a \"quoted\" line"}
+{"id":1,"title":"Alice","tags":["a","b"],"year":1865}
+{"id":2,"title":"Snark"}
+{"id":3}
+`
+	if err != nil || n != 4 || buf.String() != expected {
+		t.Errorf("Expected %d documents\n%s\nbut got %d, %v\n%s", 4, expected, n, err, buf.String())
+	}
+
+	buf.Reset()
+	if n, err := db.ExportJSONL(context.Background(), &buf, ExportOptions{Skip: 3}); err != nil || n != 4 || buf.String() != "{\"id\":3}\n" {
+		t.Errorf("Expected the resumed export to write the last document, but got %d, %v, %s", n, err, buf.String())
+	}
+
+	buf.Reset()
+	n, err = db.ExportCSV(context.Background(), &buf, ExportOptions{})
+	expected = `id,key,expires,text,tags,title,year
+0,,,"a ""quoted"" line",,,
+1,,,,a|b,Alice,1865
+2,,,,,Snark,
+3,,,,,,
+`
+	if err != nil || n != 4 || buf.String() != expected {
+		t.Errorf("Expected\n%s\nbut got %d, %v\n%s", expected, n, err, buf.String())
+	}
+
+	// json documents round trip through jsonl, keys and expiry times included
+	expires := time.Date(2100, 1, 2, 3, 4, 5, 6, time.UTC)
+	db.Delete(0)
+	db.Index(Document{ID: 4, Key: "k4", Text: "keyed", Expires: expires})
+	db.Index(Document{ID: 5, Key: "k5", Source: `{"title": "Jabberwocky"}`})
+	buf.Reset()
+	db.ExportJSONL(context.Background(), &buf, ExportOptions{})
+	exported := buf.String()
+	if line := `{"id":4,"key":"k4","expires":"2100-01-02T03:04:05.000000006Z","text":"keyed"}`; !strings.Contains(exported, line+"\n") {
+		t.Errorf("Expected %s in the export, but got\n%s", line, exported)
+	}
+
+	opts := ImportOptions{IDField: "id", KeyField: "key", ExpiresField: "expires"}
+	db2 := NewDB()
+	if _, err := db2.ImportJSONL(context.Background(), &buf, opts); err != nil {
+		t.Fatalf("Failed to import the export, %v", err)
+	}
+	buf.Reset()
+	db2.ExportJSONL(context.Background(), &buf, ExportOptions{})
+	if buf.String() != exported {
+		t.Errorf("Expected the export to round trip\n%s\nbut got\n%s", exported, buf.String())
+	}
+
+	buf.Reset()
+	db.ExportCSV(context.Background(), &buf, ExportOptions{})
+	db3 := NewDB()
+	if _, err := db3.ImportCSV(context.Background(), &buf, opts); err != nil {
+		t.Fatalf("Failed to import the csv export, %v", err)
+	}
+	for _, imported := range []*DB{db2, db3} {
+		if doc, err := imported.GetByKey("k4"); err != nil || doc.ID != 4 || !doc.Expires.Equal(expires) {
+			t.Errorf("Expected doc ID 4 expiring at %v, but got %v, %v", expires, doc, err)
+		}
+		if doc, err := imported.GetByKey("k5"); err != nil || doc.ID != 5 || !doc.Expires.IsZero() {
+			t.Errorf("Expected doc ID 5 without expiry, but got %v, %v", doc, err)
+		}
+	}
+	if _, err := NewDB().ImportJSONL(context.Background(), strings.NewReader(`{"expires": "tomorrow"}`), opts); err == nil {
+		t.Error("Should have returned an error for an invalid expiry time")
+	}
+}
+
+func TestRunExport(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "lines.txt")
+	if err := os.WriteFile(in, []byte("first line\nsecond line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	if err := run([]string{"export", "-to", "csv", in}, &stdout); err != nil {
+		t.Fatalf("Failed to export, %v", err)
+	}
+	if expected := "id,key,expires,text\n0," + in + ":1,,first line\n1," + in + ":2,,second line\n"; stdout.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, stdout.String())
+	}
+
+	out := filepath.Join(dir, "lines.jsonl")
+	if err := run([]string{"export", "-o", out, in}, &stdout); err != nil {
+		t.Fatalf("Failed to export, %v", err)
+	}
+	stdout.Reset()
+	seg := filepath.Join(dir, "lines.seg")
+	if err := run([]string{"import", "-text", "text", "-id", "id", "-key", "key", "-o", seg, "-query", "second", out}, &stdout); err != nil {
+		t.Fatalf("Failed to import, %v", err)
+	}
+	if expected := "Imported 2 records into " + seg + "\nDoc ID: 1 with Text: second line\n"; !strings.HasPrefix(stdout.String(), expected) {
+		t.Errorf("Expected the second line to be found, but got\n%s", stdout.String())
+	}
+
+	// the import persists, a resumed import adds to the segment which query and export open
+	if err := os.WriteFile(in, []byte("first line\nsecond line\nthird line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := run([]string{"import", "-o", seg, "-skip", "2", in}, &stdout); err != nil {
+		t.Fatalf("Failed to resume the import, %v", err)
+	}
+	if expected := "Imported 1 records into " + seg + "\n"; stdout.String() != expected {
+		t.Errorf("Expected %q, but got %q", expected, stdout.String())
+	}
+	stdout.Reset()
+	if err := run([]string{"query", seg, "line"}, &stdout); err != nil {
+		t.Fatalf("Failed to query, %v", err)
+	}
+	if !strings.HasSuffix(stdout.String(), "Doc ID: 2 with Text: third line\nFound 3 documents with query string line\n") {
+		t.Errorf("Expected 3 documents in the segment, but got\n%s", stdout.String())
+	}
+	stdout.Reset()
+	if err := run([]string{"export", "-to", "csv", seg}, &stdout); err != nil {
+		t.Fatalf("Failed to export the segment, %v", err)
+	}
+	if expected := "id,key,expires,text\n0," + in + ":1,,first line\n1," + in + ":2,,second line\n2," + in + ":3,,third line\n"; stdout.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, stdout.String())
+	}
+	if err := run([]string{"import", "-o", seg, in}, &stdout); err == nil {
+		t.Error("Should have returned an error importing the same records twice")
+	}
+	other := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(other, []byte("another file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := run([]string{"import", "-o", seg, "-query", "another", other}, &stdout); err != nil {
+		t.Fatalf("Failed to import a second text file, %v", err)
+	}
+	if expected := "Imported 1 records into " + seg + "\nDoc ID: 3 with Text: another file\n"; !strings.HasPrefix(stdout.String(), expected) {
+		t.Errorf("Expected the line of the second file, but got\n%s", stdout.String())
+	}
+	if err := run([]string{"import", in}, &stdout); err == nil {
+		t.Error("Should have returned an error without a segment to import into")
+	}
+
+	if err := run([]string{"frobnicate"}, &stdout); err == nil {
+		t.Error("Should have returned an error for an unknown command")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FieldType is the type inferred for a field of a JSON document
+type FieldType int
+
+const (
+	// FieldText is a string containing whitespace, it is analyzed like the document text
+	FieldText FieldType = iota + 1
+	// FieldKeyword is a string without whitespace of at most maxKeywordLength runes, it is indexed as a single exact value
+	FieldKeyword
+	// FieldNumber is a JSON number
+	FieldNumber
+	// FieldBool is a JSON true or false
+	FieldBool
+)
+
+// maxKeywordLength is the longest string in runes that is still treated as a keyword
+const maxKeywordLength = 64
+
+// String returns the name of the field type
+func (t FieldType) String() string {
+	switch t {
+	case FieldText:
+		return "text"
+	case FieldKeyword:
+		return "keyword"
+	case FieldNumber:
+		return "number"
+	case FieldBool:
+		return "bool"
+	}
+	return fmt.Sprintf("FieldType(%d)", int(t))
+}
+
+// compatible reports whether values of both types may share a field. Text and keyword are both strings and can be mixed.
+func (t FieldType) compatible(o FieldType) bool {
+	isString := func(t FieldType) bool { return t == FieldText || t == FieldKeyword }
+	return t == o || isString(t) && isString(o)
+}
+
+// FieldValue is a single value of a flattened field. Only the member matching Type is set.
+type FieldValue struct {
+	Type   FieldType
+	String string
+	Number float64
+	Bool   bool
+}
+
+// term returns the value as it is written to the index, without the field path
+func (v FieldValue) term() string {
+	switch v.Type {
+	case FieldNumber:
+		return strconv.FormatFloat(v.Number, 'g', -1, 64)
+	case FieldBool:
+		return strconv.FormatBool(v.Bool)
+	}
+	return FoldCase(v.String)
+}
+
+// Fields maps the dotted path of every leaf of a JSON document to its values. Arrays contribute one value per element under the path of the array, nulls are left out. e.g. {"book": {"tags": ["a", "b"]}} has the field "book.tags" with the values "a" and "b".
+type Fields map[string][]FieldValue
+
+// Paths returns the field paths in sorted order
+func (f Fields) Paths() []string {
+	paths := make([]string, 0, len(f))
+	for p := range f {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// inferType returns the field type of a JSON string
+func inferType(s string) FieldType {
+	if len([]rune(s)) > maxKeywordLength || strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+		return FieldText
+	}
+	return FieldKeyword
+}
+
+// ParseJSON flattens a JSON object into its fields and builds the Document text from every string value in document order, so plain queries match JSON documents as well. The returned document keeps the original JSON as its Source.
+func ParseJSON(id int, src []byte) (Document, Fields, error) {
+	fields, text, err := flattenJSON(src)
+	if err != nil {
+		return Document{}, nil, err
+	}
+	return Document{ID: id, Text: text, Source: string(bytes.TrimSpace(src))}, fields, nil
+}
+
+// flattenJSON decodes a JSON object into its fields and the text of its string values
+func flattenJSON(src []byte) (Fields, string, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, "", fmt.Errorf("json: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, "", fmt.Errorf("json: unexpected data after the document")
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("json: document must be an object")
+	}
+
+	fields := make(Fields)
+	var text []string
+	if err := flatten(obj, "", fields, &text); err != nil {
+		return nil, "", err
+	}
+	return fields, strings.Join(text, "\n"), nil
+}
+
+// flatten adds the leaves of v below path to fields and collects string values into text
+func flatten(v any, path string, fields Fields, text *[]string) error {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == "" {
+				return fmt.Errorf("json: empty field name below %q", path)
+			}
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			if err := flatten(v[k], p, fields, text); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, e := range v {
+			if err := flatten(e, path, fields, text); err != nil {
+				return err
+			}
+		}
+	case string:
+		fields[path] = append(fields[path], FieldValue{Type: inferType(v), String: v})
+		*text = append(*text, v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return fmt.Errorf("json: field %s: %v", path, err)
+		}
+		fields[path] = append(fields[path], FieldValue{Type: FieldNumber, Number: f})
+	case bool:
+		fields[path] = append(fields[path], FieldValue{Type: FieldBool, Bool: v})
+	case nil:
+	}
+	return nil
+}
+
+// deriveTexts gives the documents indexed with a JSON source but no text the text of the source's string values, as ParseJSON does
+func deriveTexts(ops []Op) {
+	for i := range ops {
+		doc := &ops[i].Doc
+		if ops[i].Type != OpIndex || doc.Text != "" || doc.Source == "" {
+			continue
+		}
+		// an invalid source is rejected by validate
+		if _, text, err := flattenJSON([]byte(doc.Source)); err == nil {
+			doc.Text = text
+		}
+	}
+}
+
+// documentFields returns the fields of a document, or nil for a plain text document
+func documentFields(doc Document) (Fields, error) {
+	if doc.Source == "" {
+		return nil, nil
+	}
+	fields, _, err := flattenJSON([]byte(doc.Source))
+	return fields, err
+}
+
+// fieldSep joins the path and the value of a field term. Analyzer.Tokens drops tokens holding it and field values never contain whitespace, so plain tokens never hold a space and the last space of a field term starts its value.
+const fieldSep = " "
+
+// isFieldTerm reports whether an index term belongs to a field rather than the document text
+func isFieldTerm(term string) bool {
+	return strings.Contains(term, fieldSep)
+}
+
+// fieldTerms returns the terms written to the index for the fields of a document. A field term is the field path and a value joined by fieldSep, e.g. "author carroll". Text values contribute one term per analyzed token, every other value a single term. Plain tokens never contain fieldSep, so field terms live next to them in the same index.
+func (d *DB) fieldTerms(fields Fields) []string {
+	var terms []string
+	for path, values := range fields {
+		for _, v := range values {
+			if v.Type == FieldText {
+				for _, t := range d.docTokens(v.String) {
+					terms = append(terms, path+fieldSep+t)
+				}
+				continue
+			}
+			terms = append(terms, path+fieldSep+v.term())
+		}
+	}
+	return terms
+}
+
+// documentTokens returns the unique terms a document version is indexed under
+func (d *DB) documentTokens(doc Document, fields Fields) []string {
+	if fields == nil {
+		return d.indexTokens(doc.Text)
+	}
+	return uniqueTokens(append(d.docTokens(doc.Text), d.fieldTerms(fields)...))
+}
+
+// checkSchema returns an error when a field of the document has a type that cannot be mixed with the type already recorded for it in schema
+func checkSchema(schema map[string]FieldType, id int, fields Fields) error {
+	for _, path := range fields.Paths() {
+		for _, v := range fields[path] {
+			if t, exists := schema[path]; exists && !t.compatible(v.Type) {
+				return fmt.Errorf("json: document %d: field %s is %v, got %v", id, path, t, v.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// updateSchema records the field types of a document. A field that has seen text stays text, the other types are kept as first seen.
+func updateSchema(schema map[string]FieldType, fields Fields) {
+	for path, values := range fields {
+		for _, v := range values {
+			if t, exists := schema[path]; !exists || t == FieldKeyword && v.Type == FieldText {
+				schema[path] = v.Type
+			}
+		}
+	}
+}
+
+// IndexJSON indexes a JSON object under the doc ID. Nested fields are flattened into dotted paths and their types are inferred from the values. A field keeps the type it was first indexed with for the lifetime of the db, so a document mixing a number and a string under the same path as earlier documents is rejected.
+func (d *DB) IndexJSON(id int, src []byte) error {
+	doc, _, err := ParseJSON(id, src)
+	if err != nil {
+		return fmt.Errorf("index json: %v", err)
+	}
+	return d.Index(doc)
+}
+
+// GetJSON returns the original JSON of the document with the specified doc ID. An error is returned if the document is not present or was not indexed from JSON.
+func (d *DB) GetJSON(id int) (json.RawMessage, error) {
+	doc, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Source == "" {
+		return nil, fmt.Errorf("get json: id %d is not a JSON document", id)
+	}
+	return json.RawMessage(doc.Source), nil
+}
+
+// QueryField returns the documents whose field at path matches the value. Text fields match on any analyzed token of value, other fields on the exact value, e.g. QueryField("year", "1865"). Results are in posting list order.
+func (d *DB) QueryField(path, value string) ([]Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.QueryField(path, value)
+}
+
+// QueryField runs a field query against the snapshot, see DB.QueryField
+func (s *Snapshot) QueryField(path, value string) ([]Document, error) {
+	s.db.mu.RLock()
+	t, exists := s.db.schema[path]
+	s.db.mu.RUnlock()
+	if !exists {
+		return []Document{}, fmt.Errorf("query field: unknown field %s", path)
+	}
+
+	var terms []string
+	switch t {
+	case FieldText:
+		for _, tok := range s.db.queryTokens(value) {
+			terms = append(terms, path+fieldSep+tok)
+		}
+		// short values of a text field are indexed as keywords
+		terms = append(terms, path+fieldSep+FoldCase(value))
+	case FieldNumber:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return []Document{}, fmt.Errorf("query field: field %s is a number, got %q", path, value)
+		}
+		terms = append(terms, path+fieldSep+strconv.FormatFloat(f, 'g', -1, 64))
+	case FieldBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return []Document{}, fmt.Errorf("query field: field %s is a bool, got %q", path, value)
+		}
+		terms = append(terms, path+fieldSep+strconv.FormatBool(b))
+	default:
+		terms = append(terms, path+fieldSep+FoldCase(value))
+	}
+
+	res := []Document{}
+	seen := make(map[int]struct{})
+	for _, term := range terms {
+		docs, _ := s.docs(context.Background(), term)
+		for _, doc := range docs {
+			if _, exists := seen[doc.ID]; !exists {
+				seen[doc.ID] = struct{}{}
+				res = append(res, doc)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Fields returns the flattened fields of the document with the specified doc ID, or nil for a plain text document. The fields are shared with the db and must not be modified.
+func (d *DB) Fields(id int) (Fields, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Fields(id)
+}
+
+// Fields returns the fields of the document as of the snapshot, see DB.Fields
+func (s *Snapshot) Fields(id int) (Fields, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if v, exists := s.lookup(id); exists {
+		return v.fields, nil
+	}
+	return nil, fmt.Errorf("fields: id %d not present", id)
+}
+
+// Schema returns the type recorded for every field path seen so far
+func (d *DB) Schema() map[string]FieldType {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	schema := make(map[string]FieldType, len(d.schema))
+	for p, t := range d.schema {
+		schema[p] = t
+	}
+	return schema
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	src := []byte(`{"title": "Alice in Wonderland", "meta": {"year": 1865, "public": true, "tags": ["fantasy", "classic"], "editor": null}}`)
+	doc, fields, err := ParseJSON(7, src)
+	if err != nil {
+		t.Fatalf("Failed to parse json, %v", err)
+	}
+	if doc.ID != 7 || doc.Source != string(src) {
+		t.Errorf("Expected doc ID 7 with the original source, but got %v", doc)
+	}
+	if doc.Text != "fantasy\nclassic\nAlice in Wonderland" {
+		t.Errorf("Expected the string values as text, but got %q", doc.Text)
+	}
+
+	testData := []struct {
+		path     string
+		expected []FieldValue
+	}{
+		{"title", []FieldValue{{Type: FieldText, String: "Alice in Wonderland"}}},
+		{"meta.year", []FieldValue{{Type: FieldNumber, Number: 1865}}},
+		{"meta.public", []FieldValue{{Type: FieldBool, Bool: true}}},
+		{"meta.tags", []FieldValue{{Type: FieldKeyword, String: "fantasy"}, {Type: FieldKeyword, String: "classic"}}},
+	}
+	if len(fields) != len(testData) {
+		t.Errorf("Expected %d fields, but got %v", len(testData), fields.Paths())
+	}
+	for _, d := range testData {
+		values := fields[d.path]
+		if len(values) != len(d.expected) {
+			t.Errorf("Expected %v for %s, but got %v", d.expected, d.path, values)
+			continue
+		}
+		for i := range values {
+			if values[i] != d.expected[i] {
+				t.Errorf("Expected %v for %s, but got %v", d.expected, d.path, values)
+			}
+		}
+	}
+
+	invalid := []string{`[1, 2]`, `"text"`, `{"a": 1`, `{"a": 1} {"b": 2}`, `{"": 1}`}
+	for _, src := range invalid {
+		if _, _, err := ParseJSON(0, []byte(src)); err == nil {
+			t.Errorf("Should have returned an error for %s", src)
+		}
+	}
+}
+
+func TestIndexJSON(t *testing.T) {
+	db := NewDB()
+	docs := []string{
+		`{"title": "Alice in Wonderland", "author": {"name": "Lewis Carroll"}, "year": 1865, "illustrated": true}`,
+		`{"title": "Through the Looking-Glass", "author": {"name": "Lewis Carroll"}, "year": 1871}`,
+		`{"title": "The Hunting of the Snark", "year": 1876, "illustrated": false, "form": "poem"}`,
+	}
+	for i, src := range docs {
+		if err := db.IndexJSON(i, []byte(src)); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", i, err)
+		}
+	}
+	db.Index(Document{ID: 3, Text: "a plain line about carroll"})
+
+	raw, err := db.GetJSON(1)
+	if err != nil || string(raw) != docs[1] {
+		t.Errorf("Expected the original json, but got %s, %v", raw, err)
+	}
+	if _, err := db.GetJSON(3); err == nil {
+		t.Error("Should have returned an error for a plain text document")
+	}
+
+	testData := []struct {
+		path     string
+		value    string
+		expected []int
+	}{
+		{"author.name", "carroll", []int{0, 1}},
+		{"title", "looking glass", []int{1}},
+		{"year", "1871", []int{1}},
+		{"year", "1871.0", []int{1}},
+		{"illustrated", "false", []int{2}},
+		{"form", "POEM", []int{2}},
+	}
+	for _, d := range testData {
+		res, err := db.QueryField(d.path, d.value)
+		if err != nil || len(res) != len(d.expected) {
+			t.Errorf("Expected %v for %s:%s, but got %v, %v", d.expected, d.path, d.value, res, err)
+			continue
+		}
+		for i, doc := range res {
+			if doc.ID != d.expected[i] {
+				t.Errorf("Expected %v for %s:%s, but got %v", d.expected, d.path, d.value, res)
+			}
+		}
+	}
+	if _, err := db.QueryField("year", "eighteen"); err == nil {
+		t.Error("Should have returned an error for a non numeric value of a number field")
+	}
+	if _, err := db.QueryField("publisher", "macmillan"); err == nil {
+		t.Error("Should have returned an error for an unknown field")
+	}
+
+	// plain queries match the string values of json documents
+	if res, _ := db.Query("carroll"); len(res) != 3 {
+		t.Errorf("Expected 3 documents for carroll, but got %v", res)
+	}
+
+	schema := db.Schema()
+	expected := map[string]FieldType{"title": FieldText, "author.name": FieldText, "year": FieldNumber, "illustrated": FieldBool, "form": FieldKeyword}
+	if len(schema) != len(expected) {
+		t.Errorf("Expected schema %v, but got %v", expected, schema)
+	}
+	for p, ft := range expected {
+		if schema[p] != ft {
+			t.Errorf("Expected %s to be %v, but got %v", p, ft, schema[p])
+		}
+	}
+
+	if err := db.IndexJSON(4, []byte(`{"year": "unknown"}`)); err == nil {
+		t.Error("Should have returned an error for a string in a number field")
+	}
+	if err := db.IndexJSON(4, []byte(`{"form": "a long poem in eight fits"}`)); err != nil {
+		t.Errorf("Should have accepted text in a keyword field, %v", err)
+	}
+
+	// field terms are reclaimed with the document
+	db.Delete(1)
+	db.Vacuum()
+	if _, exists := db.index["year"+fieldSep+"1871"]; exists {
+		t.Error("Expected the field terms of the deleted document to be reclaimed")
+	}
+	if fields, err := db.Fields(0); err != nil || fields["year"][0].Number != 1865 {
+		t.Errorf("Expected the fields of doc ID 0, but got %v, %v", fields, err)
+	}
+}
+
+func TestIndexJSONWhitespaceAnalyzer(t *testing.T) {
+	db := NewDB(WithAnalyzer(WhitespaceAnalyzer))
+	db.IndexJSON(0, []byte(`{"author": "carroll"}`))
+	// the whitespace analyzer keeps the colon inside the token
+	db.Index(Document{ID: 1, Text: "author:carroll"})
+	// a document with only a source gets its text from the source
+	db.Index(Document{ID: 2, Source: `{"title": "derived text"}`})
+
+	if res, _ := db.Query("author:carroll"); len(res) != 1 || res[0].ID != 1 {
+		t.Errorf("Expected only the plain document for author:carroll, but got %v", res)
+	}
+	if res, _ := db.QueryField("author", "carroll"); len(res) != 1 || res[0].ID != 0 {
+		t.Errorf("Expected only the json document for the author field, but got %v", res)
+	}
+	if res, _ := db.Query("derived"); len(res) != 1 || res[0].ID != 2 || res[0].Text != "derived text" {
+		t.Errorf("Expected the derived text of doc ID 2, but got %v", res)
+	}
+}
+
+func TestIndexJSONCustomTokenizer(t *testing.T) {
+	// a tokenizer keeping the whole text as a single token would produce tokens that look like field terms
+	db := NewDB(WithAnalyzer(Analyzer{Tokenizer: func(text string) []string { return []string{text} }}))
+	db.IndexJSON(0, []byte(`{"city": "york"}`))
+	db.Index(Document{ID: 1, Text: "city york"})
+	db.Index(Document{ID: 2, Text: "york"})
+
+	if res, _ := db.QueryField("city", "york"); len(res) != 1 || res[0].ID != 0 {
+		t.Errorf("Expected only the json document for the city field, but got %v", res)
+	}
+	if res, _ := db.Query("york"); len(res) != 2 || res[0].ID != 0 || res[1].ID != 2 {
+		t.Errorf("Expected doc IDs 0 and 2 for york, but got %v", res)
+	}
+	if tokens := db.analyzer.Tokens("city york"); len(tokens) != 0 {
+		t.Errorf("Expected the token holding a space to be dropped, but got %q", tokens)
+	}
+}
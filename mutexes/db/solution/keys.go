@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Add indexes the document under a new doc ID assigned by the db and returns the ID. IDs are assigned in increasing order and are never lower than any ID indexed before, so they do not collide with caller supplied IDs. The ID of doc is ignored, its Key must not be held by another live document.
+func (d *DB) Add(doc Document) (int, error) {
+	ops := []Op{{Type: OpIndex, Doc: doc, assign: true}}
+	if err := d.commit(ops); err != nil {
+		return 0, err
+	}
+	return ops[0].Doc.ID, nil
+}
+
+// assignIDs gives every operation requesting an ID the next free doc ID. IDs indexed explicitly by the same commit are skipped. Callers must hold the write lock.
+func (d *DB) assignIDs(ops []Op) {
+	next := d.nextID
+	for _, op := range ops {
+		if op.Type == OpIndex && !op.assign {
+			next = max(next, op.Doc.ID+1)
+		}
+	}
+	for i := range ops {
+		if ops[i].assign {
+			ops[i].Doc.ID = next
+			next++
+		}
+	}
+}
+
+// keyOwner returns the live document version holding the key. Callers must hold the lock.
+func (d *DB) keyOwner(key string) (docVersion, bool) {
+	for _, p := range d.keys[key] {
+		if v, exists := d.latest(p.id); exists && v.created == p.created {
+			return v, true
+		}
+	}
+	return docVersion{}, false
+}
+
+// GetByKey retrieves the document with the specified external key. An error is returned if no document holds the key
+func (d *DB) GetByKey(key string) (Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.GetByKey(key)
+}
+
+// GetByKey retrieves the document holding the key as of the snapshot, see DB.GetByKey
+func (s *Snapshot) GetByKey(key string) (Document, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, p := range s.db.keys[key] {
+		if s.visible(p) {
+			v, _ := s.lookup(p.id)
+			return v.doc, nil
+		}
+	}
+	return Document{}, fmt.Errorf("get: key %q not present", key)
+}
+
+// DeleteByKey removes the document with the specified external key. An error is returned if no document holds the key
+func (d *DB) DeleteByKey(key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	v, exists := d.keyOwner(key)
+	if !exists {
+		return fmt.Errorf("delete: key %q not present", key)
+	}
+	return d.commitLocked([]Op{{Type: OpDelete, ID: v.doc.ID}})
+}
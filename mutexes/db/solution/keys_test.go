@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	db := NewDB(WithWriteLog(10))
+
+	for i, text := range []string{"the queen of hearts", "the knave of hearts"} {
+		if id, err := db.Add(Document{ID: 42, Text: text}); err != nil || id != i {
+			t.Errorf("Expected ID %d, but got %d, %v", i, id, err)
+		}
+	}
+
+	// assigned IDs never collide with explicit ones
+	db.Index(Document{ID: 10, Text: "an explicit id"})
+	if id, _ := db.Add(Document{Text: "after the explicit id"}); id != 11 {
+		t.Errorf("Expected ID 11, but got %d", id)
+	}
+	db.Delete(11)
+	if id, _ := db.Add(Document{Text: "ids are not reused"}); id != 12 {
+		t.Errorf("Expected ID 12, but got %d", id)
+	}
+
+	b := db.NewBatch()
+	b.Add(Document{Text: "first of the batch"})
+	b.Index(Document{ID: 20, Text: "explicit in the batch"})
+	b.Add(Document{Text: "second of the batch"})
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Failed to commit, %v", err)
+	}
+	if ids := b.IDs(); len(ids) != 2 || ids[0] != 21 || ids[1] != 22 {
+		t.Errorf("Expected IDs [21 22], but got %v", ids)
+	}
+
+	// the write log holds the assigned IDs for followers
+	entries, _, _ := db.log.since(0)
+	last := entries[len(entries)-1]
+	if last.Ops[0].Doc.ID != 21 || last.Ops[0].assign {
+		t.Errorf("Expected the assigned ID in the log, but got %v", last.Ops[0])
+	}
+	follower := NewDB()
+	for _, e := range entries {
+		if err := follower.commit(e.Ops); err != nil {
+			t.Fatalf("Failed to apply entry %d, %v", e.Version, err)
+		}
+	}
+	compareDBs(t, db, follower)
+}
+
+func TestKeys(t *testing.T) {
+	db := NewDB()
+	id, err := db.Add(Document{Key: "alice.txt:1", Text: "down the rabbit hole"})
+	if err != nil {
+		t.Fatalf("Failed to add, %v", err)
+	}
+	if _, err := db.Add(Document{Key: "alice.txt:1", Text: "a duplicate key"}); err == nil {
+		t.Error("Should have returned an error for a duplicate key")
+	}
+	if err := db.Index(Document{ID: 100, Key: "alice.txt:1"}); err == nil {
+		t.Error("Should have returned an error for a duplicate key with an explicit ID")
+	}
+	if _, err := db.Add(Document{Key: "glass.txt:1", Text: "looking glass house"}); err != nil {
+		t.Errorf("Failed to add a second key, %v", err)
+	}
+
+	if doc, err := db.GetByKey("alice.txt:1"); err != nil || doc.ID != id || doc.Text != "down the rabbit hole" {
+		t.Errorf("Expected doc ID %d, but got %v, %v", id, doc, err)
+	}
+	if doc, err := db.Get(id); err != nil || doc.Key != "alice.txt:1" {
+		t.Errorf("Expected the key on the document, but got %v, %v", doc, err)
+	}
+	if _, err := db.GetByKey("missing"); err == nil {
+		t.Error("Should have returned an error for a missing key")
+	}
+
+	// a batch may move a key to a new document
+	s := db.Snapshot()
+	b := db.NewBatch()
+	b.Delete(id)
+	b.Add(Document{Key: "alice.txt:1", Text: "down the rabbit hole, rewritten"})
+	b.Add(Document{Key: "alice.txt:1", Text: "but only once"})
+	var be *BatchError
+	if err := b.Commit(); !errors.As(err, &be) || be.Op != 2 {
+		t.Fatalf("Expected the second use of the key to fail, but got %v", err)
+	}
+	b.Reset()
+	b.Delete(id)
+	b.Add(Document{Key: "alice.txt:1", Text: "down the rabbit hole, rewritten"})
+	if err := b.Commit(); err != nil {
+		t.Fatalf("Failed to move the key, %v", err)
+	}
+
+	if doc, _ := db.GetByKey("alice.txt:1"); doc.ID == id || doc.Text != "down the rabbit hole, rewritten" {
+		t.Errorf("Expected the new document for the key, but got %v", doc)
+	}
+	if doc, _ := s.GetByKey("alice.txt:1"); doc.ID != id {
+		t.Errorf("Expected the snapshot to see the old document, but got %v", doc)
+	}
+	s.Release()
+
+	if err := db.DeleteByKey("alice.txt:1"); err != nil {
+		t.Errorf("Failed to delete by key, %v", err)
+	}
+	if err := db.DeleteByKey("alice.txt:1"); err == nil {
+		t.Error("Should have returned an error deleting a missing key")
+	}
+	db.Vacuum()
+	if _, exists := db.keys["alice.txt:1"]; exists {
+		t.Errorf("Expected the key to be reclaimed, but got %v", db.keys["alice.txt:1"])
+	}
+}
+
+func TestSplitTextFileKeys(t *testing.T) {
+	shards, err := splitTextFile("../alice-in-wonderland.txt", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := NewDB()
+	for i, expected := range []bool{true, false} {
+		b := db.NewBatch()
+		for _, doc := range shards[1] {
+			b.Add(doc)
+		}
+		// adding the same lines again collides on the keys, not on the IDs
+		var be *BatchError
+		if err := b.Commit(); (err == nil) != expected || (err != nil && (!errors.As(err, &be) || be.Op != 0)) {
+			t.Errorf("Expected commit %d to succeed %v, but got %v", i, expected, err)
+		}
+	}
+
+	key := shards[1][0].Key
+	if doc, err := db.GetByKey(key); err != nil || doc.Text != shards[1][0].Text {
+		t.Errorf("Expected the first line of the shard for %s, but got %v, %v", key, doc, err)
+	}
+	if _, err := db.GetByKey(shards[0][0].Key); err == nil {
+		t.Errorf("Expected %s to be absent", shards[0][0].Key)
+	}
+}
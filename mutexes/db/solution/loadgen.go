@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadBatch is the number of documents indexed per batch when preloading the corpus
+const loadBatch = 256
+
+// LoadMix weighs the operations of a load run against each other, a zero weight leaves the operation out
+type LoadMix struct {
+	Query, Get, Index, Delete int
+}
+
+// DefaultLoadMix is a read heavy workload with a tenth of writes
+var DefaultLoadMix = LoadMix{Query: 45, Get: 45, Index: 8, Delete: 2}
+
+// LoadOptions configures RunLoad
+type LoadOptions struct {
+	// Corpus generates the documents preloaded into the db, those indexed during the run and the queries
+	Corpus CorpusOptions
+	// Workers is the number of goroutines running operations
+	Workers int
+	// Duration bounds the run, it stops early when Ops operations ran or the context is done
+	Duration time.Duration
+	// Ops bounds the total number of operations when not zero
+	Ops int
+	// Mix weighs the operations
+	Mix LoadMix
+	// QueryWords is the maximum number of words of a query
+	QueryWords int
+}
+
+// LatencyStats summarizes the latencies of one kind of operation
+type LatencyStats struct {
+	Count, Errors      int
+	P50, P90, P99, Max time.Duration
+}
+
+// newLatencyStats sorts the latencies and picks the percentiles
+func newLatencyStats(latencies []time.Duration, errors int) LatencyStats {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	s := LatencyStats{Count: len(latencies), Errors: errors}
+	if len(latencies) == 0 {
+		return s
+	}
+	pct := func(p float64) time.Duration {
+		return latencies[min(int(p*float64(len(latencies))), len(latencies)-1)]
+	}
+	s.P50, s.P90, s.P99, s.Max = pct(0.5), pct(0.9), pct(0.99), latencies[len(latencies)-1]
+	return s
+}
+
+// LoadReport is the outcome of RunLoad
+type LoadReport struct {
+	// Preload is the time it took to index the corpus before the run
+	Preload time.Duration
+	// Elapsed is the duration of the run itself
+	Elapsed                   time.Duration
+	Query, Get, Index, Delete LatencyStats
+}
+
+// Ops returns the number of operations run
+func (r LoadReport) Ops() int {
+	return r.Query.Count + r.Get.Count + r.Index.Count + r.Delete.Count
+}
+
+// Throughput returns the number of operations run per second
+func (r LoadReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops()) / r.Elapsed.Seconds()
+}
+
+// WriteTo writes the report as a table
+func (r LoadReport) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	write := func(format string, args ...any) error {
+		m, err := fmt.Fprintf(w, format, args...)
+		n += int64(m)
+		return err
+	}
+	if err := write("preload %v, ran %d ops in %v, %.0f ops/s\n", r.Preload.Round(time.Millisecond), r.Ops(), r.Elapsed.Round(time.Millisecond), r.Throughput()); err != nil {
+		return n, err
+	}
+	if err := write("%-8s %10s %8s %12s %12s %12s %12s\n", "op", "count", "errors", "p50", "p90", "p99", "max"); err != nil {
+		return n, err
+	}
+	for _, op := range []struct {
+		name  string
+		stats LatencyStats
+	}{{"query", r.Query}, {"get", r.Get}, {"index", r.Index}, {"delete", r.Delete}} {
+		s := op.stats
+		if err := write("%-8s %10d %8d %12v %12v %12v %12v\n", op.name, s.Count, s.Errors, s.P50, s.P90, s.P99, s.Max); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// loadWorker holds the latencies recorded by one worker of a load run
+type loadWorker struct {
+	latencies [4][]time.Duration
+	errors    [4]int
+}
+
+// the operations of a load run, indexing loadWorker
+const (
+	loadQuery = iota
+	loadGet
+	loadIndex
+	loadDelete
+)
+
+// RunLoad indexes a generated corpus into db and then runs a mix of queries, gets, indexes and deletes from several workers, recording the latency of every operation. Gets read the preloaded documents, which are never deleted, and deletes only remove documents indexed during the run, so errors point at real failures. The db should not hold documents with the IDs of the corpus or above it.
+func RunLoad(ctx context.Context, db *DB, opts LoadOptions) (LoadReport, error) {
+	opts.Workers = max(opts.Workers, 1)
+	if opts.Mix == (LoadMix{}) {
+		opts.Mix = DefaultLoadMix
+	}
+	if opts.QueryWords <= 0 {
+		opts.QueryWords = 3
+	}
+	if opts.Duration <= 0 && opts.Ops <= 0 {
+		return LoadReport{}, fmt.Errorf("load: expected a duration or a number of ops")
+	}
+	weights := [4]int{opts.Mix.Query, opts.Mix.Get, opts.Mix.Index, opts.Mix.Delete}
+	var total int
+	for _, w := range weights {
+		if w < 0 {
+			return LoadReport{}, fmt.Errorf("load: negative weight in mix %+v", opts.Mix)
+		}
+		total += w
+	}
+
+	var report LoadReport
+	corpus := NewCorpus(opts.Corpus)
+	preloaded := corpus.Options().Docs
+	start := time.Now()
+	for i, docs := 0, corpus.Docs(); i < len(docs); i += loadBatch {
+		b := db.NewBatch()
+		for _, doc := range docs[i:min(i+loadBatch, len(docs))] {
+			b.Index(doc)
+		}
+		if err := b.Commit(); err != nil {
+			return report, fmt.Errorf("load: preload: %v", err)
+		}
+	}
+	report.Preload = time.Since(start)
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	var ops atomic.Int64
+	var nextID atomic.Int64
+	nextID.Store(int64(preloaded))
+
+	workers := make([]loadWorker, opts.Workers)
+	var wg sync.WaitGroup
+	wg.Add(len(workers))
+	start = time.Now()
+	for i := range workers {
+		go func(w *loadWorker, seed int64) {
+			defer wg.Done()
+			wopts := corpus.Options()
+			wopts.Seed = seed
+			c := NewCorpus(wopts)
+			rng := rand.New(rand.NewSource(seed))
+			// the documents this worker indexed and did not delete yet
+			var live []int
+			for ctx.Err() == nil && (opts.Ops <= 0 || ops.Add(1) <= int64(opts.Ops)) {
+				op := loadQuery
+				for r := rng.Intn(total); r >= weights[op]; op++ {
+					r -= weights[op]
+				}
+				if op == loadDelete && len(live) == 0 {
+					op = loadIndex
+				}
+				if op == loadGet && preloaded == 0 {
+					op = loadQuery
+				}
+
+				var err error
+				began := time.Now()
+				switch op {
+				case loadQuery:
+					query := c.Query(opts.QueryWords)
+					began = time.Now()
+					_, err = db.Query(query)
+				case loadGet:
+					_, err = db.Get(rng.Intn(preloaded))
+				case loadIndex:
+					doc := Document{ID: int(nextID.Add(1) - 1), Text: c.Text()}
+					began = time.Now()
+					if err = db.Index(doc); err == nil {
+						live = append(live, doc.ID)
+					}
+				case loadDelete:
+					i := rng.Intn(len(live))
+					id := live[i]
+					live[i] = live[len(live)-1]
+					live = live[:len(live)-1]
+					err = db.Delete(id)
+				}
+				w.latencies[op] = append(w.latencies[op], time.Since(began))
+				if err != nil {
+					w.errors[op]++
+				}
+			}
+		}(&workers[i], opts.Corpus.Seed+int64(i)+1)
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	stats := make([]LatencyStats, 4)
+	for op := range stats {
+		var latencies []time.Duration
+		var errors int
+		for _, w := range workers {
+			latencies = append(latencies, w.latencies[op]...)
+			errors += w.errors[op]
+		}
+		stats[op] = newLatencyStats(latencies, errors)
+	}
+	report.Query, report.Get, report.Index, report.Delete = stats[loadQuery], stats[loadGet], stats[loadIndex], stats[loadDelete]
+	return report, nil
+}
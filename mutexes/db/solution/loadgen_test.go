@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunLoad(t *testing.T) {
+	db := NewDB()
+	opts := LoadOptions{
+		Corpus:  CorpusOptions{Docs: 1000, Vocabulary: 2000, Seed: 3},
+		Workers: 4,
+		Ops:     4000,
+		Mix:     LoadMix{Query: 4, Get: 4, Index: 3, Delete: 1},
+	}
+	report, err := RunLoad(context.Background(), db, opts)
+	if err != nil {
+		t.Fatalf("Failed to run load, %v", err)
+	}
+	if report.Ops() != opts.Ops {
+		t.Errorf("Expected %d ops, but got %d", opts.Ops, report.Ops())
+	}
+	for name, s := range map[string]LatencyStats{"query": report.Query, "get": report.Get, "index": report.Index, "delete": report.Delete} {
+		if s.Count == 0 || s.Errors != 0 {
+			t.Errorf("Expected %s ops without errors, but got %+v", name, s)
+		}
+		if s.P50 > s.P90 || s.P90 > s.P99 || s.P99 > s.Max {
+			t.Errorf("Expected ordered %s percentiles, but got %+v", name, s)
+		}
+	}
+	s := db.Snapshot()
+	if n := s.numDocs(); n != 1000+report.Index.Count-report.Delete.Count {
+		t.Errorf("Expected %d documents, but got %d", 1000+report.Index.Count-report.Delete.Count, n)
+	}
+	s.Release()
+
+	// a duration bounds the run
+	began := time.Now()
+	opts.Ops = 0
+	opts.Duration = 50 * time.Millisecond
+	if _, err := RunLoad(context.Background(), NewDB(), opts); err != nil || time.Since(began) > 5*time.Second {
+		t.Errorf("Expected the run to stop after its duration, but got %v after %v", err, time.Since(began))
+	}
+
+	errs := []LoadOptions{
+		{Corpus: opts.Corpus},
+		{Corpus: opts.Corpus, Ops: 1, Mix: LoadMix{Query: -1, Get: 2}},
+	}
+	for i, opts := range errs {
+		if _, err := RunLoad(context.Background(), NewDB(), opts); err == nil {
+			t.Errorf("Should have returned an error for options %d", i)
+		}
+	}
+}
+
+func TestLoadCLI(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := run([]string{"gen", "-docs", "5", "-seed", "2"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); len(lines) != 5 {
+		t.Errorf("Expected 5 documents, but got %q", lines)
+	}
+
+	stdout.Reset()
+	if err := run([]string{"load", "-docs", "200", "-ops", "500", "-workers", "2", "-mix", "1,1,1,0"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "ran 500 ops") || !strings.Contains(out, "p99") {
+		t.Errorf("Expected a report of 500 ops, but got %q", out)
+	}
+	if err := run([]string{"load", "-mix", "1,2"}, &stdout); err == nil {
+		t.Error("Should have returned an error for a bad mix")
+	}
+}
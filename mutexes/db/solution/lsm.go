@@ -0,0 +1,931 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mergeFactor is the number of segments of the same tier that are merged into one segment of the next tier
+	mergeFactor = 4
+	// tombstoneMagic starts a tombstone file
+	tombstoneMagic = "GSDBDEL1"
+)
+
+// SegmentedDB is an LSM-style index for continuous ingestion. Writes go to a small mutable in-memory DB, the memtable, which is frozen and flushed to an immutable segment file once it holds flushSize writes. Every write is appended to the write-ahead log of its memtable and synced before it is applied, so writes that returned survive a crash: the logs of memtables that were not flushed are replayed when the db is opened again. Deletes of documents that live in older segments are recorded as tombstones next to the segment of the deleting generation. Queries span the memtable, frozen memtables waiting to be flushed and every segment, with newer generations shadowing older ones. A background worker flushes frozen memtables and merges segments with a tiered policy: once mergeFactor adjacent segments fall into the same size tier they are compacted into one. Readers hold on to the segments they use, so merges never block them.
+type SegmentedDB struct {
+	dir       string
+	opts      []Option
+	analyzer  Analyzer
+	now       func() time.Time
+	flushSize int
+
+	mu       sync.RWMutex
+	nextGen  uint64
+	mem      *lsmPart
+	memOps   int
+	frozen   []*lsmPart
+	segments []*lsmPart
+	err      error
+
+	// keys maps external keys to the doc IDs holding them and is built on the first write of a keyed document. Entries are checked against find, so deleted and expired holders may linger.
+	keys map[string]int
+
+	// closed is set once Close started, later writes are rejected
+	closed    bool
+	closeOnce sync.Once
+	closeErr  error
+
+	// work serializes flushes and merges
+	work sync.Mutex
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// lsmPart is one generation range of a SegmentedDB, either an in-memory DB or a segment file. Its tombstones delete documents of older generations. Only the tombstones of the memtable change, under the SegmentedDB write lock.
+type lsmPart struct {
+	minGen, maxGen uint64
+	db             *DB
+	seg            *Segment
+	path           string
+	tombstones     map[int]struct{}
+	// wal is the open write-ahead log of the memtable, it is closed once the memtable is frozen
+	wal *os.File
+
+	// refs counts the open views reading the segment and retired is set once it was merged away
+	refs    int
+	retired bool
+}
+
+// OpenSegmentedDB opens the segmented db stored in dir, creating the directory if needed, and recovers the writes logged but not flushed before a crash. The options configure the memtables. The memtable is flushed after flushSize writes.
+func OpenSegmentedDB(dir string, flushSize int, opts ...Option) (*SegmentedDB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("open segmented db: %v", err)
+	}
+	defaults := NewDB(opts...)
+	l := &SegmentedDB{
+		dir:       dir,
+		opts:      opts,
+		analyzer:  defaults.analyzer,
+		now:       defaults.now,
+		flushSize: max(flushSize, 1),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if err := l.load(); err != nil {
+		l.closeSegments()
+		return nil, fmt.Errorf("open segmented db: %v", err)
+	}
+	if err := l.recoverLogs(); err != nil {
+		l.closeSegments()
+		return nil, fmt.Errorf("open segmented db: %v", err)
+	}
+	l.mem = l.newMemtable(nil)
+
+	l.wg.Add(1)
+	go l.background()
+	return l, nil
+}
+
+// segmentName returns the file name of the segment covering the generations
+func segmentName(minGen, maxGen uint64) string {
+	return fmt.Sprintf("%08d-%08d.seg", minGen, maxGen)
+}
+
+// walName returns the file name of the write-ahead log of the memtable of a generation
+func walName(gen uint64) string {
+	return fmt.Sprintf("%08d.wal", gen)
+}
+
+// tombstonePath returns the path of the tombstone file of a segment
+func tombstonePath(segPath string) string {
+	return strings.TrimSuffix(segPath, ".seg") + ".del"
+}
+
+// load opens the segments in the directory. A segment covered by a wider segment is left over from a merge that did not finish cleaning up and is removed.
+func (l *SegmentedDB) load() error {
+	leftovers, _ := filepath.Glob(filepath.Join(l.dir, "*.tmp*"))
+	for _, p := range leftovers {
+		os.Remove(p)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*.seg"))
+	if err != nil {
+		return err
+	}
+	var parts []*lsmPart
+	for _, p := range paths {
+		var minGen, maxGen uint64
+		if _, err := fmt.Sscanf(filepath.Base(p), "%d-%d.seg", &minGen, &maxGen); err != nil || minGen > maxGen {
+			continue
+		}
+		parts = append(parts, &lsmPart{minGen: minGen, maxGen: maxGen, path: p})
+	}
+	// widest first among segments ending at the same generation
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].maxGen != parts[j].maxGen {
+			return parts[i].maxGen < parts[j].maxGen
+		}
+		return parts[i].minGen < parts[j].minGen
+	})
+
+	l.nextGen = 1
+	for _, p := range parts {
+		if n := len(l.segments); n > 0 && p.minGen <= l.segments[n-1].maxGen {
+			// covered by the previous wider segment or covering previous segments
+			for n > 0 && l.segments[n-1].minGen >= p.minGen {
+				removeSegmentFiles(l.segments[n-1].path)
+				n--
+			}
+			if n > 0 && l.segments[n-1].maxGen >= p.minGen {
+				removeSegmentFiles(p.path)
+				continue
+			}
+			l.segments = l.segments[:n]
+		}
+		l.segments = append(l.segments, p)
+	}
+
+	for _, p := range l.segments {
+		if err := l.openPart(p); err != nil {
+			return err
+		}
+		l.nextGen = p.maxGen + 1
+	}
+	return nil
+}
+
+// openPart opens the segment and tombstone files of a part
+func (l *SegmentedDB) openPart(p *lsmPart) error {
+	seg, err := OpenSegment(p.path)
+	if err != nil {
+		return err
+	}
+	seg.Analyzer = l.analyzer
+	seg.Clock = l.now
+	p.seg = seg
+
+	p.tombstones, err = readTombstones(tombstonePath(p.path))
+	return err
+}
+
+// newMemtable creates the memtable of the next generation. The schema of the previous memtable is carried over so field types stay consistent across segments. Callers must hold the write lock or be the only user.
+func (l *SegmentedDB) newMemtable(prev *lsmPart) *lsmPart {
+	db := NewDB(l.opts...)
+	if prev != nil {
+		for p, t := range prev.db.schema {
+			db.schema[p] = t
+		}
+	}
+	gen := l.nextGen
+	l.nextGen++
+	return &lsmPart{minGen: gen, maxGen: gen, db: db, tombstones: make(map[int]struct{})}
+}
+
+// has reports whether the part holds the document
+func (p *lsmPart) has(id int) bool {
+	if p.db != nil {
+		_, err := p.db.Get(id)
+		return err == nil
+	}
+	return p.seg.Has(id)
+}
+
+// current returns the latest version of the document held by the part, even when it expired
+func (p *lsmPart) current(id int) (Document, bool, error) {
+	if p.db != nil {
+		p.db.mu.RLock()
+		defer p.db.mu.RUnlock()
+		v, exists := p.db.latest(id)
+		return v.doc, exists, nil
+	}
+	return p.seg.current(id)
+}
+
+// find returns the latest version of the document looking through the parts from newest to oldest. An expired version shadows older generations like a tombstone. Callers must hold the lock.
+func (l *SegmentedDB) find(id int) (Document, bool, error) {
+	now := l.now()
+	for _, p := range l.parts() {
+		doc, exists, err := p.current(id)
+		if err != nil {
+			return Document{}, false, err
+		}
+		if exists {
+			if doc.expiredAt(now) {
+				return Document{}, false, nil
+			}
+			return doc, true, nil
+		}
+		if _, deleted := p.tombstones[id]; deleted {
+			return Document{}, false, nil
+		}
+	}
+	return Document{}, false, nil
+}
+
+// parts returns every part from newest to oldest. Callers must hold the lock.
+func (l *SegmentedDB) parts() []*lsmPart {
+	parts := make([]*lsmPart, 0, 1+len(l.frozen)+len(l.segments))
+	parts = append(parts, l.mem)
+	for i := len(l.frozen) - 1; i >= 0; i-- {
+		parts = append(parts, l.frozen[i])
+	}
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		parts = append(parts, l.segments[i])
+	}
+	return parts
+}
+
+// Index adds the document to the memtable. An error is returned if a document with the same ID or key is already present in any generation.
+func (l *SegmentedDB) Index(doc Document) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.writable(); err != nil {
+		return err
+	}
+	if _, exists, err := l.find(doc.ID); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("Document id %d already present in db", doc.ID)
+	}
+	if doc.Key != "" {
+		if err := l.checkKey(doc.Key); err != nil {
+			return err
+		}
+	}
+	if err := l.write(Op{Type: OpIndex, Doc: doc}); err != nil {
+		return err
+	}
+	if doc.Key != "" {
+		l.keys[doc.Key] = doc.ID
+	}
+	return nil
+}
+
+// checkKey returns an error if a live document of any generation holds the key. Callers must hold the write lock.
+func (l *SegmentedDB) checkKey(key string) error {
+	if l.keys == nil {
+		if err := l.loadKeys(); err != nil {
+			return err
+		}
+	}
+	if id, exists := l.keys[key]; exists {
+		doc, live, err := l.find(id)
+		if err != nil {
+			return err
+		}
+		if live && doc.Key == key {
+			return fmt.Errorf("Document key %q already present in db", key)
+		}
+	}
+	return nil
+}
+
+// loadKeys builds the key map from the live documents of every generation. Callers must hold the write lock.
+func (l *SegmentedDB) loadKeys() error {
+	keys := make(map[string]int)
+	add := func(doc Document) error {
+		if doc.Key == "" {
+			return nil
+		}
+		if _, exists := keys[doc.Key]; exists {
+			return nil
+		}
+		live, exists, err := l.find(doc.ID)
+		if err == nil && exists && live.Key == doc.Key {
+			keys[doc.Key] = doc.ID
+		}
+		return err
+	}
+
+	for _, p := range l.parts() {
+		if p.db != nil {
+			s := p.db.Snapshot()
+			for _, id := range s.ids() {
+				doc, _ := s.Get(id)
+				if err := add(doc); err != nil {
+					s.Release()
+					return err
+				}
+			}
+			s.Release()
+			continue
+		}
+		for doc, err := range p.seg.Docs() {
+			if err == nil {
+				err = add(doc)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	l.keys = keys
+	return nil
+}
+
+// Delete removes the document from the memtable, or records a tombstone when it lives in an older generation
+func (l *SegmentedDB) Delete(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.writable(); err != nil {
+		return err
+	}
+	if _, exists, err := l.find(id); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("delete: id %d not present", id)
+	}
+	return l.write(Op{Type: OpDelete, ID: id})
+}
+
+// writable returns an error once the db is closed or a background flush or merge failed, writes would otherwise pile up in frozen memtables that are never flushed. Callers must hold the lock.
+func (l *SegmentedDB) writable() error {
+	if l.closed {
+		return fmt.Errorf("segmented db is closed")
+	}
+	return l.err
+}
+
+// write logs the operation and applies it to the memtable, which is frozen once it is full. Only writes that apply cleanly are logged, so replaying the log never depends on state the replay lacks. Callers must hold the write lock.
+func (l *SegmentedDB) write(op Op) error {
+	if err := l.mem.validate(op); err != nil {
+		return err
+	}
+	if err := l.logWrite(op); err != nil {
+		return err
+	}
+	if err := l.mem.apply(op); err != nil {
+		return err
+	}
+	if l.memOps++; l.memOps >= l.flushSize {
+		l.freeze()
+	}
+	return nil
+}
+
+// validate returns the error apply would return for the write without applying it
+func (p *lsmPart) validate(op Op) error {
+	if op.Type == OpDelete && !p.has(op.ID) {
+		return nil
+	}
+	p.db.mu.RLock()
+	defer p.db.mu.RUnlock()
+	return p.db.validate([]Op{op})
+}
+
+// apply applies a write to a memtable. A delete of a document the memtable does not hold is recorded as a tombstone for older generations, an older version held by the memtable itself was deleted before and is already covered by a tombstone.
+func (p *lsmPart) apply(op Op) error {
+	switch op.Type {
+	case OpIndex:
+		return p.db.Index(op.Doc)
+	case OpDelete:
+		if p.has(op.ID) {
+			return p.db.Delete(op.ID)
+		}
+		p.tombstones[op.ID] = struct{}{}
+		return nil
+	}
+	return fmt.Errorf("apply: unknown operation type %d", op.Type)
+}
+
+// logWrite appends the operation to the write-ahead log of the memtable and syncs it, creating the log on the first write. A record is the uint32 length and CRC-32C of its payload followed by the payload, a JSON encoded LogEntry. Callers must hold the write lock.
+func (l *SegmentedDB) logWrite(op Op) error {
+	p := l.mem
+	if p.wal == nil {
+		f, err := os.OpenFile(filepath.Join(l.dir, walName(p.minGen)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return fmt.Errorf("write log: %v", err)
+		}
+		p.wal = f
+	}
+
+	rec, err := json.Marshal(LogEntry{Version: uint64(l.memOps + 1), Ops: []Op{op}})
+	if err != nil {
+		return fmt.Errorf("write log: %v", err)
+	}
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(len(rec)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(rec, crcTable))
+	buf = append(buf, rec...)
+	if _, err := p.wal.Write(buf); err != nil {
+		return fmt.Errorf("write log: %v", err)
+	}
+	if err := p.wal.Sync(); err != nil {
+		return fmt.Errorf("write log: %v", err)
+	}
+	return nil
+}
+
+// readLog calls apply for every operation of the write-ahead log at path. A torn or corrupt record ends the log, it was being written when the process stopped and its write never returned.
+func readLog(path string, apply func(Op)) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for len(data) >= 8 {
+		n, sum := binary.LittleEndian.Uint32(data), binary.LittleEndian.Uint32(data[4:])
+		if uint64(len(data)-8) < uint64(n) {
+			break
+		}
+		rec := data[8 : 8+n]
+		var e LogEntry
+		if crc32.Checksum(rec, crcTable) != sum || json.Unmarshal(rec, &e) != nil {
+			break
+		}
+		for _, op := range e.Ops {
+			apply(op)
+		}
+		data = data[8+n:]
+	}
+	return nil
+}
+
+// recoverLogs replays the write-ahead logs of memtables that were not flushed into frozen memtables and flushes them. Logs of generations that were flushed already are removed.
+func (l *SegmentedDB) recoverLogs() error {
+	paths, err := filepath.Glob(filepath.Join(l.dir, "*.wal"))
+	if err != nil {
+		return err
+	}
+	// generation numbers are zero padded, so the names sort in generation order
+	sort.Strings(paths)
+	for _, path := range paths {
+		var gen uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%d.wal", &gen); err != nil {
+			continue
+		}
+		if gen < l.nextGen {
+			os.Remove(path)
+			continue
+		}
+		p := &lsmPart{minGen: gen, maxGen: gen, db: NewDB(l.opts...), tombstones: make(map[int]struct{})}
+		if n := len(l.frozen); n > 0 {
+			for path, t := range l.frozen[n-1].db.schema {
+				p.db.schema[path] = t
+			}
+		}
+		// only writes that applied were logged, they apply again on top of the generations before them
+		if err := readLog(path, func(op Op) { p.apply(op) }); err != nil {
+			return err
+		}
+		l.frozen = append(l.frozen, p)
+		l.nextGen = gen + 1
+	}
+	return l.flushFrozen()
+}
+
+// freeze queues the memtable for flushing and starts a new one. Callers must hold the write lock.
+func (l *SegmentedDB) freeze() {
+	if l.memOps == 0 {
+		return
+	}
+	if l.mem.wal != nil {
+		l.mem.wal.Close()
+		l.mem.wal = nil
+	}
+	l.frozen = append(l.frozen, l.mem)
+	l.mem = l.newMemtable(l.mem)
+	l.memOps = 0
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get retrieves the latest version of the document with the specified doc ID. An error is returned if the document is not present
+func (l *SegmentedDB) Get(id int) (Document, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	doc, exists, err := l.find(id)
+	if err != nil {
+		return Document{}, err
+	}
+	if !exists {
+		return Document{}, fmt.Errorf("get: id %d not present", id)
+	}
+	return doc, nil
+}
+
+// lsmView is a consistent read view over the parts of a SegmentedDB from newest to oldest. In-memory parts are read through snapshots, segments are kept open until the view is released.
+type lsmView struct {
+	l          *SegmentedDB
+	parts      []*lsmPart
+	snapshots  []*Snapshot
+	tombstones []map[int]struct{}
+}
+
+// view acquires a read view of the latest state
+func (l *SegmentedDB) view() *lsmView {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v := &lsmView{l: l, parts: l.parts()}
+	v.snapshots = make([]*Snapshot, len(v.parts))
+	v.tombstones = make([]map[int]struct{}, len(v.parts))
+	for i, p := range v.parts {
+		v.tombstones[i] = p.tombstones
+		if p.db != nil {
+			v.snapshots[i] = p.db.Snapshot()
+		} else {
+			p.refs++
+		}
+	}
+	// the memtable tombstones keep changing, copy them
+	mem := make(map[int]struct{}, len(l.mem.tombstones))
+	for id := range l.mem.tombstones {
+		mem[id] = struct{}{}
+	}
+	v.tombstones[0] = mem
+	return v
+}
+
+// release gives up the view, closing segments that were merged away in the meantime
+func (v *lsmView) release() {
+	for _, s := range v.snapshots {
+		if s != nil {
+			s.Release()
+		}
+	}
+
+	l := v.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range v.parts {
+		if p.seg != nil && p.db == nil {
+			p.refs--
+			l.maybeDrop(p)
+		}
+	}
+}
+
+// shadowed reports whether a document found in part i is replaced, deleted or expired by a newer part
+func (v *lsmView) shadowed(i, id int) bool {
+	for j := 0; j < i; j++ {
+		if _, deleted := v.tombstones[j][id]; deleted {
+			return true
+		}
+		if v.snapshots[j] != nil {
+			if v.snapshots[j].holds(id) {
+				return true
+			}
+		} else if v.parts[j].seg.Has(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query returns the unique documents containing any token of the query string across all generations, sorted by doc ID
+func (l *SegmentedDB) Query(term string) ([]Document, error) {
+	v := l.view()
+	defer v.release()
+
+	res := []Document{}
+	for i := range v.parts {
+		var docs []Document
+		var err error
+		if v.snapshots[i] != nil {
+			docs, err = v.snapshots[i].Query(term)
+		} else {
+			docs, err = v.parts[i].seg.Query(term)
+		}
+		if err != nil {
+			return []Document{}, err
+		}
+		for _, doc := range docs {
+			if !v.shadowed(i, doc.ID) {
+				res = append(res, doc)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// Segments returns the number of segment files currently in use
+func (l *SegmentedDB) Segments() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.segments)
+}
+
+// Flush freezes the memtable and writes every frozen memtable to a segment file
+func (l *SegmentedDB) Flush() error {
+	l.mu.Lock()
+	l.freeze()
+	l.mu.Unlock()
+
+	l.work.Lock()
+	defer l.work.Unlock()
+	return l.flushFrozen()
+}
+
+// Close stops background merging, flushes the memtable and closes every segment. Closing more than once returns the result of the first Close.
+func (l *SegmentedDB) Close() error {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		l.closed = true
+		l.mu.Unlock()
+
+		close(l.done)
+		l.wg.Wait()
+		err := l.Flush()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.closeSegments()
+		if l.mem.wal != nil {
+			l.mem.wal.Close()
+		}
+		if err == nil {
+			err = l.err
+		}
+		l.closeErr = err
+	})
+	return l.closeErr
+}
+
+// closeSegments closes every open segment
+func (l *SegmentedDB) closeSegments() {
+	for _, p := range l.segments {
+		if p.seg != nil {
+			p.seg.Close()
+		}
+	}
+}
+
+// background flushes and merges whenever the memtable was frozen
+func (l *SegmentedDB) background() {
+	defer l.wg.Done()
+	for {
+		select {
+		case <-l.wake:
+		case <-l.done:
+			return
+		}
+
+		l.work.Lock()
+		err := l.flushFrozen()
+		if err == nil {
+			err = l.merge()
+		}
+		l.work.Unlock()
+
+		if err != nil {
+			l.mu.Lock()
+			l.err = err
+			l.mu.Unlock()
+		}
+	}
+}
+
+// flushFrozen writes the frozen memtables to segment files, oldest first. Callers must hold the work lock.
+func (l *SegmentedDB) flushFrozen() error {
+	for {
+		l.mu.RLock()
+		if len(l.frozen) == 0 {
+			l.mu.RUnlock()
+			return nil
+		}
+		p := l.frozen[0]
+		l.mu.RUnlock()
+
+		// documents that expired in the memtable are not written to the segment, tombstones keep them from resurfacing from older segments
+		tombstones := make(map[int]struct{}, len(p.tombstones))
+		for id := range p.tombstones {
+			tombstones[id] = struct{}{}
+		}
+		snap := p.db.Snapshot()
+		for _, id := range snap.expired() {
+			tombstones[id] = struct{}{}
+		}
+		snap.Release()
+
+		path := filepath.Join(l.dir, segmentName(p.minGen, p.maxGen))
+		if err := writeTombstones(tombstonePath(path), tombstones); err != nil {
+			return fmt.Errorf("flush: %v", err)
+		}
+		if err := p.db.WriteSegment(path); err != nil {
+			return fmt.Errorf("flush: %v", err)
+		}
+		flushed := &lsmPart{minGen: p.minGen, maxGen: p.maxGen, path: path}
+		if err := l.openPart(flushed); err != nil {
+			return fmt.Errorf("flush: %v", err)
+		}
+
+		l.mu.Lock()
+		l.frozen = l.frozen[1:]
+		l.segments = append(l.segments, flushed)
+		l.mu.Unlock()
+		// the segment is durable, the writes no longer need replaying
+		os.Remove(filepath.Join(l.dir, walName(p.minGen)))
+	}
+}
+
+// tier returns the size tier of a segment. Tier 0 holds up to flushSize documents and every tier holds mergeFactor times more than the one below.
+func (l *SegmentedDB) tier(p *lsmPart) int {
+	t, limit := 0, l.flushSize
+	for n := p.seg.NumDocs(); n > limit; limit *= mergeFactor {
+		t++
+	}
+	return t
+}
+
+// merge compacts runs of mergeFactor adjacent segments of the same tier until there are none left. Callers must hold the work lock.
+func (l *SegmentedDB) merge() error {
+	for {
+		l.mu.RLock()
+		var run []*lsmPart
+		start := -1
+		for i, p := range l.segments {
+			if len(run) > 0 && l.tier(run[0]) != l.tier(p) {
+				run = run[:0]
+			}
+			if len(run) == 0 {
+				start = i
+			}
+			run = append(run, p)
+			if len(run) == mergeFactor {
+				break
+			}
+		}
+		l.mu.RUnlock()
+		if len(run) < mergeFactor {
+			return nil
+		}
+
+		merged, err := l.mergeParts(run, start == 0)
+		if err != nil {
+			return fmt.Errorf("merge: %v", err)
+		}
+
+		// only the worker replaces segments, so the run is still at start
+		l.mu.Lock()
+		segments := append([]*lsmPart(nil), l.segments[:start]...)
+		segments = append(segments, merged)
+		l.segments = append(segments, l.segments[start+len(run):]...)
+		for _, p := range run {
+			p.retired = true
+			l.maybeDrop(p)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// mergeParts writes the live documents of adjacent segments, oldest first, to a single segment. Expired documents are dropped and turned into tombstones. Tombstones are kept to shadow older segments unless the oldest segment is part of the merge.
+func (l *SegmentedDB) mergeParts(run []*lsmPart, oldest bool) (*lsmPart, error) {
+	db := NewDB(l.opts...)
+	now := l.now()
+	tombstones := make(map[int]struct{})
+	var docs []Document
+	for i := len(run) - 1; i >= 0; i-- {
+		for doc, err := range run[i].seg.Docs() {
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := tombstones[doc.ID]; exists {
+				continue
+			}
+			shadowed := false
+			for _, newer := range run[i+1:] {
+				if newer.seg.Has(doc.ID) {
+					shadowed = true
+					break
+				}
+			}
+			if shadowed {
+				continue
+			}
+			if doc.expiredAt(now) {
+				tombstones[doc.ID] = struct{}{}
+				continue
+			}
+			docs = append(docs, doc)
+		}
+		for id := range run[i].tombstones {
+			tombstones[id] = struct{}{}
+		}
+	}
+	db.restore(docs)
+	if oldest {
+		tombstones = nil
+	}
+
+	merged := &lsmPart{minGen: run[0].minGen, maxGen: run[len(run)-1].maxGen}
+	merged.path = filepath.Join(l.dir, segmentName(merged.minGen, merged.maxGen))
+	if err := writeTombstones(tombstonePath(merged.path), tombstones); err != nil {
+		return nil, err
+	}
+	if err := db.WriteSegment(merged.path); err != nil {
+		return nil, err
+	}
+	if err := l.openPart(merged); err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// maybeDrop closes and removes a merged away segment once no view reads it anymore. Callers must hold the write lock.
+func (l *SegmentedDB) maybeDrop(p *lsmPart) {
+	if p.retired && p.refs == 0 {
+		p.seg.Close()
+		removeSegmentFiles(p.path)
+	}
+}
+
+// removeSegmentFiles removes a segment file and its tombstones
+func removeSegmentFiles(path string) {
+	os.Remove(path)
+	os.Remove(tombstonePath(path))
+}
+
+// restore loads documents into the db in a single version without validating them, they were validated when first indexed
+func (d *DB) restore(docs []Document) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.version++
+	for _, doc := range docs {
+		d.insert(doc, d.version)
+	}
+}
+
+// writeTombstones writes the deleted doc IDs to path: tombstoneMagic, uvarint count, the sorted IDs as varint deltas and a CRC-32C of everything before. No file is written for an empty set.
+func writeTombstones(path string, tombstones map[int]struct{}) error {
+	os.Remove(path)
+	if len(tombstones) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(tombstones))
+	for id := range tombstones {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	buf := []byte(tombstoneMagic)
+	buf = binary.AppendUvarint(buf, uint64(len(ids)))
+	prev := 0
+	for _, id := range ids {
+		buf = binary.AppendVarint(buf, int64(id-prev))
+		prev = id
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readTombstones reads a tombstone file written by writeTombstones. A missing file holds no tombstones.
+func readTombstones(path string) (map[int]struct{}, error) {
+	tombstones := make(map[int]struct{})
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return tombstones, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(tombstoneMagic)+4 || string(data[:len(tombstoneMagic)]) != tombstoneMagic {
+		return nil, fmt.Errorf("%s: not a tombstone file", path)
+	}
+	body := data[:len(data)-4]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, fmt.Errorf("%s: checksum mismatch", path)
+	}
+
+	r := bytes.NewReader(body[len(tombstoneMagic):])
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	prev := int64(0)
+	for i := uint64(0); i < n; i++ {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		prev += delta
+		tombstones[int(prev)] = struct{}{}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%s: %v", path, io.ErrUnexpectedEOF)
+	}
+	return tombstones, nil
+}
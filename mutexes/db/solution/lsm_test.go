@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// settle flushes the memtable and runs every pending merge
+func settle(t *testing.T, l *SegmentedDB) {
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Failed to flush, %v", err)
+	}
+	l.work.Lock()
+	defer l.work.Unlock()
+	if err := l.merge(); err != nil {
+		t.Fatalf("Failed to merge, %v", err)
+	}
+}
+
+// compareLSM checks that the segmented db returns the same results as the reference db
+func compareLSM(t *testing.T, expected *DB, actual *SegmentedDB, queries []string) {
+	for _, q := range queries {
+		e, _ := expected.Query(q)
+		sort.Slice(e, func(i, j int) bool { return e[i].ID < e[j].ID })
+		a, err := actual.Query(q)
+		if err != nil || len(a) != len(e) {
+			t.Errorf("Expected %d results for %s, but got %d, %v", len(e), q, len(a), err)
+			continue
+		}
+		for i := range e {
+			if a[i] != e[i] {
+				t.Errorf("Expected %v for %s, but got %v", e[i], q, a[i])
+			}
+		}
+	}
+
+	s := expected.Snapshot()
+	defer s.Release()
+	for _, id := range s.ids() {
+		e, _ := s.Get(id)
+		if a, err := actual.Get(id); err != nil || a != e {
+			t.Errorf("Expected %v, but got %v, %v", e, a, err)
+		}
+	}
+}
+
+func TestSegmentedDB(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenSegmentedDB(dir, 10)
+	if err != nil {
+		t.Fatalf("Failed to open, %v", err)
+	}
+	expected := NewDB()
+
+	apply := func(index bool, doc Document) {
+		var errE, errA error
+		if index {
+			errE, errA = expected.Index(doc), l.Index(doc)
+		} else {
+			errE, errA = expected.Delete(doc.ID), l.Delete(doc.ID)
+		}
+		if (errE == nil) != (errA == nil) {
+			t.Fatalf("Expected error %v for %v, but got %v", errE, doc, errA)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		apply(true, Document{ID: i, Text: fmt.Sprintf("line %d of the book", i)})
+		if i%5 == 0 {
+			apply(true, Document{ID: i / 2, Text: "a duplicate"})
+		}
+		if i%7 == 0 {
+			// deletes documents in memory and in flushed segments
+			apply(false, Document{ID: i / 3})
+			apply(true, Document{ID: i / 3, Text: fmt.Sprintf("rewritten line %d", i/3)})
+		}
+		if i%11 == 0 {
+			apply(false, Document{ID: i / 2})
+		}
+	}
+	if err := l.Delete(1000); err == nil {
+		t.Error("Should have returned an error deleting a missing document")
+	}
+
+	queries := []string{"line", "rewritten", "book 42", "duplicate", "missing"}
+	compareLSM(t, expected, l, queries)
+	settle(t, l)
+	compareLSM(t, expected, l, queries)
+
+	if n := l.Segments(); n > 2*mergeFactor {
+		t.Errorf("Expected merged segments, but got %d", n)
+	}
+
+	// everything survives a restart
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to close, %v", err)
+	}
+	l, err = OpenSegmentedDB(dir, 10)
+	if err != nil {
+		t.Fatalf("Failed to reopen, %v", err)
+	}
+	defer l.Close()
+	compareLSM(t, expected, l, queries)
+
+	apply(false, Document{ID: 3})
+	apply(true, Document{ID: 3, Text: "after the restart"})
+	compareLSM(t, expected, l, []string{"restart", "line"})
+}
+
+func TestSegmentedDBConcurrent(t *testing.T) {
+	l, err := OpenSegmentedDB(t.TempDir(), 8)
+	if err != nil {
+		t.Fatalf("Failed to open, %v", err)
+	}
+	defer l.Close()
+
+	numWriters, numDocs := 4, 100
+	var wg sync.WaitGroup
+	wg.Add(numWriters)
+	for w := 0; w < numWriters; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < numDocs; i++ {
+				l.Index(Document{ID: w*numDocs + i, Text: "common"})
+			}
+		}(w)
+	}
+
+	// readers keep querying while segments are flushed and merged in the background
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		prev := 0
+		for i := 0; i < 100; i++ {
+			res, err := l.Query("common")
+			if err != nil || len(res) < prev {
+				t.Errorf("Expected at least %d documents, but got %d, %v", prev, len(res), err)
+			}
+			prev = len(res)
+		}
+	}()
+	wg.Wait()
+	<-done
+
+	settle(t, l)
+	if res, _ := l.Query("common"); len(res) != numWriters*numDocs {
+		t.Errorf("Expected %d documents, but got %d", numWriters*numDocs, len(res))
+	}
+}
+
+func TestSegmentedDBLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(minGen, maxGen uint64, docs ...Document) {
+		db := NewDB()
+		for _, doc := range docs {
+			db.Index(doc)
+		}
+		if err := db.WriteSegment(filepath.Join(dir, segmentName(minGen, maxGen))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a merge of generations 1 and 2 that crashed before removing its inputs
+	write(1, 1, Document{ID: 1, Text: "old"})
+	write(2, 2, Document{ID: 2, Text: "old"})
+	write(1, 2, Document{ID: 1, Text: "merged"}, Document{ID: 2, Text: "merged"})
+	write(3, 3, Document{ID: 3, Text: "newest"})
+	if err := writeTombstones(tombstonePath(filepath.Join(dir, segmentName(3, 3))), map[int]struct{}{1: {}}); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, segmentName(4, 4)+".tmp123"), []byte("partial"), 0o644)
+
+	l, err := OpenSegmentedDB(dir, 10)
+	if err != nil {
+		t.Fatalf("Failed to open, %v", err)
+	}
+	defer l.Close()
+
+	if n := l.Segments(); n != 2 {
+		t.Errorf("Expected 2 segments, but got %d", n)
+	}
+	if res, _ := l.Query("merged old newest"); len(res) != 2 || res[0].ID != 2 || res[0].Text != "merged" || res[1].ID != 3 {
+		t.Errorf("Expected doc ID 2 from the merged segment and doc ID 3, but got %v", res)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 3 {
+		t.Errorf("Expected the leftovers to be removed, but got %v", files)
+	}
+	if err := l.Index(Document{ID: 1, Text: "indexed again"}); err != nil {
+		t.Errorf("Should have accepted a deleted doc ID, %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.del")
+	os.WriteFile(bad, []byte(tombstoneMagic+"garbage!"), 0o644)
+	if _, err := readTombstones(bad); err == nil {
+		t.Error("Should have returned an error for a corrupt tombstone file")
+	}
+}
+
+// crash stops the segmented db without flushing its memtable, as if the process died
+func crash(l *SegmentedDB) {
+	close(l.done)
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closeSegments()
+	if l.mem.wal != nil {
+		l.mem.wal.Close()
+	}
+}
+
+func TestSegmentedDBRecover(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenSegmentedDB(dir, 100)
+	if err != nil {
+		t.Fatalf("Failed to open, %v", err)
+	}
+	l.Index(Document{ID: 1, Text: "flushed"})
+	l.Index(Document{ID: 2, Text: "flushed"})
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Failed to flush, %v", err)
+	}
+	l.Index(Document{ID: 3, Text: "logged"})
+	l.Delete(1)
+	// a write rejected by the schema carried over from the flushed memtable is not logged
+	if err := l.Index(Document{ID: 4, Source: `{"year": 1865}`}); err != nil {
+		t.Fatalf("Failed to index json, %v", err)
+	}
+	l.mu.Lock()
+	l.freeze()
+	l.mu.Unlock()
+	if err := l.Index(Document{ID: 5, Source: `{"year": "unknown"}`}); err == nil {
+		t.Error("Should have returned an error for a string in a number field")
+	}
+	wal := filepath.Join(dir, walName(l.mem.minGen))
+	crash(l)
+
+	// a write torn by the crash is dropped
+	f, _ := os.OpenFile(wal, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write([]byte{200, 0, 0, 0, 1, 2, 3})
+	f.Close()
+
+	l, err = OpenSegmentedDB(dir, 100)
+	if err != nil {
+		t.Fatalf("Failed to reopen, %v", err)
+	}
+	if res, _ := l.Query("flushed logged"); len(res) != 2 || res[0].ID != 2 || res[1].ID != 3 {
+		t.Errorf("Expected doc IDs 2 and 3, but got %v", res)
+	}
+	if _, err := os.Stat(wal); !os.IsNotExist(err) {
+		t.Errorf("Expected the replayed log to be removed, but got %v", err)
+	}
+	if _, err := l.Get(4); err != nil {
+		t.Errorf("Expected the json document to be recovered, %v", err)
+	}
+	if doc, err := l.Get(5); err == nil {
+		t.Errorf("Expected the rejected write to stay rejected, but got %v", doc)
+	}
+
+	// a failed background flush stops further writes
+	l.mu.Lock()
+	l.err = fmt.Errorf("disk full")
+	l.mu.Unlock()
+	if err := l.Index(Document{ID: 6, Text: "after the failure"}); err == nil || err.Error() != "disk full" {
+		t.Errorf("Expected the flush error, but got %v", err)
+	}
+	if err := l.Close(); err == nil {
+		t.Error("Expected close to report the flush error")
+	}
+	l.mu.Lock()
+	l.err = nil
+	l.mu.Unlock()
+
+	if err := l.Close(); err == nil {
+		t.Error("Expected a second close to return the result of the first")
+	}
+	if err := l.Index(Document{ID: 6, Text: "after close"}); err == nil {
+		t.Error("Should have returned an error indexing into a closed db")
+	}
+	if err := l.Delete(2); err == nil {
+		t.Error("Should have returned an error deleting from a closed db")
+	}
+}
+
+func TestSegmentedDBKeys(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenSegmentedDB(dir, 100)
+	if err != nil {
+		t.Fatalf("Failed to open, %v", err)
+	}
+	l.Index(Document{ID: 1, Key: "a", Text: "first"})
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Failed to flush, %v", err)
+	}
+
+	if err := l.Index(Document{ID: 2, Key: "a", Text: "second"}); err == nil {
+		t.Error("Should have returned an error for a key held by a flushed document")
+	}
+	if err := l.Index(Document{ID: 2, Key: "b", Text: "second"}); err != nil {
+		t.Errorf("Failed to index a new key, %v", err)
+	}
+	if err := l.Index(Document{ID: 3, Key: "b", Text: "third"}); err == nil {
+		t.Error("Should have returned an error for a key held by the memtable")
+	}
+	l.Delete(1)
+	if err := l.Index(Document{ID: 3, Key: "a", Text: "third"}); err != nil {
+		t.Errorf("Should have accepted the key of a deleted document, %v", err)
+	}
+
+	// the keys are rebuilt from the segments after a restart
+	if err := l.Close(); err != nil {
+		t.Fatalf("Failed to close, %v", err)
+	}
+	l, err = OpenSegmentedDB(dir, 100)
+	if err != nil {
+		t.Fatalf("Failed to reopen, %v", err)
+	}
+	defer l.Close()
+	for _, key := range []string{"a", "b"} {
+		if err := l.Index(Document{ID: 4, Key: key, Text: "fourth"}); err == nil {
+			t.Errorf("Should have returned an error for key %q after a restart", key)
+		}
+	}
+}
@@ -2,103 +2,306 @@ package main
 
 import (
 	"bufio"
+	"container/heap"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"sort"
-	"strings"
 	"sync"
+	"time"
 )
 
+// Document is a unit of indexed text. Key is an optional external key that is unique among the live documents. Source holds the original JSON of documents indexed with IndexJSON, Text is then derived from its string values. Expires is an optional expiry time, from then on reads no longer see the document and Expire removes it.
 type Document struct {
-	ID   int
-	Text string
+	ID      int
+	Key     string
+	Text    string
+	Source  string
+	Expires time.Time
 }
 
+// DB is an inverted index over Documents. Every write commits a new version and readers work against a Snapshot of a single version, so a query never observes a half-applied write. Old document versions are kept until no snapshot can see them anymore.
 type DB struct {
-	sync.Mutex
-	index map[string][]int
-	data  map[int]Document
+	mu       sync.RWMutex
+	version  uint64
+	index    map[string][]posting
+	data     map[int][]docVersion
+	analyzer Analyzer
+	grams    map[string][]int
+	gramSize int
+
+	synonyms    *SynonymMap
+	synonymMode SynonymMode
+
+	completions *completionTrie
+
+	vectors *vectorIndex
+
+	// schema holds the inferred type of every JSON field path
+	schema map[string]FieldType
+
+	// keys lists the document versions per external key and nextID is the next ID assigned by Add
+	keys   map[string][]posting
+	nextID int
+
+	// expiries queues the versions of expiring documents by expiry time and now is the clock they expire by
+	expiries expiryQueue
+	now      func() time.Time
+
+	// snapshots counts the open snapshots per version and garbage lists deleted document versions waiting to be reclaimed
+	snapshots  map[uint64]int
+	garbage    []posting
+	nextVacuum int
+
+	log *writeLog
+
+	percolator *percolator
+}
+
+// Option configures optional behaviour of a DB created with NewDB
+type Option func(*DB)
+
+// WithAnalyzer sets the analyzer used to tokenize both indexed documents and query strings. The default is a Unicode analyzer without accent folding.
+func WithAnalyzer(a Analyzer) Option {
+	return func(d *DB) {
+		d.analyzer = a
+	}
 }
 
 // NewDB creates a DB struct and initializes the map in the index and data field
-func NewDB() DB {
-	return DB{index: make(map[string][]int), data: make(map[int]Document)}
+func NewDB(opts ...Option) *DB {
+	d := &DB{
+		index:     make(map[string][]posting),
+		data:      make(map[int][]docVersion),
+		analyzer:  NewUnicodeAnalyzer(false),
+		snapshots: make(map[uint64]int),
+		schema:    make(map[string]FieldType),
+		keys:      make(map[string][]posting),
+		now:       time.Now,
+
+		percolator: newPercolator(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// OpType is the kind of a write operation
+type OpType int
+
+const (
+	// OpIndex adds Op.Doc to the db
+	OpIndex OpType = iota + 1
+	// OpDelete removes the document with ID Op.ID
+	OpDelete
+)
+
+// Op is a single write operation. All operations applied by one commit become visible together at the same version.
+type Op struct {
+	Type OpType
+	Doc  Document
+	ID   int
+
+	// assign requests a new doc ID for Doc, it is cleared once the ID is committed
+	assign bool
 }
 
-// Index takes a Document and will index it into the index map and data map. The document will first be tokenized through the analyze function. For each resulting token, the doc ID will be appended to the list in the index field of the db. the key for the index field is the token string. the doc ID will be used as the key in the data field.
+// Index takes a Document and will index it into the index map and data map. A document with a JSON Source and no Text gets the text ParseJSON builds from the source. The document will first be tokenized through the analyzer of the db. For each resulting token, a posting for the doc ID will be appended to the list in the index field of the db. the key for the index field is the token string. the doc ID will be used as the key in the data field. An error is returned if a document with the same ID is already present.
 func (d *DB) Index(v Document) error {
-	tokens := analyze(v.Text)
-	for _, t := range tokens {
-		d.index[t] = append(d.index[t], v.ID)
+	return d.commit([]Op{{Type: OpIndex, Doc: v}})
+}
+
+// Delete removes the document with the specified doc ID. Snapshots taken before the delete still see the document until they are released. An error is returned if the document is not present.
+func (d *DB) Delete(id int) error {
+	return d.commit([]Op{{Type: OpDelete, ID: id}})
+}
+
+// commit validates the operations against the current state and applies all of them under a single new version. Nothing is applied if any operation is invalid.
+func (d *DB) commit(ops []Op) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.commitLocked(ops)
+}
+
+// commitLocked is commit for callers that hold the write lock
+func (d *DB) commitLocked(ops []Op) error {
+	d.assignIDs(ops)
+	deriveTexts(ops)
+	if err := d.validate(ops); err != nil {
+		return err
 	}
-	if _, exists := d.data[v.ID]; exists {
-		return fmt.Errorf("Document id %d already present in db", v.ID)
+
+	d.version++
+	for _, op := range ops {
+		switch op.Type {
+		case OpIndex:
+			d.insert(op.Doc, d.version)
+			if !op.Doc.expiredAt(d.now()) {
+				versions := d.data[op.Doc.ID]
+				d.percolate(versions[len(versions)-1])
+			}
+		case OpDelete:
+			d.remove(op.ID, d.version)
+		}
+	}
+	for i := range ops {
+		ops[i].assign = false
 	}
-	d.data[v.ID] = v
+	if d.log != nil {
+		d.log.append(LogEntry{Version: d.version, Ops: ops})
+	}
+	d.maybeVacuum()
 	return nil
 }
 
-// Query will take a query string term and run it through the same analyzer as the Index function does. It will then build out a slice of documents that pertain to this particular query string. e.g. a query of "Alice Wonderland" will fetch all unique documents that contain both "alice" and "wonderland"
-func (d DB) Query(term string) ([]Document, error) {
-	var v Document
-	var err error
-	var vals []Document
-	uniqDocIds := make(map[int]struct{})
+// validate checks that every operation applies cleanly on top of the current state and the operations before it. The error of the first invalid operation is returned as an *opError. Callers must hold the lock.
+func (d *DB) validate(ops []Op) error {
+	pending := make(map[int]bool)
+	live := func(id int) bool {
+		if l, exists := pending[id]; exists {
+			return l
+		}
+		_, exists := d.latest(id)
+		return exists
+	}
+	// pendingKeys tracks keys taken or freed by earlier operations and pendingDocKeys the keys of documents indexed by them
+	pendingKeys := make(map[string]bool)
+	pendingDocKeys := make(map[int]string)
+	keyTaken := func(key string) bool {
+		if l, exists := pendingKeys[key]; exists {
+			return l
+		}
+		_, exists := d.keyOwner(key)
+		return exists
+	}
 
-	tokens := analyze(term)
-	for _, t := range tokens {
-		if ids, exists := d.index[t]; exists {
-			for _, id := range ids {
-				if _, idExists := uniqDocIds[id]; idExists {
-					continue
+	var schema map[string]FieldType
+	check := func(op Op) error {
+		switch op.Type {
+		case OpIndex:
+			if live(op.Doc.ID) {
+				return fmt.Errorf("Document id %d already present in db", op.Doc.ID)
+			}
+			if op.Doc.Key != "" && keyTaken(op.Doc.Key) {
+				return fmt.Errorf("Document key %q already present in db", op.Doc.Key)
+			}
+			fields, err := documentFields(op.Doc)
+			if err != nil {
+				return fmt.Errorf("Document id %d: %v", op.Doc.ID, err)
+			}
+			if fields != nil {
+				if schema == nil {
+					schema = make(map[string]FieldType, len(d.schema))
+					for p, t := range d.schema {
+						schema[p] = t
+					}
 				}
-				v, err = d.Get(id)
-				if err != nil {
-					return []Document{}, fmt.Errorf("query: failed to fetch all ids, %v", err)
+				if err := checkSchema(schema, op.Doc.ID, fields); err != nil {
+					return err
 				}
-				uniqDocIds[id] = struct{}{}
-				vals = append(vals, v)
+				updateSchema(schema, fields)
+			}
+			pending[op.Doc.ID] = true
+			pendingDocKeys[op.Doc.ID] = op.Doc.Key
+			if op.Doc.Key != "" {
+				pendingKeys[op.Doc.Key] = true
+			}
+		case OpDelete:
+			if !live(op.ID) {
+				return fmt.Errorf("delete: id %d not present", op.ID)
+			}
+			key, exists := pendingDocKeys[op.ID]
+			if !exists {
+				v, _ := d.latest(op.ID)
+				key = v.doc.Key
 			}
+			if key != "" {
+				pendingKeys[key] = false
+			}
+			pending[op.ID] = false
+		default:
+			return fmt.Errorf("commit: unknown operation type %d", op.Type)
 		}
+		return nil
 	}
-	return vals, nil
-}
 
-// Get will retrieve the document with the specified doc ID. An error is returned if the document is not present
-func (d DB) Get(id int) (Document, error) {
-	if _, exists := d.data[id]; exists {
-		return d.data[id], nil
+	for i, op := range ops {
+		if err := check(op); err != nil {
+			return &opError{index: i, err: err}
+		}
 	}
-	return Document{}, fmt.Errorf("get: id %d not present", id)
+	return nil
 }
 
-// analyze will tokenize the text string and lowercase all tokens
-// delimiter is a whiespace and returned list should be in sorted lexical order
-// with unique words only.
-func analyze(text string) []string {
-	var tokens []string
-	tokenMap := make(map[string]struct{})
-
-	// tokenize by white space
-	for _, s := range strings.Fields(text) {
+// insert writes a new version of the document created at the given version. Callers must hold the write lock.
+func (d *DB) insert(v Document, version uint64) {
+	// the source was validated before the commit
+	fields, _ := documentFields(v)
+	if fields != nil {
+		updateSchema(d.schema, fields)
+	}
+	for _, t := range d.documentTokens(v, fields) {
+		d.index[t] = append(d.index[t], posting{id: v.ID, created: version})
+	}
+	var vec *docVector
+	if d.vectors != nil {
+		vec = d.vectors.add(d.docTokens(v.Text), posting{id: v.ID, created: version})
+	}
+	d.data[v.ID] = append(d.data[v.ID], docVersion{doc: v, fields: fields, vec: vec, created: version})
+	if v.Key != "" {
+		d.keys[v.Key] = append(d.keys[v.Key], posting{id: v.ID, created: version})
+	}
+	d.nextID = max(d.nextID, v.ID+1)
+	if !v.Expires.IsZero() {
+		heap.Push(&d.expiries, expiry{at: v.Expires, id: v.ID, created: version})
+	}
+	if d.grams != nil {
+		d.indexGrams(v)
+	}
+	if d.completions != nil {
+		d.completions.addDocument(d.analyzer.Tokens(v.Text))
+	}
+}
 
-		// filter to transform text
-		s = strings.ToLower(s)
+// remove marks the live version of the document as deleted at the given version. Callers must hold the write lock.
+func (d *DB) remove(id int, version uint64) {
+	versions := d.data[id]
+	i := len(versions) - 1
+	versions[i].deleted = version
+	d.garbage = append(d.garbage, posting{id: id, created: versions[i].created})
+	if d.completions != nil {
+		d.completions.removeDocument(d.analyzer.Tokens(versions[i].doc.Text))
+	}
+	if d.vectors != nil {
+		d.vectors.deleted(versions[i].vec)
+	}
+}
 
-		// adding to list of unique tokens
-		if _, exists := tokenMap[s]; !exists {
-			tokenMap[s] = struct{}{}
-			tokens = append(tokens, s)
-		}
+// latest returns the live version of the document. Callers must hold the lock.
+func (d *DB) latest(id int) (docVersion, bool) {
+	versions := d.data[id]
+	if len(versions) == 0 || versions[len(versions)-1].deleted != 0 {
+		return docVersion{}, false
 	}
+	return versions[len(versions)-1], true
+}
+
+// Query will take a query string term and run it through the same analyzer as the Index function does. It will then build out a slice of documents that pertain to this particular query string. e.g. a query of "Alice Wonderland" will fetch all unique documents that contain either "alice" or "wonderland". The query runs against a snapshot of the latest version.
+func (d *DB) Query(term string) ([]Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Query(term)
+}
 
-	sort.Strings(tokens)
-	return tokens
+// Get will retrieve the document with the specified doc ID. An error is returned if the document is not present
+func (d *DB) Get(id int) (Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Get(id)
 }
 
-// splitTextFile reads in a text file and splits it into a slice of Document slices based on the number of shards specified in the arguments. Each line in the text file will be treated as a document.
+// splitTextFile reads in a text file and splits it into a slice of Document slices based on the number of shards specified in the arguments. Each line in the text file will be treated as a document keyed by the file name and line number, e.g. "alice-in-wonderland.txt:42". The documents have no ID yet, they are assigned one by DB.Add.
 func splitTextFile(filename string, numShards int) ([][]Document, error) {
 	f, err := os.Open(filename)
 	defer f.Close()
@@ -127,7 +330,8 @@ func splitTextFile(filename string, numShards int) ([][]Document, error) {
 	for i = 0; i < numShards; i++ {
 		splitLines[i] = make([]Document, linesPerShard)
 		for j = 0; j < linesPerShard; j++ {
-			splitLines[i][j] = Document{ID: i*linesPerShard + j, Text: lines[i*linesPerShard+j]}
+			n := i*linesPerShard + j
+			splitLines[i][j] = Document{Key: fmt.Sprintf("%s:%d", filename, n+1), Text: lines[n]}
 		}
 	}
 
@@ -135,24 +339,33 @@ func splitTextFile(filename string, numShards int) ([][]Document, error) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := run(os.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	numShards := 4
 	lines, err := splitTextFile("alice-in-wonderland.txt", numShards)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Create a go routine to index each slice of Documents after being split from above
+	// Create a go routine to index each slice of Documents after being split from above. Every shard is committed as a single batch, so a bad document leaves the whole shard out instead of half of it.
 	db := NewDB()
 	var wg sync.WaitGroup
 	wg.Add(numShards)
 	for i := 0; i < numShards; i++ {
 		go func(data []Document) {
+			defer wg.Done()
+			b := db.NewBatch()
 			for _, d := range data {
-				db.Lock()
-				db.Index(d)
-				db.Unlock()
+				b.Add(d)
+			}
+			if err := b.Commit(); err != nil {
+				log.Printf("Failed to index shard, %v", err)
 			}
-			wg.Done()
 		}(lines[i])
 	}
 	wg.Wait()
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Hit is a document matched by a ranked query together with its relevance score
+type Hit struct {
+	Document
+	Score float64
+}
+
+// MoreLikeThisOptions tunes how significant terms are picked from the source document
+type MoreLikeThisOptions struct {
+	// MaxTerms is the number of highest weighted terms used in the query
+	MaxTerms int
+	// MinDocFreq ignores terms found in fewer documents, including the source
+	MinDocFreq int
+	// MaxDocFreqRatio ignores terms found in more than this fraction of all documents
+	MaxDocFreqRatio float64
+}
+
+// DefaultMoreLikeThisOptions are the options used by MoreLikeThis
+var DefaultMoreLikeThisOptions = MoreLikeThisOptions{
+	MaxTerms:        25,
+	MinDocFreq:      2,
+	MaxDocFreqRatio: 0.5,
+}
+
+// termWeight is a term of the source document and its tf-idf weight
+type termWeight struct {
+	term   string
+	weight float64
+}
+
+// MoreLikeThis returns up to n documents that are about the same thing as the document with the given ID, ranked by score. See MoreLikeThisWith.
+func (d *DB) MoreLikeThis(id int, n int) ([]Hit, error) {
+	return d.MoreLikeThisWith(id, n, DefaultMoreLikeThisOptions)
+}
+
+// MoreLikeThis runs MoreLikeThis against the snapshot
+func (s *Snapshot) MoreLikeThis(id int, n int) ([]Hit, error) {
+	return s.MoreLikeThisWith(id, n, DefaultMoreLikeThisOptions)
+}
+
+// MoreLikeThisWith picks the most significant terms of the document with the given ID by tf-idf, skipping terms that are too rare or too common to tell documents apart, and runs them as a weighted query. Every other document scores the summed weight of the selected terms it contains. The source document is never returned.
+func (d *DB) MoreLikeThisWith(id int, n int, opts MoreLikeThisOptions) ([]Hit, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.MoreLikeThisWith(id, n, opts)
+}
+
+// MoreLikeThisWith runs MoreLikeThisWith against the snapshot
+func (s *Snapshot) MoreLikeThisWith(id int, n int, opts MoreLikeThisOptions) ([]Hit, error) {
+	src, err := s.Get(id)
+	if err != nil {
+		return []Hit{}, fmt.Errorf("more like this: %v", err)
+	}
+
+	terms := s.significantTerms(src.Text, opts)
+
+	scores := make(map[int]float64)
+	for _, t := range terms {
+		for _, docID := range s.postings(t.term) {
+			if docID != id {
+				scores[docID] += t.weight
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for docID, score := range scores {
+		doc, err := s.Get(docID)
+		if err != nil {
+			return []Hit{}, fmt.Errorf("more like this: failed to fetch all ids, %v", err)
+		}
+		hits = append(hits, Hit{Document: doc, Score: score})
+	}
+	sortHits(hits)
+
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	return hits, nil
+}
+
+// significantTerms weighs every term of the text by tf-idf and returns the highest weighted terms allowed by opts
+func (s *Snapshot) significantTerms(text string, opts MoreLikeThisOptions) []termWeight {
+	numDocs := s.numDocs()
+	maxDocFreq := int(opts.MaxDocFreqRatio * float64(numDocs))
+
+	var terms []termWeight
+	for t, tf := range s.db.termFreqs(text) {
+		df := s.docFreq(t)
+		if df < opts.MinDocFreq || (opts.MaxDocFreqRatio > 0 && df > maxDocFreq) {
+			continue
+		}
+		terms = append(terms, termWeight{term: t, weight: float64(tf) * idf(df, numDocs)})
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight != terms[j].weight {
+			return terms[i].weight > terms[j].weight
+		}
+		return terms[i].term < terms[j].term
+	})
+	if opts.MaxTerms > 0 && len(terms) > opts.MaxTerms {
+		terms = terms[:opts.MaxTerms]
+	}
+	return terms
+}
+
+// termFreqs counts how often each index token occurs in the text
+func (d *DB) termFreqs(text string) map[string]int {
+	freqs := make(map[string]int)
+	for _, t := range d.docTokens(text) {
+		freqs[t]++
+	}
+	return freqs
+}
+
+// idf is the inverse document frequency of a term found in df out of numDocs documents
+func idf(df, numDocs int) float64 {
+	return 1 + math.Log(float64(numDocs)/float64(df+1))
+}
+
+// sortHits orders hits by descending score, breaking ties by doc ID
+func sortHits(hits []Hit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMoreLikeThis(t *testing.T) {
+	db := NewDB()
+	docs := []Document{
+		{ID: 0, Text: "the queen shouted off with her head"},
+		{ID: 1, Text: "off with his head the queen said"},
+		{ID: 2, Text: "the rabbit took a watch from its waistcoat pocket"},
+		{ID: 3, Text: "the rabbit looked at the watch"},
+		{ID: 4, Text: "the queen of hearts made some tarts"},
+		{ID: 5, Text: "the cat only grinned"},
+		{ID: 6, Text: "the dormouse fell asleep"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	testData := []struct {
+		id       int
+		expected []int
+	}{
+		{0, []int{1, 4}},
+		{2, []int{3}},
+		{5, []int{}},
+	}
+
+	for _, d := range testData {
+		res, err := db.MoreLikeThis(d.id, 10)
+		if err != nil {
+			t.Errorf("Got an error for doc ID %d, %v", d.id, err)
+			continue
+		}
+		if len(res) != len(d.expected) {
+			t.Errorf("Expected %d similar documents for doc ID %d, but got %d, res: %v", len(d.expected), d.id, len(res), res)
+			continue
+		}
+		for i, h := range res {
+			if h.ID != d.expected[i] {
+				t.Errorf("Expected doc ID %d at rank %d for doc ID %d, but got %d", d.expected[i], i, d.id, h.ID)
+			}
+			if h.ID == d.id {
+				t.Errorf("Source doc ID %d should not be returned", d.id)
+			}
+		}
+	}
+
+	if res, err := db.MoreLikeThis(0, 1); err != nil || len(res) != 1 {
+		t.Errorf("Expected a single result, but got %v, %v", res, err)
+	}
+
+	if _, err := db.MoreLikeThis(42, 10); err == nil {
+		t.Error("Should have returned an error for a missing document")
+	}
+}
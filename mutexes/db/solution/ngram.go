@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// WithNGrams enables a character n-gram index of size n next to the token index so that arbitrary substrings of a document can be searched with QuerySubstring and QueryRegexp. Trigrams (n = 3) are a good default.
+func WithNGrams(n int) Option {
+	return func(d *DB) {
+		if n > 0 {
+			d.gramSize = n
+			d.grams = make(map[string][]int)
+		}
+	}
+}
+
+// ngrams returns the unique character n-grams of the case folded text
+func ngrams(text string, n int) []string {
+	runes := []rune(FoldCase(text))
+	var grams []string
+	seen := make(map[string]struct{})
+	for i := 0; i+n <= len(runes); i++ {
+		g := string(runes[i : i+n])
+		if _, exists := seen[g]; !exists {
+			seen[g] = struct{}{}
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+// indexGrams appends the doc ID to the posting list of every n-gram in the document text
+func (d *DB) indexGrams(v Document) {
+	for _, g := range ngrams(v.Text, d.gramSize) {
+		d.grams[g] = append(d.grams[g], v.ID)
+	}
+}
+
+// removeGrams removes the doc ID from the posting list of every n-gram in the text of a reclaimed document version, except for n-grams still used by the versions that remain
+func (d *DB) removeGrams(v Document, remaining []docVersion) {
+	keep := make(map[string]struct{})
+	for _, r := range remaining {
+		for _, g := range ngrams(r.doc.Text, d.gramSize) {
+			keep[g] = struct{}{}
+		}
+	}
+
+	for _, g := range ngrams(v.Text, d.gramSize) {
+		if _, exists := keep[g]; exists {
+			continue
+		}
+		ids := d.grams[g][:0]
+		for _, id := range d.grams[g] {
+			if id != v.ID {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			delete(d.grams, g)
+		} else {
+			d.grams[g] = ids
+		}
+	}
+}
+
+// QuerySubstring returns every document whose text contains the substring sub, ignoring case. e.g. "ootle" finds documents containing "tootle". The n-gram index is used to narrow down the candidate documents which are then verified against their text. Results are sorted by doc ID.
+func (d *DB) QuerySubstring(sub string) ([]Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.QuerySubstring(sub)
+}
+
+// QuerySubstring runs a substring search against the snapshot, see DB.QuerySubstring
+func (s *Snapshot) QuerySubstring(sub string) ([]Document, error) {
+	if s.db.grams == nil {
+		return []Document{}, fmt.Errorf("query substring: n-gram index is not enabled")
+	}
+	if sub == "" {
+		return []Document{}, fmt.Errorf("query substring: empty substring")
+	}
+
+	needle := FoldCase(sub)
+	return s.verifyCandidates(s.gramCandidates([]string{needle}), func(doc Document) bool {
+		return strings.Contains(FoldCase(doc.Text), needle)
+	})
+}
+
+// QueryRegexp returns every document whose text matches the regular expression expr. Literal strings that any match must contain are extracted from the expression and looked up in the n-gram index to prune candidates before the expression is run against the text. Results are sorted by doc ID.
+func (d *DB) QueryRegexp(expr string) ([]Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.QueryRegexp(expr)
+}
+
+// QueryRegexp runs a regular expression search against the snapshot, see DB.QueryRegexp
+func (s *Snapshot) QueryRegexp(expr string) ([]Document, error) {
+	if s.db.grams == nil {
+		return []Document{}, fmt.Errorf("query regexp: n-gram index is not enabled")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return []Document{}, fmt.Errorf("query regexp: %v", err)
+	}
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return []Document{}, fmt.Errorf("query regexp: %v", err)
+	}
+
+	var literals []string
+	for _, l := range requiredLiterals(parsed.Simplify()) {
+		literals = append(literals, FoldCase(l))
+	}
+	return s.verifyCandidates(s.gramCandidates(literals), func(doc Document) bool {
+		return re.MatchString(doc.Text)
+	})
+}
+
+// gramCandidates intersects the posting lists of the n-grams of every literal. A nil map is returned when none of the literals is long enough to use the index, meaning every document is a candidate.
+func (s *Snapshot) gramCandidates(literals []string) map[int]struct{} {
+	var grams []string
+	for _, l := range literals {
+		if len([]rune(l)) >= s.db.gramSize {
+			grams = append(grams, ngrams(l, s.db.gramSize)...)
+		}
+	}
+	if len(grams) == 0 {
+		return nil
+	}
+
+	s.db.mu.RLock()
+	lists := make([][]int, len(grams))
+	for i, g := range grams {
+		lists[i] = append([]int(nil), s.db.grams[g]...)
+	}
+	s.db.mu.RUnlock()
+
+	// start from the shortest list to keep the candidate set small
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	candidates := make(map[int]struct{}, len(lists[0]))
+	for _, id := range lists[0] {
+		candidates[id] = struct{}{}
+	}
+	for _, list := range lists[1:] {
+		next := make(map[int]struct{}, len(candidates))
+		for _, id := range list {
+			if _, exists := candidates[id]; exists {
+				next[id] = struct{}{}
+			}
+		}
+		candidates = next
+		if len(candidates) == 0 {
+			break
+		}
+	}
+	return candidates
+}
+
+// verifyCandidates fetches the candidate documents, or every document when candidates is nil, and keeps those accepted by match. Candidates that are not part of the snapshot are skipped.
+func (s *Snapshot) verifyCandidates(candidates map[int]struct{}, match func(Document) bool) ([]Document, error) {
+	var ids []int
+	if candidates == nil {
+		ids = s.ids()
+	} else {
+		for id := range candidates {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+	}
+
+	res := []Document{}
+	for _, id := range ids {
+		doc, err := s.Get(id)
+		if err != nil {
+			continue
+		}
+		if match(doc) {
+			res = append(res, doc)
+		}
+	}
+	return res, nil
+}
+
+// requiredLiterals returns literal strings that must appear in any text matched by re. Only concatenations and capture groups are followed, alternations and repetitions contribute nothing, so the result may be empty but never requires more than the expression does.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCapture:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpPlus:
+		// x+ contains at least one x
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpConcat:
+		var res []string
+		var run strings.Builder
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				run.WriteString(string(sub.Rune))
+				continue
+			}
+			if run.Len() > 0 {
+				res = append(res, run.String())
+				run.Reset()
+			}
+			res = append(res, requiredLiterals(sub)...)
+		}
+		if run.Len() > 0 {
+			res = append(res, run.String())
+		}
+		return res
+	}
+	return nil
+}
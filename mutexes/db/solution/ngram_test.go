@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNGrams(t *testing.T) {
+	testData := []struct {
+		text     string
+		n        int
+		expected []string
+	}{
+		{"tootle", 3, []string{"too", "oot", "otl", "tle"}},
+		{"Abab", 2, []string{"ab", "ba"}},
+		{"hi", 3, nil},
+	}
+
+	for _, d := range testData {
+		res := ngrams(d.text, d.n)
+		if len(res) != len(d.expected) {
+			t.Errorf("Expected %d grams, but got %d, res: %v", len(d.expected), len(res), res)
+			continue
+		}
+		for i, g := range res {
+			if g != d.expected[i] {
+				t.Errorf("Expected %s, but got %s", d.expected[i], g)
+			}
+		}
+	}
+}
+
+func TestQuerySubstring(t *testing.T) {
+	db := NewDB(WithNGrams(3))
+	docs := []Document{
+		{ID: 0, Text: "the Dormouse went tootle tootle"},
+		{ID: 1, Text: "Twinkle, twinkle, little bat"},
+		{ID: 2, Text: "How doth the little crocodile"},
+		{ID: 3, Text: "a bottle marked DRINK ME"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	testData := []struct {
+		query    string
+		expected []int
+	}{
+		{"ootle", []int{0}},
+		{"ttle", []int{1, 2, 3}},
+		{"LITTLE", []int{1, 2}},
+		{"e, l", []int{1}},
+		{"tt", []int{1, 2, 3}},
+		{"rink m", []int{3}},
+		{"crocodiles", []int{}},
+	}
+
+	for _, d := range testData {
+		res, err := db.QuerySubstring(d.query)
+		if err != nil {
+			t.Errorf("Got an error while querying %s, %v", d.query, err)
+			continue
+		}
+		if len(res) != len(d.expected) {
+			t.Errorf("Expected %d results for %s, but got %d, res: %v", len(d.expected), d.query, len(res), res)
+			continue
+		}
+		for i, doc := range res {
+			if doc.ID != d.expected[i] {
+				t.Errorf("Expected doc ID %d for %s, but got %d", d.expected[i], d.query, doc.ID)
+			}
+		}
+	}
+
+	if _, err := NewDB().QuerySubstring("ootle"); err == nil {
+		t.Error("Should have returned an error when the n-gram index is not enabled")
+	}
+}
+
+func TestQueryRegexp(t *testing.T) {
+	db := NewDB(WithNGrams(3))
+	docs := []Document{
+		{ID: 0, Text: "the Dormouse went tootle tootle"},
+		{ID: 1, Text: "Twinkle, twinkle, little bat"},
+		{ID: 2, Text: "How doth the little crocodile"},
+		{ID: 3, Text: "a bottle marked DRINK ME"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	testData := []struct {
+		expr     string
+		expected []int
+	}{
+		{"t[o]+tle", []int{0}},
+		{"(?i)drink me", []int{3}},
+		{"drink me", []int{}},
+		{"[Tt]winkle, (twinkle|star)", []int{1}},
+		{"^How", []int{2}},
+		{"b[aeiu]t", []int{1}},
+	}
+
+	for _, d := range testData {
+		res, err := db.QueryRegexp(d.expr)
+		if err != nil {
+			t.Errorf("Got an error while querying %s, %v", d.expr, err)
+			continue
+		}
+		if len(res) != len(d.expected) {
+			t.Errorf("Expected %d results for %s, but got %d, res: %v", len(d.expected), d.expr, len(res), res)
+			continue
+		}
+		for i, doc := range res {
+			if doc.ID != d.expected[i] {
+				t.Errorf("Expected doc ID %d for %s, but got %d", d.expected[i], d.expr, doc.ID)
+			}
+		}
+	}
+
+	if _, err := db.QueryRegexp("a(b"); err == nil {
+		t.Error("Should have returned an error for an invalid expression")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// compositions lists, for each combining mark, pairs of a base rune followed by the precomposed rune it forms with that mark. It covers the Latin, Greek and Cyrillic letters found in European and Vietnamese text.
+var compositions = map[rune]string{
+	// grave accent
+	'\u0300': "AÀEÈIÌOÒUÙaàeèiìoòuùÜǛüǜNǸnǹЕЀИЍеѐиѝĒḔēḕŌṐōṑWẀwẁÂẦâầĂẰăằÊỀêềÔỒôồƠỜơờƯỪưừYỲyỳ",
+	// acute accent
+	'\u0301': "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕSŚsśZŹzźÜǗüǘGǴgǵÅǺåǻÆǼæǽØǾøǿ¨΅ΑΆΕΈΗΉΙΊΟΌΥΎΩΏϊΐαάεέηήιίϋΰοόυύωώϒϓГЃКЌгѓкќÇḈçḉĒḖēḗÏḮïḯKḰkḱMḾmḿÕṌõṍŌṒōṓPṔpṕŨṸũṹWẂwẃÂẤâấĂẮăắÊẾêếÔỐôốƠỚơớƯỨưứ",
+	// circumflex accent
+	'\u0302': "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝWŴwŵYŶyŷZẐzẑẠẬạậẸỆẹệỌỘọộ",
+	// tilde
+	'\u0303': "AÃNÑOÕaãnñoõIĨiĩUŨuũVṼvṽÂẪâẫĂẴăẵEẼeẽÊỄêễÔỖôỗƠỠơỡƯỮưữYỸyỹ",
+	// macron
+	'\u0304': "AĀaāEĒeēIĪiīOŌoōUŪuūÜǕüǖÄǞäǟȦǠȧǡÆǢæǣǪǬǫǭÖȪöȫÕȬõȭȮȰȯȱYȲyȳИӢиӣУӮуӯGḠgḡḶḸḷḹṚṜṛṝ",
+	// breve
+	'\u0306': "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭУЎИЙийуўЖӁжӂАӐаӑЕӖеӗȨḜȩḝẠẶạặ",
+	// dot above
+	'\u0307': "CĊcċEĖeėGĠgġIİZŻzżAȦaȧOȮoȯBḂbḃDḊdḋFḞfḟHḢhḣMṀmṁNṄnṅPṖpṗRṘrṙSṠsṡŚṤśṥŠṦšṧṢṨṣṩTṪtṫWẆwẇXẊxẋYẎyẏſẛ",
+	// diaeresis
+	'\u0308': "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸΙΪΥΫιϊυϋϒϔЕЁІЇеёіїАӒаӓӘӚәӛЖӜжӝЗӞзӟИӤиӥОӦоӧӨӪөӫЭӬэӭУӰуӱЧӴчӵЫӸыӹHḦhḧÕṎõṏŪṺūṻWẄwẅXẌxẍtẗ",
+	// hook above
+	'\u0309': "AẢaảÂẨâẩĂẲăẳEẺeẻÊỂêểIỈiỉOỎoỏÔỔôổƠỞơởUỦuủƯỬưửYỶyỷ",
+	// ring above
+	'\u030a': "AÅaåUŮuůwẘyẙ",
+	// double acute accent
+	'\u030b': "OŐoőUŰuűУӲуӳ",
+	// caron
+	'\u030c': "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzžAǍaǎIǏiǐOǑoǒUǓuǔÜǙüǚGǦgǧKǨkǩƷǮʒǯjǰHȞhȟ",
+	// horn
+	'\u031b': "OƠoơUƯuư",
+	// dot below
+	'\u0323': "BḄbḅDḌdḍHḤhḥKḲkḳLḶlḷMṂmṃNṆnṇRṚrṛSṢsṣTṬtṭVṾvṿWẈwẉZẒzẓAẠaạEẸeẹIỊiịOỌoọƠỢơợUỤuụƯỰưựYỴyỵ",
+	// comma below
+	'\u0326': "SȘsșTȚtț",
+	// cedilla
+	'\u0327': "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţEȨeȩDḐdḑHḨhḩ",
+	// ogonek
+	'\u0328': "AĄaąEĘeęIĮiįUŲuųOǪoǫ",
+}
+
+// compatibility holds the compatibility decompositions applied by NFKD on top of the canonical ones
+var compatibility = map[rune]string{
+	'\u00a0': " ", '\u00aa': "a", '\u00b2': "2", '\u00b3': "3", '\u00b5': "\u03bc", '\u00b9': "1", '\u00ba': "o",
+	'\u00bc': "1\u20444", '\u00bd': "1\u20442", '\u00be': "3\u20444",
+	'\u0132': "IJ", '\u0133': "ij", '\u013f': "L\u00b7", '\u0140': "l\u00b7", '\u0149': "\u02bcn", '\u017f': "s",
+	'\u01c4': "D\u017d", '\u01c5': "D\u017e", '\u01c6': "d\u017e", '\u01c7': "LJ", '\u01c8': "Lj", '\u01c9': "lj",
+	'\u01ca': "NJ", '\u01cb': "Nj", '\u01cc': "nj", '\u01f1': "DZ", '\u01f2': "Dz", '\u01f3': "dz",
+	'\u2011': "\u2010", '\u2024': ".", '\u2025': "..", '\u2026': "...", '\u203c': "!!", '\u2047': "??", '\u2048': "?!", '\u2049': "!?",
+	'\u3000': " ", '\ufb00': "ff", '\ufb01': "fi", '\ufb02': "fl", '\ufb03': "ffi", '\ufb04': "ffl", '\ufb05': "st", '\ufb06': "st",
+}
+
+// unaccented maps letters that carry a diacritic but have no decomposition to their plain form
+var unaccented = map[rune]string{
+	'\u00c6': "AE", '\u00e6': "ae", '\u00d8': "O", '\u00f8': "o", '\u0110': "D", '\u0111': "d",
+	'\u0141': "L", '\u0142': "l", '\u0152': "OE", '\u0153': "oe", '\u0131': "i", '\u00df': "ss",
+}
+
+// decompositions and composed are built from the compositions table
+var (
+	decompositions = make(map[rune][2]rune)
+	composed       = make(map[[2]rune]rune)
+)
+
+func init() {
+	for mark, pairs := range compositions {
+		runes := []rune(pairs)
+		for i := 0; i+1 < len(runes); i += 2 {
+			decompositions[runes[i+1]] = [2]rune{runes[i], mark}
+			composed[[2]rune{runes[i], mark}] = runes[i+1]
+		}
+	}
+}
+
+// combiningClass returns the canonical combining class of r used to order combining marks. Starters have class 0.
+func combiningClass(r rune) int {
+	switch r {
+	case '\u031b':
+		return 216
+	case '\u0323', '\u0324', '\u0325', '\u0326', '\u032d', '\u032e', '\u0330', '\u0331':
+		return 220
+	case '\u0327', '\u0328':
+		return 202
+	}
+	if unicode.Is(unicode.Mn, r) {
+		return 230
+	}
+	return 0
+}
+
+// decompose appends the full decomposition of r to buf. Compatibility decompositions are only applied when compat is set.
+func decompose(buf []rune, r rune, compat bool) []rune {
+	if d, exists := decompositions[r]; exists {
+		buf = decompose(buf, d[0], compat)
+		return append(buf, d[1])
+	}
+	if compat {
+		if d, exists := compatibility[r]; exists {
+			for _, c := range d {
+				buf = decompose(buf, c, compat)
+			}
+			return buf
+		}
+		// fullwidth ASCII variants
+		if r >= '\uff01' && r <= '\uff5e' {
+			return append(buf, r-0xfee0)
+		}
+	}
+	return append(buf, r)
+}
+
+// decomposeString fully decomposes s and puts each run of combining marks into canonical order
+func decomposeString(s string, compat bool) []rune {
+	buf := make([]rune, 0, len(s))
+	for _, r := range s {
+		buf = decompose(buf, r, compat)
+	}
+
+	for i := 0; i < len(buf); {
+		if combiningClass(buf[i]) == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(buf) && combiningClass(buf[j]) != 0 {
+			j++
+		}
+		marks := buf[i:j]
+		sort.SliceStable(marks, func(a, b int) bool {
+			return combiningClass(marks[a]) < combiningClass(marks[b])
+		})
+		i = j
+	}
+	return buf
+}
+
+// NFD returns the canonical decomposition of s
+func NFD(s string) string {
+	return string(decomposeString(s, false))
+}
+
+// NFKD returns the compatibility decomposition of s, e.g. ligatures and fullwidth letters are replaced by their plain equivalents
+func NFKD(s string) string {
+	return string(decomposeString(s, true))
+}
+
+// NFC returns the canonical composition of s so that text typed with combining marks and text using precomposed letters compare equal
+func NFC(s string) string {
+	buf := decomposeString(s, false)
+
+	res := buf[:0]
+	starter := -1
+	lastClass := 0
+	for _, r := range buf {
+		class := combiningClass(r)
+		// a mark composes with the last starter unless another mark of the same class sits in between
+		if starter >= 0 && (len(res) == starter+1 || lastClass < class) {
+			if c, exists := composed[[2]rune{res[starter], r}]; exists {
+				res[starter] = c
+				continue
+			}
+		}
+		if class == 0 {
+			starter = len(res)
+		}
+		lastClass = class
+		res = append(res, r)
+	}
+	return string(res)
+}
+
+// FoldCase applies full Unicode case folding to s, e.g. "Straße" becomes "strasse" and final sigma folds to sigma
+func FoldCase(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\u00df', '\u1e9e':
+			b.WriteString("ss")
+		case '\u0130':
+			b.WriteString("i\u0307")
+		case '\u0149':
+			b.WriteString("\u02bcn")
+		case '\ufb00', '\ufb01', '\ufb02', '\ufb03', '\ufb04', '\ufb05', '\ufb06':
+			b.WriteString(compatibility[r])
+		default:
+			b.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+		}
+	}
+	return b.String()
+}
+
+// FoldAccents removes diacritics from s by taking its compatibility decomposition and dropping all combining marks
+func FoldAccents(s string) string {
+	var b strings.Builder
+	for _, r := range decomposeString(s, true) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if plain, exists := unaccented[r]; exists {
+			b.WriteString(plain)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
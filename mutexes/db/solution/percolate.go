@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MatchEvent is emitted when a newly indexed document matches a registered query
+type MatchEvent struct {
+	QueryID string
+	Doc     Document
+	Version uint64
+}
+
+// boolNode is a node of a parsed boolean query. A leaf matches documents containing any of its tokens.
+type boolNode struct {
+	op       boolOp
+	tokens   []string
+	children []*boolNode
+}
+
+// boolOp is the operator of a boolNode
+type boolOp int
+
+const (
+	opTerm boolOp = iota
+	opAnd
+	opOr
+	opNot
+)
+
+// match reports whether a document with the token set matches the node
+func (n *boolNode) match(tokens map[string]struct{}) bool {
+	switch n.op {
+	case opTerm:
+		for _, t := range n.tokens {
+			if _, exists := tokens[t]; exists {
+				return true
+			}
+		}
+		return false
+	case opAnd:
+		for _, c := range n.children {
+			if !c.match(tokens) {
+				return false
+			}
+		}
+		return true
+	case opOr:
+		for _, c := range n.children {
+			if c.match(tokens) {
+				return true
+			}
+		}
+		return false
+	case opNot:
+		return !n.children[0].match(tokens)
+	}
+	return false
+}
+
+// triggers returns tokens of which a matching document must contain at least one. nil means the node can match documents without any specific token, e.g. a negation.
+func (n *boolNode) triggers() []string {
+	switch n.op {
+	case opTerm:
+		return n.tokens
+	case opAnd:
+		// any child is required, the one with the fewest tokens is the most selective
+		var best []string
+		for _, c := range n.children {
+			if t := c.triggers(); t != nil && (best == nil || len(t) < len(best)) {
+				best = t
+			}
+		}
+		return best
+	case opOr:
+		var all []string
+		for _, c := range n.children {
+			t := c.triggers()
+			if t == nil {
+				return nil
+			}
+			all = append(all, t...)
+		}
+		return all
+	}
+	return nil
+}
+
+// boolParser parses the boolean query syntax of standing queries: words combined with AND, OR and NOT, a leading - as shorthand for NOT and parentheses for grouping. AND binds tighter than OR and words next to each other are combined with OR, like in Query.
+type boolParser struct {
+	words  []string
+	pos    int
+	tokens func(string) []string
+}
+
+// lexBool splits a boolean query into words and parentheses
+func lexBool(query string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range query {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			words = append(words, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// parseBool parses a boolean query, analyzing every word with tokens
+func parseBool(query string, tokens func(string) []string) (*boolNode, error) {
+	p := &boolParser{words: lexBool(query), tokens: tokens}
+	if len(p.words) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.words) {
+		return nil, fmt.Errorf("unexpected %q at word %d", p.words[p.pos], p.pos+1)
+	}
+	return n, nil
+}
+
+func (p *boolParser) peek() string {
+	if p.pos < len(p.words) {
+		return p.words[p.pos]
+	}
+	return ""
+}
+
+func (p *boolParser) or() (*boolNode, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	children := []*boolNode{n}
+	for p.pos < len(p.words) && p.peek() != ")" {
+		if p.peek() == "OR" {
+			p.pos++
+		}
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &boolNode{op: opOr, children: children}, nil
+}
+
+func (p *boolParser) and() (*boolNode, error) {
+	n, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	children := []*boolNode{n}
+	for p.peek() == "AND" {
+		p.pos++
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &boolNode{op: opAnd, children: children}, nil
+}
+
+func (p *boolParser) unary() (*boolNode, error) {
+	word := p.peek()
+	switch {
+	case word == "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case word == "NOT":
+		p.pos++
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &boolNode{op: opNot, children: []*boolNode{n}}, nil
+	case word == "(":
+		p.pos++
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) at word %d", p.pos+1)
+		}
+		p.pos++
+		return n, nil
+	case word == ")" || word == "AND" || word == "OR":
+		return nil, fmt.Errorf("unexpected %q at word %d", word, p.pos+1)
+	case strings.HasPrefix(word, "-") && len(word) > 1:
+		p.words[p.pos] = word[1:]
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &boolNode{op: opNot, children: []*boolNode{n}}, nil
+	}
+
+	p.pos++
+	tokens := p.tokens(word)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%q has no searchable tokens", word)
+	}
+	return &boolNode{op: opTerm, tokens: tokens}, nil
+}
+
+// standingQuery is a registered query of the percolator
+type standingQuery struct {
+	id       string
+	query    *boolNode
+	triggers []string
+}
+
+// Subscription receives match events on C. Events are dropped rather than blocking writers when C is full, Dropped counts them.
+type Subscription struct {
+	C <-chan MatchEvent
+
+	c       chan MatchEvent
+	p       *percolator
+	queries map[string]struct{}
+	dropped int
+}
+
+// percolator holds the standing queries of a DB, indexed by the tokens that trigger their evaluation
+type percolator struct {
+	mu      sync.Mutex
+	queries map[string]*standingQuery
+	byToken map[string]map[string]struct{}
+	always  map[string]struct{}
+	subs    map[*Subscription]struct{}
+}
+
+// newPercolator creates an empty percolator
+func newPercolator() *percolator {
+	return &percolator{
+		queries: make(map[string]*standingQuery),
+		byToken: make(map[string]map[string]struct{}),
+		always:  make(map[string]struct{}),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// RegisterQuery saves a standing query under the ID, replacing any query registered under the same ID. Every document indexed from now on is matched against it and subscribers are notified of matches. The query combines words with AND, OR and NOT, e.g. "queen AND (execution OR trial) AND NOT tarts", words next to each other are combined with OR like in Query.
+func (d *DB) RegisterQuery(id, query string) error {
+	n, err := parseBool(query, d.queryTokens)
+	if err != nil {
+		return fmt.Errorf("register query %s: %v", id, err)
+	}
+
+	p := d.percolator
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.unregister(id)
+	q := &standingQuery{id: id, query: n, triggers: n.triggers()}
+	p.queries[id] = q
+	if q.triggers == nil {
+		p.always[id] = struct{}{}
+	}
+	for _, t := range q.triggers {
+		if p.byToken[t] == nil {
+			p.byToken[t] = make(map[string]struct{})
+		}
+		p.byToken[t][id] = struct{}{}
+	}
+	return nil
+}
+
+// UnregisterQuery removes the standing query with the ID. An error is returned if no such query is registered.
+func (d *DB) UnregisterQuery(id string) error {
+	p := d.percolator
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.unregister(id) {
+		return fmt.Errorf("unregister query: %s not registered", id)
+	}
+	return nil
+}
+
+// unregister removes a query and reports whether it existed. Callers must hold the lock.
+func (p *percolator) unregister(id string) bool {
+	q, exists := p.queries[id]
+	if !exists {
+		return false
+	}
+	delete(p.queries, id)
+	delete(p.always, id)
+	for _, t := range q.triggers {
+		delete(p.byToken[t], id)
+		if len(p.byToken[t]) == 0 {
+			delete(p.byToken, t)
+		}
+	}
+	return true
+}
+
+// Subscribe returns a subscription receiving match events of the queries with the given IDs, or of every query when none are given. C buffers up to buffer events.
+func (d *DB) Subscribe(buffer int, queryIDs ...string) *Subscription {
+	c := make(chan MatchEvent, max(buffer, 0))
+	s := &Subscription{C: c, c: c, p: d.percolator}
+	if len(queryIDs) > 0 {
+		s.queries = make(map[string]struct{}, len(queryIDs))
+		for _, id := range queryIDs {
+			s.queries[id] = struct{}{}
+		}
+	}
+
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	s.p.subs[s] = struct{}{}
+	return s
+}
+
+// Close ends the subscription and closes C. Closing a subscription more than once has no effect.
+func (s *Subscription) Close() {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+
+	if _, exists := s.p.subs[s]; exists {
+		delete(s.p.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped returns the number of events dropped because C was full
+func (s *Subscription) Dropped() int {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	return s.dropped
+}
+
+// percolate matches a newly indexed document against the standing queries and notifies subscribers. Only queries triggered by a token of the document are evaluated. It runs within the commit, so events are delivered in commit order. Callers must hold the write lock.
+func (d *DB) percolate(v docVersion) {
+	p := d.percolator
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.queries) == 0 || len(p.subs) == 0 {
+		return
+	}
+
+	tokens := make(map[string]struct{})
+	candidates := make(map[string]struct{}, len(p.always))
+	for id := range p.always {
+		candidates[id] = struct{}{}
+	}
+	for _, t := range d.documentTokens(v.doc, v.fields) {
+		tokens[t] = struct{}{}
+		for id := range p.byToken[t] {
+			candidates[id] = struct{}{}
+		}
+	}
+
+	for id := range candidates {
+		if !p.queries[id].query.match(tokens) {
+			continue
+		}
+		e := MatchEvent{QueryID: id, Doc: v.doc, Version: v.created}
+		for s := range p.subs {
+			if s.queries != nil {
+				if _, exists := s.queries[id]; !exists {
+					continue
+				}
+			}
+			select {
+			case s.c <- e:
+			default:
+				s.dropped++
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+)
+
+func TestParseBool(t *testing.T) {
+	testData := []struct {
+		query   string
+		doc     string
+		matches bool
+	}{
+		{"queen AND execution", "the queen ordered the execution", true},
+		{"queen AND execution", "the queen of hearts", false},
+		{"queen execution", "the queen of hearts", true},
+		{"queen OR king AND tarts", "the queen of hearts", true},
+		{"(queen OR king) AND tarts", "the queen of hearts", false},
+		{"(queen OR king) AND tarts", "the king stole the tarts", true},
+		{"queen AND NOT hearts", "the queen of hearts", false},
+		{"queen AND -hearts", "the red queen", true},
+		{"NOT (queen OR king)", "the knave", true},
+		{"Queen's AND TRIAL", "the queen's trial", true},
+	}
+
+	db := NewDB()
+	for _, d := range testData {
+		n, err := parseBool(d.query, db.queryTokens)
+		if err != nil {
+			t.Errorf("Failed to parse %s, %v", d.query, err)
+			continue
+		}
+		tokens := make(map[string]struct{})
+		for _, tok := range db.indexTokens(d.doc) {
+			tokens[tok] = struct{}{}
+		}
+		if n.match(tokens) != d.matches {
+			t.Errorf("Expected %s matching %q to be %t", d.query, d.doc, d.matches)
+		}
+	}
+
+	invalid := []string{"", "queen AND", "AND queen", "(queen", "queen)", "queen AND ()", "NOT", "- queen", "queen AND ..."}
+	for _, q := range invalid {
+		if _, err := parseBool(q, db.queryTokens); err == nil {
+			t.Errorf("Should have returned an error for %q", q)
+		}
+	}
+}
+
+func TestPercolate(t *testing.T) {
+	db := NewDB()
+	db.Index(Document{ID: 0, Text: "the queen ordered an execution before the query existed"})
+
+	queries := map[string]string{
+		"execution": "queen AND execution",
+		"tarts":     "tarts",
+		"no-king":   "NOT king",
+	}
+	for id, q := range queries {
+		if err := db.RegisterQuery(id, q); err != nil {
+			t.Fatalf("Failed to register %s, %v", id, err)
+		}
+	}
+	if err := db.RegisterQuery("bad", "queen AND"); err == nil {
+		t.Error("Should have returned an error for an invalid query")
+	}
+
+	all := db.Subscribe(10)
+	tarts := db.Subscribe(10, "tarts")
+	small := db.Subscribe(1)
+
+	db.Index(Document{ID: 1, Text: "off with her head said the queen at the execution"})
+	db.Index(Document{ID: 2, Text: "the king stole the tarts"})
+	db.Delete(2)
+	db.IndexAll(context.Background(), []Document{{ID: 3, Text: "the knave of hearts"}})
+
+	all.Close()
+	tarts.Close()
+	small.Close()
+	small.Close()
+
+	var got []MatchEvent
+	for e := range all.C {
+		got = append(got, e)
+	}
+	sort.SliceStable(got, func(i, j int) bool { return got[i].Doc.ID < got[j].Doc.ID })
+	expected := []struct {
+		doc     int
+		queries []string
+	}{
+		{1, []string{"execution", "no-king"}},
+		{2, []string{"tarts"}},
+		{3, []string{"no-king"}},
+	}
+	var i int
+	for _, e := range expected {
+		var ids []string
+		for ; i < len(got) && got[i].Doc.ID == e.doc; i++ {
+			ids = append(ids, got[i].QueryID)
+		}
+		sort.Strings(ids)
+		if len(ids) != len(e.queries) {
+			t.Errorf("Expected %v for doc ID %d, but got %v", e.queries, e.doc, ids)
+			continue
+		}
+		for j := range ids {
+			if ids[j] != e.queries[j] {
+				t.Errorf("Expected %v for doc ID %d, but got %v", e.queries, e.doc, ids)
+			}
+		}
+	}
+	if i != len(got) {
+		t.Errorf("Expected no other events, but got %v", got[i:])
+	}
+
+	var tartEvents []MatchEvent
+	for e := range tarts.C {
+		tartEvents = append(tartEvents, e)
+	}
+	if len(tartEvents) != 1 || tartEvents[0].Doc.ID != 2 || tartEvents[0].Version != 3 {
+		t.Errorf("Expected a single event for doc ID 2 at version 3, but got %v", tartEvents)
+	}
+
+	if small.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped events, but got %d", small.Dropped())
+	}
+
+	// unregistered queries no longer match
+	if err := db.UnregisterQuery("tarts"); err != nil {
+		t.Errorf("Failed to unregister, %v", err)
+	}
+	if err := db.UnregisterQuery("tarts"); err == nil {
+		t.Error("Should have returned an error unregistering twice")
+	}
+	sub := db.Subscribe(10)
+	defer sub.Close()
+	db.Index(Document{ID: 4, Text: "more tarts for the king"})
+	select {
+	case e := <-sub.C:
+		t.Errorf("Expected no events, but got %v", e)
+	default:
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// propertyWords is the vocabulary of random corpora. It mixes case, accents and punctuation so the analyzer has to do its part, and a few words never used in documents are only queried.
+var propertyWords = []string{
+	"alice", "Alice", "ALICE!", "rabbit", "Rabbit's", "queen", "hearts,", "tea", "party.",
+	"café", "CAFÉ", "cafe", "Straße", "strasse", "don't", "e-mail", "3.14", "42",
+	"mad", "hatter", "the", "of", "and", "(dormouse)", "caterpillar?", "日本語",
+}
+
+// propertyQueryWords are only ever used in queries
+var propertyQueryWords = []string{"jabberwock", "snark", ""}
+
+// randomText joins up to n random words of the vocabulary
+func randomText(rng *rand.Rand, n int) string {
+	words := make([]string, rng.IntN(n+1))
+	for i := range words {
+		words[i] = propertyWords[rng.IntN(len(propertyWords))]
+	}
+	return strings.Join(words, " ")
+}
+
+// randomQuery picks one to three words, sometimes ones that are not in any document
+func randomQuery(rng *rand.Rand) string {
+	words := make([]string, 1+rng.IntN(3))
+	for i := range words {
+		if rng.IntN(5) == 0 {
+			words[i] = propertyQueryWords[rng.IntN(len(propertyQueryWords))]
+		} else {
+			words[i] = propertyWords[rng.IntN(len(propertyWords))]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// bruteForce scans the text of every document for any of the query tokens and returns the sorted IDs of the matches
+func bruteForce(a Analyzer, docs map[int]Document, query string) []int {
+	var ids []int
+	for id, doc := range docs {
+		if containsAny(a.Analyze(doc.Text), a.Tokens(query)) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// sortedIDs returns the sorted IDs of the documents and fails the test if an ID shows up twice
+func sortedIDs(t *testing.T, docs []Document) []int {
+	t.Helper()
+	ids := make([]int, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	slices.Sort(ids)
+	if len(slices.Compact(slices.Clone(ids))) != len(ids) {
+		t.Errorf("Expected unique documents, but got %v", ids)
+	}
+	return ids
+}
+
+func TestQueryProperty(t *testing.T) {
+	for seed := uint64(1); seed <= 10; seed++ {
+		rng := rand.New(rand.NewPCG(seed, seed))
+		db := NewDB()
+		model := make(map[int]Document)
+
+		for id := 0; id < 200; id++ {
+			doc := Document{ID: id, Text: randomText(rng, 12)}
+			if err := db.Index(doc); err != nil {
+				t.Fatalf("Failed to index doc ID %d, %v", id, err)
+			}
+			model[id] = doc
+		}
+		for id := range model {
+			if rng.IntN(4) == 0 {
+				db.Delete(id)
+				delete(model, id)
+			}
+		}
+		if seed%2 == 0 {
+			db.Vacuum()
+		}
+
+		for i := 0; i < 50; i++ {
+			query := randomQuery(rng)
+			res, err := db.Query(query)
+			if err != nil {
+				t.Fatalf("Failed to query %q, %v", query, err)
+			}
+			expected := bruteForce(db.analyzer, model, query)
+			if ids := sortedIDs(t, res); !slices.Equal(ids, expected) {
+				t.Errorf("Seed %d: expected %v for %q, but got %v", seed, expected, query, ids)
+			}
+
+			// the JSON match query runs through the same engine
+			res, _ = db.QueryJSON(fmt.Appendf(nil, `{"match": {"query": %q}}`, query))
+			if ids := sortedIDs(t, res); !slices.Equal(ids, expected) {
+				t.Errorf("Seed %d: expected %v for the match query %q, but got %v", seed, expected, query, ids)
+			}
+		}
+	}
+}
+
+// propertyRead is the result of a query run against a snapshot during TestConcurrentProperty
+type propertyRead struct {
+	version uint64
+	query   string
+	ids     []int
+}
+
+func TestConcurrentProperty(t *testing.T) {
+	const writers, readers, opsPerWriter = 4, 4, 300
+	db := NewDB(WithWriteLog(writers * opsPerWriter))
+
+	var wg sync.WaitGroup
+	wg.Add(writers)
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(w), 1))
+			// every writer owns a range of IDs, so it knows which of them are live
+			var live []int
+			next := w * 1000000
+			for i := 0; i < opsPerWriter; i++ {
+				switch op := rng.IntN(10); {
+				case op < 5 || len(live) == 0:
+					if err := db.Index(Document{ID: next, Text: randomText(rng, 8)}); err != nil {
+						t.Errorf("Failed to index doc ID %d, %v", next, err)
+						return
+					}
+					live = append(live, next)
+					next++
+				case op < 8:
+					i := rng.IntN(len(live))
+					if err := db.Delete(live[i]); err != nil {
+						t.Errorf("Failed to delete doc ID %d, %v", live[i], err)
+						return
+					}
+					live = slices.Delete(live, i, i+1)
+				default:
+					// replace a document in a single batch
+					id := live[rng.IntN(len(live))]
+					b := db.NewBatch()
+					b.Delete(id)
+					b.Index(Document{ID: id, Text: randomText(rng, 8)})
+					if err := b.Commit(); err != nil {
+						t.Errorf("Failed to replace doc ID %d, %v", id, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	var mu sync.Mutex
+	var reads []propertyRead
+	done := make(chan struct{})
+	var rwg sync.WaitGroup
+	rwg.Add(readers)
+	for r := 0; r < readers; r++ {
+		go func(r int) {
+			defer rwg.Done()
+			rng := rand.New(rand.NewPCG(uint64(r), 2))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s := db.Snapshot()
+				query := randomQuery(rng)
+				res, err := s.Query(query)
+				read := propertyRead{version: s.Version(), query: query, ids: sortedIDs(t, res)}
+				s.Release()
+				if err != nil {
+					t.Errorf("Failed to query %q, %v", query, err)
+					return
+				}
+				mu.Lock()
+				reads = append(reads, read)
+				mu.Unlock()
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	close(done)
+	rwg.Wait()
+
+	// replay the write log into a model and check every read against the model at the version it saw
+	byVersion := make(map[uint64][]propertyRead)
+	for _, r := range reads {
+		byVersion[r.version] = append(byVersion[r.version], r)
+	}
+	model := make(map[int]Document)
+	check := func(version uint64) {
+		for _, r := range byVersion[version] {
+			if expected := bruteForce(db.analyzer, model, r.query); !slices.Equal(r.ids, expected) {
+				t.Errorf("Expected %v for %q at version %d, but got %v", expected, r.query, version, r.ids)
+			}
+		}
+	}
+	check(0)
+	entries, ok, _ := db.log.since(0)
+	if !ok {
+		t.Fatal("Expected the write log to hold every write")
+	}
+	for _, e := range entries {
+		for _, op := range e.Ops {
+			switch op.Type {
+			case OpIndex:
+				model[op.Doc.ID] = op.Doc
+			case OpDelete:
+				delete(model, op.ID)
+			}
+		}
+		check(e.Version)
+	}
+
+	for i := 0; i < 50; i++ {
+		query := randomQuery(rand.New(rand.NewPCG(uint64(i), 3)))
+		res, _ := db.Query(query)
+		if expected, ids := bruteForce(db.analyzer, model, query), sortedIDs(t, res); !slices.Equal(ids, expected) {
+			t.Errorf("Expected %v for %q after the writes, but got %v", expected, query, ids)
+		}
+	}
+	if len(reads) == 0 {
+		t.Error("Expected reads to run during the writes")
+	}
+}
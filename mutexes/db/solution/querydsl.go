@@ -0,0 +1,741 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QueryError is returned for an invalid JSON query. Path locates the offending part of the query, e.g. "bool.must[1].range.gte", and is empty for the query as a whole.
+type QueryError struct {
+	Path string
+	Err  error
+}
+
+func (e *QueryError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("query: %v", e.Err)
+	}
+	return fmt.Sprintf("query: %s: %v", e.Path, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// queryErrorf creates a *QueryError at the path
+func queryErrorf(path, format string, a ...any) error {
+	return &QueryError{Path: path, Err: fmt.Errorf(format, a...)}
+}
+
+// dslNode is a compiled JSON query. eval returns the IDs of the matching documents in result order.
+type dslNode interface {
+	eval(s *Snapshot) []int
+}
+
+// dslTerms matches documents containing any of the terms, or all of them when and is set. Documents are ordered like the results of Query: term by term in posting list order.
+type dslTerms struct {
+	terms []string
+	and   bool
+}
+
+func (n *dslTerms) eval(s *Snapshot) []int {
+	if len(n.terms) == 0 {
+		return nil
+	}
+	if !n.and {
+		lists := make([][]int, len(n.terms))
+		for i, t := range n.terms {
+			lists[i] = s.postings(t)
+		}
+		return unionIDs(lists...)
+	}
+	ids := s.postings(n.terms[0])
+	for _, t := range n.terms[1:] {
+		ids = intersectIDs(ids, s.postings(t))
+	}
+	return ids
+}
+
+// dslPhrase matches documents whose text, or a text value of field, contains the tokens in a row. Candidates are the documents containing every token, which are then verified against their analyzed text.
+type dslPhrase struct {
+	field  string
+	tokens []string
+	terms  dslTerms
+}
+
+func (n *dslPhrase) eval(s *Snapshot) []int {
+	var ids []int
+	for _, id := range n.terms.eval(s) {
+		if s.phraseMatch(id, n.field, n.tokens) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// dslBool combines clauses: a document must match every must clause, at least minShould should clauses and none of the must_not clauses. Without must and should clauses every document matches.
+type dslBool struct {
+	must, should, mustNot []dslNode
+	minShould             int
+}
+
+func (n *dslBool) eval(s *Snapshot) []int {
+	var ids []int
+	switch {
+	case len(n.must) > 0:
+		ids = n.must[0].eval(s)
+		for _, c := range n.must[1:] {
+			ids = intersectIDs(ids, c.eval(s))
+		}
+	case len(n.should) > 0:
+		// the should clauses are evaluated below
+	default:
+		ids = s.ids()
+	}
+
+	if len(n.should) > 0 && (n.minShould > 0 || len(n.must) == 0) {
+		lists := make([][]int, len(n.should))
+		counts := make(map[int]int)
+		for i, c := range n.should {
+			lists[i] = c.eval(s)
+			for _, id := range lists[i] {
+				counts[id]++
+			}
+		}
+		if len(n.must) == 0 {
+			ids = unionIDs(lists...)
+		}
+		var res []int
+		for _, id := range ids {
+			if counts[id] >= max(n.minShould, 1) {
+				res = append(res, id)
+			}
+		}
+		ids = res
+	}
+
+	if len(n.mustNot) > 0 {
+		exclude := make(map[int]struct{})
+		for _, c := range n.mustNot {
+			for _, id := range c.eval(s) {
+				exclude[id] = struct{}{}
+			}
+		}
+		var res []int
+		for _, id := range ids {
+			if _, exists := exclude[id]; !exists {
+				res = append(res, id)
+			}
+		}
+		ids = res
+	}
+	return ids
+}
+
+// unionIDs merges the lists keeping the first occurrence of every ID
+func unionIDs(lists ...[]int) []int {
+	var res []int
+	seen := make(map[int]struct{})
+	for _, list := range lists {
+		for _, id := range list {
+			if _, exists := seen[id]; !exists {
+				seen[id] = struct{}{}
+				res = append(res, id)
+			}
+		}
+	}
+	return res
+}
+
+// intersectIDs keeps the IDs of a that are also in b, in the order of a
+func intersectIDs(a, b []int) []int {
+	in := make(map[int]struct{}, len(b))
+	for _, id := range b {
+		in[id] = struct{}{}
+	}
+	var res []int
+	for _, id := range a {
+		if _, exists := in[id]; exists {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// phraseMatch reports whether the document visible to the snapshot contains the tokens in a row, in its text or in a string value of field
+func (s *Snapshot) phraseMatch(id int, field string, tokens []string) bool {
+	s.db.mu.RLock()
+	v, exists := s.lookup(id)
+	s.db.mu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	texts := []string{v.doc.Text}
+	if field != "" {
+		texts = nil
+		for _, fv := range v.fields[field] {
+			if fv.Type == FieldText || fv.Type == FieldKeyword {
+				texts = append(texts, fv.String)
+			}
+		}
+	}
+	for _, text := range texts {
+		if containsRun(s.db.analyzer.Tokens(text), tokens) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsRun reports whether run appears in tokens as consecutive tokens
+func containsRun(tokens, run []string) bool {
+	for i := 0; i+len(run) <= len(tokens); i++ {
+		j := 0
+		for j < len(run) && tokens[i+j] == run[j] {
+			j++
+		}
+		if j == len(run) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryJSON runs a query written in the JSON query DSL. A query is an object with a single key naming its type:
+//
+//	{"term": {"field": "author", "value": "carroll"}}          exact term, the value is normalized like a token but not tokenized
+//	{"match": {"field": "title", "query": "white rabbit", "operator": "and"}}   analyzed query, any token matches unless operator is "and"
+//	{"phrase": {"query": "white rabbit"}}                       analyzed tokens in a row
+//	{"bool": {"must": [...], "should": [...], "must_not": [...], "minimum_should_match": 1}}
+//	{"range": {"field": "year", "gte": 1860, "lt": 1900}}       numeric range on number fields, lexical range on string fields
+//	{"prefix": {"value": "rab"}}                                terms starting with the value
+//	{"fuzzy": {"value": "rabit", "fuzziness": 1}}               terms within the edit distance, by default 1 for terms up to 4 runes and 2 beyond
+//
+// The field is optional except for range, without it the query runs against the document text. A match query without field returns the same documents in the same order as Query. A bool query with should clauses and no must clause requires at least one should clause to match, with must clauses should clauses only filter when minimum_should_match is set. An invalid query returns a *QueryError pointing to the offending path.
+func (d *DB) QueryJSON(src []byte) ([]Document, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.QueryJSON(src)
+}
+
+// QueryJSON runs a JSON query against the snapshot, see DB.QueryJSON
+func (s *Snapshot) QueryJSON(src []byte) ([]Document, error) {
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return []Document{}, &QueryError{Err: err}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return []Document{}, queryErrorf("", "unexpected data after the query")
+	}
+
+	c := &dslCompiler{s: s, schema: s.db.Schema()}
+	n, err := c.compile(v, "")
+	if err != nil {
+		return []Document{}, err
+	}
+
+	res := []Document{}
+	for _, id := range n.eval(s) {
+		if doc, err := s.Get(id); err == nil {
+			res = append(res, doc)
+		}
+	}
+	return res, nil
+}
+
+// dslCompiler turns a decoded JSON query into dslNodes, checking fields against the schema
+type dslCompiler struct {
+	s      *Snapshot
+	schema map[string]FieldType
+	terms  []string
+}
+
+// dslArgs are the parameters of a query type, path is the path of the parameter object
+type dslArgs struct {
+	m    map[string]any
+	path string
+}
+
+// joinPath appends a key to a query path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonKind names the JSON type of a decoded value for error messages
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+// args checks that v is an object holding only the known parameters
+func (c *dslCompiler) args(v any, path string, known ...string) (dslArgs, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return dslArgs{}, queryErrorf(path, "expected an object, got %s", jsonKind(v))
+	}
+	for key := range m {
+		found := false
+		for _, k := range known {
+			found = found || k == key
+		}
+		if !found {
+			return dslArgs{}, queryErrorf(joinPath(path, key), "unknown parameter")
+		}
+	}
+	return dslArgs{m: m, path: path}, nil
+}
+
+// string returns the string parameter, an empty string if it is absent and not required
+func (a dslArgs) string(key string, required bool) (string, error) {
+	v, exists := a.m[key]
+	if !exists {
+		if required {
+			return "", queryErrorf(joinPath(a.path, key), "missing")
+		}
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", queryErrorf(joinPath(a.path, key), "expected a string, got %s", jsonKind(v))
+	}
+	return s, nil
+}
+
+// int returns the non negative integer parameter or def if it is absent
+func (a dslArgs) int(key string, def int) (int, error) {
+	v, exists := a.m[key]
+	if !exists {
+		return def, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, queryErrorf(joinPath(a.path, key), "expected a number, got %s", jsonKind(v))
+	}
+	i, err := strconv.Atoi(n.String())
+	if err != nil || i < 0 {
+		return 0, queryErrorf(joinPath(a.path, key), "expected a non negative integer, got %s", n)
+	}
+	return i, nil
+}
+
+// field returns the optional field parameter and its type, zero for the document text
+func (c *dslCompiler) field(a dslArgs, required bool) (string, FieldType, error) {
+	field, err := a.string("field", required)
+	if err != nil || field == "" {
+		return "", 0, err
+	}
+	t, exists := c.schema[field]
+	if !exists {
+		return "", 0, queryErrorf(joinPath(a.path, "field"), "unknown field %q", field)
+	}
+	return field, t, nil
+}
+
+// normalize applies the token filters of the analyzer to a single value without tokenizing it
+func (c *dslCompiler) normalize(value string) string {
+	tokens := []string{value}
+	for _, f := range c.s.db.analyzer.Filters {
+		tokens = f(tokens)
+	}
+	if len(tokens) != 1 {
+		return value
+	}
+	return tokens[0]
+}
+
+// indexTerms returns the terms of the index in sorted order, loaded once per query
+func (c *dslCompiler) indexTerms() []string {
+	if c.terms == nil {
+		c.terms = c.s.terms()
+		sort.Strings(c.terms)
+	}
+	return c.terms
+}
+
+// expand returns the sorted terms of the field, or the plain tokens for the document text, whose value is accepted by match
+func (c *dslCompiler) expand(field string, match func(value string) bool) []string {
+	var res []string
+	for _, t := range c.indexTerms() {
+		value := t
+		if field == "" {
+			if isFieldTerm(t) {
+				continue
+			}
+		} else {
+			var found bool
+			// a path with a space is the prefix of the terms of a longer path, values never hold one
+			if value, found = strings.CutPrefix(t, field+fieldSep); !found || isFieldTerm(value) {
+				continue
+			}
+		}
+		if match(value) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// compile compiles the query at path
+func (c *dslCompiler) compile(v any, path string) (dslNode, error) {
+	m, ok := v.(map[string]any)
+	if !ok || len(m) != 1 {
+		if ok {
+			return nil, queryErrorf(path, "expected a single query type, got %d keys", len(m))
+		}
+		return nil, queryErrorf(path, "expected a query object, got %s", jsonKind(v))
+	}
+
+	for typ, body := range m {
+		path = joinPath(path, typ)
+		switch typ {
+		case "term":
+			return c.term(body, path)
+		case "match":
+			return c.match(body, path)
+		case "phrase":
+			return c.phrase(body, path)
+		case "bool":
+			return c.boolQuery(body, path)
+		case "range":
+			return c.rangeQuery(body, path)
+		case "prefix":
+			return c.prefix(body, path)
+		case "fuzzy":
+			return c.fuzzy(body, path)
+		}
+		return nil, queryErrorf(path, "unknown query type")
+	}
+	return nil, nil
+}
+
+func (c *dslCompiler) term(body any, path string) (dslNode, error) {
+	a, err := c.args(body, path, "field", "value")
+	if err != nil {
+		return nil, err
+	}
+	field, t, err := c.field(a, false)
+	if err != nil {
+		return nil, err
+	}
+	v, exists := a.m["value"]
+	if !exists {
+		return nil, queryErrorf(joinPath(path, "value"), "missing")
+	}
+	valuePath := joinPath(path, "value")
+	expected := "a string"
+
+	switch t {
+	case 0:
+		if s, ok := v.(string); ok {
+			return &dslTerms{terms: []string{c.normalize(s)}}, nil
+		}
+	case FieldText:
+		// short values of a text field are indexed as keywords
+		if s, ok := v.(string); ok {
+			return &dslTerms{terms: uniqueTokens([]string{field + fieldSep + c.normalize(s), field + fieldSep + FoldCase(s)})}, nil
+		}
+	case FieldKeyword:
+		if s, ok := v.(string); ok {
+			return &dslTerms{terms: []string{field + fieldSep + FoldCase(s)}}, nil
+		}
+	case FieldNumber:
+		if n, ok := v.(json.Number); ok {
+			f, err := n.Float64()
+			if err != nil {
+				return nil, queryErrorf(valuePath, "%v", err)
+			}
+			return &dslTerms{terms: []string{field + fieldSep + strconv.FormatFloat(f, 'g', -1, 64)}}, nil
+		}
+		expected = "a number"
+	case FieldBool:
+		if b, ok := v.(bool); ok {
+			return &dslTerms{terms: []string{field + fieldSep + strconv.FormatBool(b)}}, nil
+		}
+		expected = "a bool"
+	}
+	return nil, queryErrorf(valuePath, "expected %s for field %q, got %s", expected, field, jsonKind(v))
+}
+
+func (c *dslCompiler) match(body any, path string) (dslNode, error) {
+	a, err := c.args(body, path, "field", "query", "operator")
+	if err != nil {
+		return nil, err
+	}
+	field, t, err := c.field(a, false)
+	if err != nil {
+		return nil, err
+	}
+	query, err := a.string("query", true)
+	if err != nil {
+		return nil, err
+	}
+	operator, err := a.string("operator", false)
+	if err != nil {
+		return nil, err
+	}
+	if operator != "" && operator != "or" && operator != "and" {
+		return nil, queryErrorf(joinPath(path, "operator"), "expected \"or\" or \"and\", got %q", operator)
+	}
+
+	switch t {
+	case 0:
+		return &dslTerms{terms: c.s.db.queryTokens(query), and: operator == "and"}, nil
+	case FieldText:
+		tokens := &dslTerms{and: operator == "and"}
+		for _, tok := range c.s.db.queryTokens(query) {
+			tokens.terms = append(tokens.terms, field+fieldSep+tok)
+		}
+		// short values of a text field are indexed as keywords
+		return &dslBool{should: []dslNode{tokens, &dslTerms{terms: []string{field + fieldSep + FoldCase(query)}}}}, nil
+	}
+
+	// other fields hold exact values, match behaves like term with the query string as value
+	v := any(query)
+	switch t {
+	case FieldNumber:
+		v = json.Number(query)
+		if _, err := strconv.ParseFloat(query, 64); err != nil {
+			return nil, queryErrorf(joinPath(path, "query"), "field %q is a number, got %q", field, query)
+		}
+	case FieldBool:
+		b, err := strconv.ParseBool(query)
+		if err != nil {
+			return nil, queryErrorf(joinPath(path, "query"), "field %q is a bool, got %q", field, query)
+		}
+		v = b
+	}
+	return c.term(map[string]any{"field": field, "value": v}, path)
+}
+
+func (c *dslCompiler) phrase(body any, path string) (dslNode, error) {
+	a, err := c.args(body, path, "field", "query")
+	if err != nil {
+		return nil, err
+	}
+	field, t, err := c.field(a, false)
+	if err != nil {
+		return nil, err
+	}
+	if t != 0 && t != FieldText && t != FieldKeyword {
+		return nil, queryErrorf(joinPath(path, "field"), "phrase needs a string field, %q is %v", field, t)
+	}
+	query, err := a.string("query", true)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &dslPhrase{field: field, tokens: c.s.db.analyzer.Tokens(query), terms: dslTerms{and: true}}
+	for _, tok := range uniqueTokens(n.tokens) {
+		if field != "" {
+			tok = field + fieldSep + tok
+		}
+		n.terms.terms = append(n.terms.terms, tok)
+	}
+	if field != "" && len(n.tokens) > 0 {
+		// a keyword value holds the whole phrase in a single term
+		return &dslBool{should: []dslNode{n, &dslTerms{terms: []string{field + fieldSep + FoldCase(query)}}}}, nil
+	}
+	return n, nil
+}
+
+func (c *dslCompiler) boolQuery(body any, path string) (dslNode, error) {
+	a, err := c.args(body, path, "must", "should", "must_not", "minimum_should_match")
+	if err != nil {
+		return nil, err
+	}
+
+	n := &dslBool{}
+	clauses := func(key string) ([]dslNode, error) {
+		v, exists := a.m[key]
+		if !exists {
+			return nil, nil
+		}
+		list, ok := v.([]any)
+		if !ok {
+			// a single clause may be given without the array
+			list = []any{v}
+		}
+		nodes := make([]dslNode, len(list))
+		for i, q := range list {
+			p := joinPath(path, key)
+			if ok {
+				p = fmt.Sprintf("%s[%d]", p, i)
+			}
+			if nodes[i], err = c.compile(q, p); err != nil {
+				return nil, err
+			}
+		}
+		return nodes, nil
+	}
+	if n.must, err = clauses("must"); err != nil {
+		return nil, err
+	}
+	if n.should, err = clauses("should"); err != nil {
+		return nil, err
+	}
+	if n.mustNot, err = clauses("must_not"); err != nil {
+		return nil, err
+	}
+	if n.minShould, err = a.int("minimum_should_match", 0); err != nil {
+		return nil, err
+	}
+	if n.minShould > len(n.should) {
+		return nil, queryErrorf(joinPath(path, "minimum_should_match"), "%d exceeds the %d should clauses", n.minShould, len(n.should))
+	}
+	return n, nil
+}
+
+func (c *dslCompiler) rangeQuery(body any, path string) (dslNode, error) {
+	a, err := c.args(body, path, "field", "gt", "gte", "lt", "lte")
+	if err != nil {
+		return nil, err
+	}
+	field, t, err := c.field(a, true)
+	if err != nil {
+		return nil, err
+	}
+	if t == FieldBool {
+		return nil, queryErrorf(joinPath(path, "field"), "range needs a number or string field, %q is %v", field, t)
+	}
+
+	// a bound accepts the comparison of a field value with its parameter
+	type bound struct {
+		key string
+		ok  func(cmp int) bool
+	}
+	bounds := []bound{
+		{"gt", func(cmp int) bool { return cmp > 0 }},
+		{"gte", func(cmp int) bool { return cmp >= 0 }},
+		{"lt", func(cmp int) bool { return cmp < 0 }},
+		{"lte", func(cmp int) bool { return cmp <= 0 }},
+	}
+	var checks []func(value string) bool
+	for _, b := range bounds {
+		v, exists := a.m[b.key]
+		if !exists {
+			continue
+		}
+		p := joinPath(path, b.key)
+		switch t {
+		case FieldNumber:
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, queryErrorf(p, "expected a number for field %q, got %s", field, jsonKind(v))
+			}
+			f, err := n.Float64()
+			if err != nil {
+				return nil, queryErrorf(p, "%v", err)
+			}
+			checks = append(checks, func(value string) bool {
+				x, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return false
+				}
+				switch {
+				case x < f:
+					return b.ok(-1)
+				case x > f:
+					return b.ok(1)
+				}
+				return b.ok(0)
+			})
+		default:
+			s, ok := v.(string)
+			if !ok {
+				return nil, queryErrorf(p, "expected a string for field %q, got %s", field, jsonKind(v))
+			}
+			s = FoldCase(s)
+			checks = append(checks, func(value string) bool { return b.ok(strings.Compare(value, s)) })
+		}
+	}
+	if len(checks) == 0 {
+		return nil, queryErrorf(path, "expected at least one of gt, gte, lt or lte")
+	}
+
+	return &dslTerms{terms: c.expand(field, func(value string) bool {
+		for _, check := range checks {
+			if !check(value) {
+				return false
+			}
+		}
+		return true
+	})}, nil
+}
+
+// stringTermArgs parses the parameters shared by prefix and fuzzy, which only apply to the document text and string fields
+func (c *dslCompiler) stringTermArgs(body any, path string, known ...string) (dslArgs, string, string, error) {
+	a, err := c.args(body, path, append(known, "field", "value")...)
+	if err != nil {
+		return a, "", "", err
+	}
+	field, t, err := c.field(a, false)
+	if err != nil {
+		return a, "", "", err
+	}
+	if t == FieldNumber || t == FieldBool {
+		return a, "", "", queryErrorf(joinPath(path, "field"), "expected a string field, %q is %v", field, t)
+	}
+	value, err := a.string("value", true)
+	if err != nil {
+		return a, "", "", err
+	}
+	if value == "" {
+		return a, "", "", queryErrorf(joinPath(path, "value"), "empty")
+	}
+	if t == FieldKeyword {
+		return a, field, FoldCase(value), nil
+	}
+	return a, field, c.normalize(value), nil
+}
+
+func (c *dslCompiler) prefix(body any, path string) (dslNode, error) {
+	_, field, value, err := c.stringTermArgs(body, path)
+	if err != nil {
+		return nil, err
+	}
+	return &dslTerms{terms: c.expand(field, func(term string) bool {
+		return strings.HasPrefix(term, value)
+	})}, nil
+}
+
+func (c *dslCompiler) fuzzy(body any, path string) (dslNode, error) {
+	a, field, value, err := c.stringTermArgs(body, path, "fuzziness")
+	if err != nil {
+		return nil, err
+	}
+	dist := 1
+	if len([]rune(value)) > 4 {
+		dist = 2
+	}
+	if dist, err = a.int("fuzziness", dist); err != nil {
+		return nil, err
+	}
+	if dist > 2 {
+		return nil, queryErrorf(joinPath(path, "fuzziness"), "at most 2 edits are supported, got %d", dist)
+	}
+	return &dslTerms{terms: c.expand(field, func(term string) bool {
+		return editDistance(value, term, dist) <= dist
+	})}, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestQueryJSON(t *testing.T) {
+	db := NewDB()
+	db.Index(Document{ID: 1, Text: "Alice follows the White Rabbit"})
+	db.Index(Document{ID: 2, Text: "the rabbit is late"})
+	db.Index(Document{ID: 3, Text: "white is the queen and alice is late"})
+	books := []string{
+		`{"title": "Alice in Wonderland", "author": "Carroll", "year": 1865, "tags": ["rabbit", "queen"], "public": true}`,
+		`{"title": "Through the Looking-Glass", "author": "Carroll", "year": 1871, "tags": ["queen"], "public": true}`,
+		`{"title": "The Hunting of the Snark", "author": "Carroll", "year": 1876, "public": false}`,
+		`{"title": "Peter Pan", "author": "Barrie", "year": 1911}`,
+	}
+	for i, src := range books {
+		if err := db.IndexJSON(10+i, []byte(src)); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", 10+i, err)
+		}
+	}
+
+	// the text of a JSON document is made of its string values, so the books also match queries without a field
+	testData := []struct {
+		query    string
+		expected []int
+	}{
+		{`{"term": {"value": "Rabbit"}}`, []int{1, 2, 10}},
+		{`{"term": {"field": "author", "value": "carroll"}}`, []int{10, 11, 12}},
+		{`{"term": {"field": "year", "value": 1871}}`, []int{11}},
+		{`{"term": {"field": "public", "value": false}}`, []int{12}},
+		{`{"term": {"field": "tags", "value": "queen"}}`, []int{10, 11}},
+		{`{"match": {"query": "late queen"}}`, []int{2, 3, 10, 11}},
+		{`{"match": {"query": "white alice", "operator": "and"}}`, []int{1, 3}},
+		{`{"match": {"field": "title", "query": "the snark"}}`, []int{12, 11}},
+		{`{"match": {"field": "title", "query": "peter pan", "operator": "and"}}`, []int{13}},
+		{`{"match": {"field": "year", "query": "1865"}}`, []int{10}},
+		{`{"phrase": {"query": "white rabbit"}}`, []int{1}},
+		{`{"phrase": {"query": "rabbit white"}}`, nil},
+		{`{"phrase": {"field": "title", "query": "looking glass"}}`, []int{11}},
+		{`{"range": {"field": "year", "gte": 1865, "lt": 1876}}`, []int{10, 11}},
+		{`{"range": {"field": "year", "gt": 1900}}`, []int{13}},
+		{`{"range": {"field": "author", "lt": "c"}}`, []int{13}},
+		{`{"prefix": {"value": "Rab"}}`, []int{1, 2, 10}},
+		{`{"prefix": {"field": "author", "value": "car"}}`, []int{10, 11, 12}},
+		{`{"fuzzy": {"value": "rabit"}}`, []int{1, 2, 10}},
+		{`{"fuzzy": {"value": "qeen", "fuzziness": 0}}`, nil},
+		{`{"fuzzy": {"field": "author", "value": "barry"}}`, []int{13}},
+		{`{"bool": {"must": [{"term": {"value": "alice"}}], "must_not": {"term": {"value": "rabbit"}}}}`, []int{3}},
+		{`{"bool": {"should": [{"term": {"value": "queen"}}, {"term": {"value": "rabbit"}}]}}`, []int{3, 10, 11, 1, 2}},
+		{`{"bool": {"must": [{"term": {"field": "author", "value": "carroll"}}], "should": [{"term": {"field": "tags", "value": "queen"}}, {"term": {"field": "public", "value": true}}], "minimum_should_match": 2}}`, []int{10, 11}},
+		{`{"bool": {"must_not": [{"range": {"field": "year", "lt": 1900}}, {"term": {"value": "the"}}]}}`, []int{13}},
+		{`{"bool": {}}`, []int{1, 2, 3, 10, 11, 12, 13}},
+	}
+	for _, d := range testData {
+		res, err := db.QueryJSON([]byte(d.query))
+		if err != nil {
+			t.Errorf("Failed to run %s, %v", d.query, err)
+			continue
+		}
+		ids := make([]int, len(res))
+		for i, doc := range res {
+			ids[i] = doc.ID
+		}
+		if !slices.Equal(ids, d.expected) {
+			t.Errorf("Expected %v for %s, but got %v", d.expected, d.query, ids)
+		}
+	}
+
+	// a match query on the text runs the same as Query
+	expected, _ := db.Query("Alice and the Queen")
+	res, _ := db.QueryJSON([]byte(`{"match": {"query": "Alice and the Queen"}}`))
+	if len(res) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, res)
+	}
+	for i := range res {
+		if res[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, res)
+		}
+	}
+}
+
+func TestQueryJSONErrors(t *testing.T) {
+	db := NewDB()
+	db.IndexJSON(1, []byte(`{"title": "Alice in Wonderland", "year": 1865, "public": true}`))
+
+	testData := []struct {
+		query string
+		path  string
+	}{
+		{`{"term": `, ""},
+		{`{"term": {"value": "a"}} {}`, ""},
+		{`[]`, ""},
+		{`{"term": {"value": "a"}, "match": {"query": "a"}}`, ""},
+		{`{"wildcard": {"value": "a*"}}`, "wildcard"},
+		{`{"term": {"value": "a", "boost": 2}}`, "term.boost"},
+		{`{"term": {"field": "titel", "value": "alice"}}`, "term.field"},
+		{`{"term": {"field": "year", "value": "1865"}}`, "term.value"},
+		{`{"term": {"field": "title"}}`, "term.value"},
+		{`{"match": {"query": "a", "operator": "xor"}}`, "match.operator"},
+		{`{"match": {"field": "year", "query": "recent"}}`, "match.query"},
+		{`{"phrase": {"field": "public", "query": "true"}}`, "phrase.field"},
+		{`{"bool": {"must": [{"term": {"value": "a"}}, {"range": {"field": "year", "gte": "old"}}]}}`, "bool.must[1].range.gte"},
+		{`{"bool": {"should": {"prefix": {"value": 3}}}}`, "bool.should.prefix.value"},
+		{`{"bool": {"must_not": [{"bool": {"filter": []}}]}}`, "bool.must_not[0].bool.filter"},
+		{`{"bool": {"should": [{"term": {"value": "a"}}], "minimum_should_match": 2}}`, "bool.minimum_should_match"},
+		{`{"range": {"year": {"gte": 1865}}}`, "range.year"},
+		{`{"range": {"field": "year"}}`, "range"},
+		{`{"range": {"field": "public", "gt": 0}}`, "range.field"},
+		{`{"prefix": {"value": ""}}`, "prefix.value"},
+		{`{"fuzzy": {"value": "alice", "fuzziness": 3}}`, "fuzzy.fuzziness"},
+		{`{"fuzzy": {"field": "year", "value": "1865"}}`, "fuzzy.field"},
+	}
+	for _, d := range testData {
+		_, err := db.QueryJSON([]byte(d.query))
+		var qe *QueryError
+		if !errors.As(err, &qe) {
+			t.Errorf("Expected a query error for %s, but got %v", d.query, err)
+			continue
+		}
+		if qe.Path != d.path {
+			t.Errorf("Expected the error at %q for %s, but got %v", d.path, d.query, err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// LogEntry is a committed write. Version is the db version the operations were committed at on the leader.
+type LogEntry struct {
+	Version uint64
+	Ops     []Op
+}
+
+// writeLog keeps the most recent log entries of a DB so followers can catch up without a full snapshot
+type writeLog struct {
+	entries []LogEntry
+	size    int
+	last    uint64
+	wait    chan struct{}
+}
+
+// WithWriteLog keeps the last size committed writes in memory so that the db can act as a replication leader
+func WithWriteLog(size int) Option {
+	return func(d *DB) {
+		d.log = &writeLog{size: max(size, 1), wait: make(chan struct{})}
+	}
+}
+
+// append adds a committed entry and wakes up everyone waiting for new entries. Callers must hold the write lock.
+func (l *writeLog) append(e LogEntry) {
+	l.entries = append(l.entries, e)
+	if len(l.entries) > 2*l.size {
+		l.entries = append([]LogEntry(nil), l.entries[len(l.entries)-l.size:]...)
+	}
+	l.last = e.Version
+	close(l.wait)
+	l.wait = make(chan struct{})
+}
+
+// since returns the entries committed after version. ok is false when the log no longer holds all of them, or the version is unknown, and the reader has to start over from a snapshot. wait is closed when the next entry is appended. Callers must hold the lock.
+func (l *writeLog) since(version uint64) (entries []LogEntry, ok bool, wait <-chan struct{}) {
+	if version > l.last {
+		return nil, false, l.wait
+	}
+	if version == l.last {
+		return nil, true, l.wait
+	}
+	if len(l.entries) == 0 || l.entries[0].Version > version+1 {
+		return nil, false, l.wait
+	}
+
+	i := 0
+	for i < len(l.entries) && l.entries[i].Version <= version {
+		i++
+	}
+	return append([]LogEntry(nil), l.entries[i:]...), true, l.wait
+}
+
+// message kinds of the replication protocol
+const (
+	msgHello = iota + 1
+	msgSnapshotDoc
+	msgSnapshotEnd
+	msgEntry
+)
+
+// replMessage is sent over the replication connection. A follower opens with a hello carrying the last leader version it applied. The leader answers with either the log entries after that version or, when they are gone, every document of a snapshot followed by the entries after the snapshot.
+type replMessage struct {
+	Kind    int
+	Version uint64
+	Doc     Document
+	Entry   LogEntry
+}
+
+// Leader streams the write log of a DB to followers connecting over TCP
+type Leader struct {
+	db    *DB
+	ln    net.Listener
+	done  chan struct{}
+	once  sync.Once
+	conns sync.WaitGroup
+}
+
+// NewLeader creates a leader serving db on the listener. The db must have been created with WithWriteLog.
+func NewLeader(db *DB, ln net.Listener) (*Leader, error) {
+	if db.log == nil {
+		return nil, fmt.Errorf("leader: db has no write log")
+	}
+	return &Leader{db: db, ln: ln, done: make(chan struct{})}, nil
+}
+
+// Serve accepts followers until the leader is closed
+func (l *Leader) Serve() error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		l.conns.Add(1)
+		go func() {
+			defer l.conns.Done()
+			defer conn.Close()
+			l.serveFollower(conn)
+		}()
+	}
+}
+
+// Close stops accepting followers and disconnects the connected ones
+func (l *Leader) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.ln.Close()
+	})
+	l.conns.Wait()
+	return err
+}
+
+// serveFollower streams to a single follower until the connection breaks or the leader is closed
+func (l *Leader) serveFollower(conn net.Conn) error {
+	// unblock pending reads and writes once the leader is closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-l.done:
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+
+	var hello replMessage
+	if err := dec.Decode(&hello); err != nil {
+		return err
+	}
+	if hello.Kind != msgHello {
+		return fmt.Errorf("leader: expected hello, got message kind %d", hello.Kind)
+	}
+
+	version := hello.Version
+	for {
+		l.db.mu.RLock()
+		entries, ok, wait := l.db.log.since(version)
+		l.db.mu.RUnlock()
+
+		if !ok {
+			v, err := l.sendSnapshot(enc)
+			if err != nil {
+				return err
+			}
+			version = v
+			continue
+		}
+
+		for _, e := range entries {
+			if err := enc.Encode(replMessage{Kind: msgEntry, Version: e.Version, Entry: e}); err != nil {
+				return err
+			}
+			version = e.Version
+		}
+
+		if len(entries) == 0 {
+			select {
+			case <-wait:
+			case <-l.done:
+				return nil
+			}
+		}
+	}
+}
+
+// sendSnapshot sends every document of a fresh snapshot and returns its version. Expired documents that were not removed yet are sent as well, the log deletes them later.
+func (l *Leader) sendSnapshot(enc *gob.Encoder) (uint64, error) {
+	s := l.db.Snapshot()
+	defer s.Release()
+
+	for _, doc := range s.currentDocs() {
+		if err := enc.Encode(replMessage{Kind: msgSnapshotDoc, Doc: doc}); err != nil {
+			return 0, err
+		}
+	}
+	if err := enc.Encode(replMessage{Kind: msgSnapshotEnd, Version: s.Version()}); err != nil {
+		return 0, err
+	}
+	return s.Version(), nil
+}
+
+// Follower applies the write log of a leader to a local DB, which can serve reads in the meantime. The local DB must not be written to directly.
+type Follower struct {
+	db *DB
+
+	mu      sync.Mutex
+	applied uint64
+	changed chan struct{}
+}
+
+// NewFollower creates a follower replicating into db
+func NewFollower(db *DB) *Follower {
+	return &Follower{db: db, changed: make(chan struct{})}
+}
+
+// DB returns the local db of the follower for reads
+func (f *Follower) DB() *DB {
+	return f.db
+}
+
+// Applied returns the last leader version applied to the local db
+func (f *Follower) Applied() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applied
+}
+
+// WaitFor blocks until the leader version has been applied or the context is done
+func (f *Follower) WaitFor(ctx context.Context, version uint64) error {
+	for {
+		f.mu.Lock()
+		applied, changed := f.applied, f.changed
+		f.mu.Unlock()
+		if applied >= version {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setApplied records the last applied leader version
+func (f *Follower) setApplied(version uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.applied = version
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// Follow connects to the leader at addr and keeps replicating, reconnecting after a delay whenever the connection drops, until the context is done
+func (f *Follower) Follow(ctx context.Context, addr string, retry time.Duration) error {
+	for {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			err = f.Sync(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Sync runs a single replication session over conn until it breaks or the context is done. The leader sends only the entries missing since the last applied version, or a snapshot when they are no longer in its log.
+func (f *Follower) Sync(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+	if err := enc.Encode(replMessage{Kind: msgHello, Version: f.Applied()}); err != nil {
+		return err
+	}
+
+	var snapshot []Document
+	for {
+		var msg replMessage
+		if err := dec.Decode(&msg); err != nil {
+			return err
+		}
+
+		switch msg.Kind {
+		case msgSnapshotDoc:
+			snapshot = append(snapshot, msg.Doc)
+		case msgSnapshotEnd:
+			if err := f.db.commit(f.db.replaceOps(snapshot)); err != nil {
+				return fmt.Errorf("follower: apply snapshot at version %d: %v", msg.Version, err)
+			}
+			snapshot = nil
+			f.setApplied(msg.Version)
+		case msgEntry:
+			if err := f.db.commit(msg.Entry.Ops); err != nil {
+				return fmt.Errorf("follower: apply entry at version %d: %v", msg.Version, err)
+			}
+			f.setApplied(msg.Version)
+		default:
+			return fmt.Errorf("follower: unexpected message kind %d", msg.Kind)
+		}
+	}
+}
+
+// replaceOps returns the operations that turn the current contents of the db into exactly docs. Documents that are unchanged are left alone. Every delete comes before the indexes, so a key that moved to another doc ID is free again when its new document is indexed.
+func (d *DB) replaceOps(docs []Document) []Op {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var deletes, indexes []Op
+	keep := make(map[int]struct{}, len(docs))
+	for _, doc := range docs {
+		keep[doc.ID] = struct{}{}
+		if v, exists := d.latest(doc.ID); exists {
+			if v.doc == doc {
+				continue
+			}
+			deletes = append(deletes, Op{Type: OpDelete, ID: doc.ID})
+		}
+		indexes = append(indexes, Op{Type: OpIndex, Doc: doc})
+	}
+	for id := range d.data {
+		if _, exists := keep[id]; exists {
+			continue
+		}
+		if _, exists := d.latest(id); exists {
+			deletes = append(deletes, Op{Type: OpDelete, ID: id})
+		}
+	}
+	return append(deletes, indexes...)
+}
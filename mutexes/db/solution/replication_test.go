@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startLeader serves db on a local listener and returns its address
+func startLeader(t *testing.T, db *DB) (*Leader, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader, err := NewLeader(db, ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve()
+	return leader, ln.Addr().String()
+}
+
+// waitForFollower blocks until the follower applied the current leader version
+func waitForFollower(t *testing.T, f *Follower, leader *DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := leader.Snapshot()
+	defer s.Release()
+	if err := f.WaitFor(ctx, s.Version()); err != nil {
+		t.Fatalf("Follower did not catch up to version %d, applied %d, %v", s.Version(), f.Applied(), err)
+	}
+}
+
+// compareDBs checks that both dbs hold the same documents
+func compareDBs(t *testing.T, expected, actual *DB) {
+	es, as := expected.Snapshot(), actual.Snapshot()
+	defer es.Release()
+	defer as.Release()
+
+	expectedIDs, actualIDs := es.ids(), as.ids()
+	if len(expectedIDs) != len(actualIDs) {
+		t.Fatalf("Expected %d documents, but got %d", len(expectedIDs), len(actualIDs))
+	}
+	for i, id := range expectedIDs {
+		e, _ := es.Get(id)
+		a, err := as.Get(actualIDs[i])
+		if err != nil || e != a {
+			t.Errorf("Expected document %v, but got %v, %v", e, a, err)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leaderDB := NewDB(WithWriteLog(10))
+	for i := 0; i < 5; i++ {
+		leaderDB.Index(Document{ID: i, Text: fmt.Sprintf("line %d of the book", i)})
+	}
+	leader, addr := startLeader(t, leaderDB)
+	defer leader.Close()
+
+	follower := NewFollower(NewDB())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- follower.Follow(ctx, addr, 10*time.Millisecond) }()
+
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+
+	// live writes are streamed in order
+	leaderDB.Delete(2)
+	leaderDB.Index(Document{ID: 2, Text: "line two rewritten"})
+	leaderDB.Index(Document{ID: 5, Text: "the last line"})
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+
+	res, err := follower.DB().Query("rewritten")
+	if err != nil || len(res) != 1 || res[0].ID != 2 {
+		t.Errorf("Expected the follower to serve the rewritten line, but got %v, %v", res, err)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected the follower to stop with %v, but got %v", context.Canceled, err)
+	}
+
+	// a short gap is caught up from the log tail
+	leaderDB.Delete(0)
+	leaderDB.Index(Document{ID: 6, Text: "written while the follower was away"})
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- follower.Follow(ctx, addr, 10*time.Millisecond) }()
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+	cancel()
+	<-done
+
+	// a gap longer than the log is caught up from a snapshot
+	for i := 10; i < 50; i++ {
+		leaderDB.Index(Document{ID: i, Text: fmt.Sprintf("line %d", i)})
+	}
+	leaderDB.Delete(1)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- follower.Follow(ctx, addr, 10*time.Millisecond) }()
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+	cancel()
+	<-done
+}
+
+func TestReplicationReconnect(t *testing.T) {
+	leaderDB := NewDB(WithWriteLog(100))
+	leader, addr := startLeader(t, leaderDB)
+
+	follower := NewFollower(NewDB())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go follower.Follow(ctx, addr, 10*time.Millisecond)
+
+	leaderDB.Index(Document{ID: 0, Text: "before the restart"})
+	waitForFollower(t, follower, leaderDB)
+	leader.Close()
+
+	// the follower keeps retrying until a leader listens on the address again
+	leaderDB.Index(Document{ID: 1, Text: "while the leader was down"})
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("Could not listen on %s again, %v", addr, err)
+	}
+	leader, err = NewLeader(leaderDB, ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve()
+	defer leader.Close()
+
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+
+	if _, err := NewLeader(NewDB(), ln); err == nil {
+		t.Error("Should have returned an error for a db without a write log")
+	}
+}
+
+func TestReplicationExpire(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	var clock atomic.Int64
+	clock.Store(start.UnixNano())
+	now := func() time.Time { return time.Unix(0, clock.Load()) }
+
+	leaderDB := NewDB(WithWriteLog(1), WithClock(now))
+	leaderDB.Index(Document{ID: 1, Text: "short lived", Expires: start.Add(time.Second)})
+	leaderDB.Index(Document{ID: 2, Text: "long lived"})
+	leaderDB.Index(Document{ID: 3, Text: "long lived"})
+	clock.Store(start.Add(time.Minute).UnixNano())
+
+	leader, addr := startLeader(t, leaderDB)
+	defer leader.Close()
+
+	// the follower catches up from a snapshot taken while doc ID 1 is expired but not removed
+	follower := NewFollower(NewDB(WithClock(now)))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- follower.Follow(ctx, addr, 10*time.Millisecond) }()
+	waitForFollower(t, follower, leaderDB)
+
+	if n := leaderDB.Expire(); n != 1 {
+		t.Errorf("Expected 1 expired document, but got %d", n)
+	}
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+	follower.DB().mu.RLock()
+	if _, exists := follower.DB().latest(1); exists {
+		t.Error("Expected the follower to apply the delete of the expired document")
+	}
+	follower.DB().mu.RUnlock()
+	cancel()
+	<-done
+}
+
+func TestReplicationSnapshotKeyMove(t *testing.T) {
+	leaderDB := NewDB(WithWriteLog(1))
+	leaderDB.Index(Document{ID: 1, Key: "k", Text: "first holder"})
+	leader, addr := startLeader(t, leaderDB)
+	defer leader.Close()
+
+	follower := NewFollower(NewDB())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- follower.Follow(ctx, addr, 10*time.Millisecond) }()
+	waitForFollower(t, follower, leaderDB)
+	cancel()
+	<-done
+
+	// the key moves to a new doc ID while the follower is away and the log no longer holds the move
+	leaderDB.Delete(1)
+	leaderDB.Index(Document{ID: 2, Key: "k", Text: "second holder"})
+	leaderDB.Index(Document{ID: 3, Text: "truncates the log"})
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- follower.Follow(ctx, addr, 10*time.Millisecond) }()
+	waitForFollower(t, follower, leaderDB)
+	compareDBs(t, leaderDB, follower.DB())
+	if doc, err := follower.DB().GetByKey("k"); err != nil || doc.ID != 2 {
+		t.Errorf("Expected the key on doc ID 2, but got %v, %v", doc, err)
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// migrateChunk is the number of documents a migration moves between two chances for queries and writes to run
+const migrateChunk = 256
+
+// ringPoint is a virtual node of a shard on the ring
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+// Ring is a consistent hash ring mapping doc IDs to shard names. Every shard is placed on the ring at replicas points and owns the IDs hashing between the point before and each of its points, so adding or removing a shard only moves the IDs of that shard. A Ring is immutable, Add and Remove return a new ring.
+type Ring struct {
+	replicas int
+	points   []ringPoint
+}
+
+// NewRing creates a ring of the shards with replicas virtual nodes per shard. More replicas spread the IDs more evenly, 100 is a good default.
+func NewRing(replicas int, shards ...string) *Ring {
+	r := &Ring{replicas: max(replicas, 1)}
+	for _, shard := range shards {
+		r = r.Add(shard)
+	}
+	return r
+}
+
+// ringHash hashes a virtual node or doc ID key to its place on the ring
+func ringHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix64(h.Sum64())
+}
+
+// Add returns a ring with the shard added. The ring itself is returned if it already holds the shard.
+func (r *Ring) Add(shard string) *Ring {
+	if r.Has(shard) {
+		return r
+	}
+	points := append([]ringPoint(nil), r.points...)
+	for i := 0; i < r.replicas; i++ {
+		points = append(points, ringPoint{hash: ringHash(shard + "#" + strconv.Itoa(i)), shard: shard})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].shard < points[j].shard
+	})
+	return &Ring{replicas: r.replicas, points: points}
+}
+
+// Remove returns a ring without the shard
+func (r *Ring) Remove(shard string) *Ring {
+	res := &Ring{replicas: r.replicas}
+	for _, p := range r.points {
+		if p.shard != shard {
+			res.points = append(res.points, p)
+		}
+	}
+	return res
+}
+
+// Has reports whether the shard is on the ring
+func (r *Ring) Has(shard string) bool {
+	for _, p := range r.points {
+		if p.shard == shard {
+			return true
+		}
+	}
+	return false
+}
+
+// Shards returns the names of the shards on the ring in sorted order
+func (r *Ring) Shards() []string {
+	seen := make(map[string]struct{})
+	var shards []string
+	for _, p := range r.points {
+		if _, exists := seen[p.shard]; !exists {
+			seen[p.shard] = struct{}{}
+			shards = append(shards, p.shard)
+		}
+	}
+	sort.Strings(shards)
+	return shards
+}
+
+// Owner returns the shard owning the doc ID, or an empty string for an empty ring
+func (r *Ring) Owner(id int) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(strconv.Itoa(id))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
+
+// ShardedDB spreads documents over several DBs by doc ID with a consistent hash ring. Shards can be added and removed at runtime: the documents whose owner changed are then migrated in the background, while reads and writes keep seeing every document exactly once.
+type ShardedDB struct {
+	// mu is held for reading by every read and write and for writing while the ring changes and while a chunk of documents is moved
+	mu     sync.RWMutex
+	shards map[string]*DB
+	ring   *Ring
+
+	// prev is the ring before the last change while its migration runs or after it failed, documents may still be placed by it. done is closed and err set once the migration finished.
+	prev *Ring
+	done chan struct{}
+	err  error
+
+	// remove deletes a migrated document from its old shard, (*DB).Delete unless a test makes it fail
+	remove func(db *DB, id int) error
+}
+
+// NewShardedDB creates a sharded db over the named DBs with replicas virtual nodes per shard on the ring. The DBs must be empty or hold only documents they own on the ring.
+func NewShardedDB(replicas int, shards map[string]*DB) *ShardedDB {
+	s := &ShardedDB{shards: make(map[string]*DB), ring: NewRing(replicas), done: make(chan struct{}), remove: (*DB).Delete}
+	for name, db := range shards {
+		s.shards[name] = db
+		s.ring = s.ring.Add(name)
+	}
+	close(s.done)
+	return s
+}
+
+// owners returns the shard owning the doc ID and, while a migration runs, the shard that owned it before when it differs. Callers must hold the lock.
+func (s *ShardedDB) owners(id int) (*DB, *DB, error) {
+	owner := s.ring.Owner(id)
+	if owner == "" {
+		return nil, nil, fmt.Errorf("sharded: no shards")
+	}
+	if s.prev != nil {
+		if old := s.prev.Owner(id); old != owner && old != "" {
+			return s.shards[owner], s.shards[old], nil
+		}
+	}
+	return s.shards[owner], nil, nil
+}
+
+// Index indexes the document into the shard owning its doc ID. An error is returned if a document with the same ID is already present, including on a shard it has not been migrated away from yet.
+func (s *ShardedDB) Index(doc Document) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db, old, err := s.owners(doc.ID)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		if _, err := old.Get(doc.ID); err == nil {
+			return fmt.Errorf("Document id %d already present in db", doc.ID)
+		}
+	}
+	return db.Index(doc)
+}
+
+// Delete removes the document with the specified doc ID from the shard holding it. An error is returned if the document is not present
+func (s *ShardedDB) Delete(id int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db, old, err := s.owners(id)
+	if err != nil {
+		return err
+	}
+	if err := db.Delete(id); err == nil || old == nil {
+		return err
+	}
+	return old.Delete(id)
+}
+
+// Get retrieves the document with the specified doc ID from the shard holding it. An error is returned if the document is not present
+func (s *ShardedDB) Get(id int) (Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	db, old, err := s.owners(id)
+	if err != nil {
+		return Document{}, err
+	}
+	doc, err := db.Get(id)
+	if err == nil || old == nil {
+		return doc, err
+	}
+	return old.Get(id)
+}
+
+// Query runs the query string on every shard and returns the matching documents sorted by doc ID. While a migration runs or after it failed, a document found on both its old and new shard is returned once, from its new shard.
+func (s *ShardedDB) Query(term string) ([]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := []Document{}
+	for name, db := range s.shards {
+		docs, err := db.Query(term)
+		if err != nil {
+			return res, err
+		}
+		for _, doc := range docs {
+			if owner := s.ring.Owner(doc.ID); s.prev != nil && owner != name {
+				if _, err := s.shards[owner].Get(doc.ID); err == nil {
+					continue
+				}
+			}
+			res = append(res, doc)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+// NumDocs returns the number of documents over all shards
+func (s *ShardedDB) NumDocs() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var n int
+	for _, db := range s.shards {
+		snap := db.Snapshot()
+		n += snap.numDocs()
+		snap.Release()
+	}
+	return n
+}
+
+// Shards returns the names of the shards on the ring in sorted order. A removed shard is left out even while its documents are still being migrated.
+func (s *ShardedDB) Shards() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.Shards()
+}
+
+// AddShard puts the db on the ring under name and starts migrating the documents it now owns from the other shards. The db should be empty. A migration that is still running is waited for first.
+func (s *ShardedDB) AddShard(name string, db *DB) error {
+	return s.rebalance(func() (*Ring, error) {
+		if _, exists := s.shards[name]; exists {
+			return nil, fmt.Errorf("sharded: shard %s already present", name)
+		}
+		s.shards[name] = db
+		return s.ring.Add(name), nil
+	})
+}
+
+// RemoveShard takes the shard off the ring and starts migrating its documents to the remaining shards. The shard is dropped once the migration succeeded. A migration that is still running is waited for first.
+func (s *ShardedDB) RemoveShard(name string) error {
+	return s.rebalance(func() (*Ring, error) {
+		if !s.ring.Has(name) {
+			return nil, fmt.Errorf("sharded: shard %s not present", name)
+		}
+		if len(s.shards) == 1 {
+			return nil, fmt.Errorf("sharded: cannot remove the last shard %s", name)
+		}
+		return s.ring.Remove(name), nil
+	})
+}
+
+// rebalance waits for the running migration, switches to the ring returned by change and migrates to it in the background. An error is returned if the last migration failed and was not retried.
+func (s *ShardedDB) rebalance(change func() (*Ring, error)) error {
+	for {
+		s.mu.Lock()
+		if s.prev == nil {
+			break
+		}
+		if err := s.err; err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("sharded: retry the failed migration first, %v", err)
+		}
+		done := s.done
+		s.mu.Unlock()
+		<-done
+	}
+	defer s.mu.Unlock()
+
+	next, err := change()
+	if err != nil {
+		return err
+	}
+	s.prev, s.ring = s.ring, next
+	s.done = make(chan struct{})
+	s.err = nil
+	go s.migrate(s.prev, next, s.done)
+	return nil
+}
+
+// Wait blocks until the running migration, if any, finished and returns its error
+func (s *ShardedDB) Wait() error {
+	s.mu.RLock()
+	done := s.done
+	s.mu.RUnlock()
+	<-done
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+// Retry restarts a failed migration to move the documents it left behind and waits for it. It returns nil when no migration failed.
+func (s *ShardedDB) Retry() error {
+	s.mu.Lock()
+	if s.prev != nil && s.err != nil {
+		s.done = make(chan struct{})
+		s.err = nil
+		go s.migrate(s.prev, s.ring, s.done)
+	}
+	s.mu.Unlock()
+	return s.Wait()
+}
+
+// migrate moves every document whose owner differs between prev and next, a chunk at a time. A document is indexed on its new shard before it is deleted from the old one, and no read runs in between. Documents that fail to move stay where they are and the first error is kept for Wait, prev then keeps routing reads and writes to them until Retry moved them.
+func (s *ShardedDB) migrate(prev, next *Ring, done chan struct{}) {
+	var err error
+	fail := func(id int, name string, moveErr error) {
+		if err == nil {
+			err = fmt.Errorf("sharded: migrate id %d from %s: %v", id, name, moveErr)
+		}
+	}
+	for _, name := range prev.Shards() {
+		s.mu.RLock()
+		src := s.shards[name]
+		s.mu.RUnlock()
+
+		snap := src.Snapshot()
+		ids := snap.ids()
+		snap.Release()
+
+		var moving []int
+		for _, id := range ids {
+			if next.Owner(id) != name {
+				moving = append(moving, id)
+			}
+		}
+
+		for len(moving) > 0 {
+			chunk := moving[:min(migrateChunk, len(moving))]
+			moving = moving[len(chunk):]
+
+			s.mu.Lock()
+			for _, id := range chunk {
+				doc, getErr := src.Get(id)
+				if getErr != nil {
+					// deleted since the migration started
+					continue
+				}
+				dst := s.shards[next.Owner(id)]
+				if moveErr := dst.Index(doc); moveErr != nil {
+					// an earlier attempt may have indexed it and failed to delete it
+					if moved, getErr := dst.Get(id); getErr != nil || moved != doc {
+						fail(id, name, moveErr)
+						continue
+					}
+				}
+				if delErr := s.remove(src, id); delErr != nil {
+					// undo the copy so the document stays on a single shard, the old one still serves it through prev
+					if undoErr := dst.Delete(id); undoErr != nil {
+						fail(id, next.Owner(id), undoErr)
+					}
+					fail(id, name, delErr)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	close(done)
+	if err != nil {
+		return
+	}
+	for name := range s.shards {
+		if !next.Has(name) {
+			delete(s.shards, name)
+		}
+	}
+	s.prev = nil
+}
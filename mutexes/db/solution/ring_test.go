@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	const numIDs = 10000
+	r := NewRing(100, "a", "b", "c")
+
+	counts := make(map[string]int)
+	for id := 0; id < numIDs; id++ {
+		counts[r.Owner(id)]++
+	}
+	for _, shard := range []string{"a", "b", "c"} {
+		if counts[shard] < numIDs/5 {
+			t.Errorf("Expected shard %s to own about a third of the IDs, but got %v", shard, counts)
+		}
+	}
+
+	testData := []struct {
+		name  string
+		next  *Ring
+		moved func(from, to string) bool
+	}{
+		{"add", r.Add("d"), func(from, to string) bool { return to == "d" }},
+		{"remove", r.Remove("a"), func(from, to string) bool { return from == "a" }},
+	}
+	for _, d := range testData {
+		var moved int
+		for id := 0; id < numIDs; id++ {
+			from, to := r.Owner(id), d.next.Owner(id)
+			if from == to {
+				continue
+			}
+			moved++
+			if !d.moved(from, to) {
+				t.Errorf("Expected ID %d to stay on %s after %s, but it moved to %s", id, from, d.name, to)
+			}
+		}
+		if moved < numIDs/6 || moved > numIDs/2 {
+			t.Errorf("Expected about a quarter to a third of the IDs to move after %s, but got %d", d.name, moved)
+		}
+	}
+
+	if r.Add("a") != r || len(r.Shards()) != 3 || NewRing(10).Owner(1) != "" {
+		t.Error("Expected adding an existing shard to keep the ring")
+	}
+}
+
+func TestShardedDB(t *testing.T) {
+	shards, err := splitTextFile("../alice-in-wonderland.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewShardedDB(50, map[string]*DB{"a": NewDB(), "b": NewDB()})
+	for i, doc := range shards[0] {
+		doc.ID = i
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", i, err)
+		}
+	}
+	expected, _ := db.Query("alice")
+
+	// readers and writers carry on while documents move between shards
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	var reads, written atomic.Int64
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			if res, _ := db.Query("alice"); len(res) != len(expected) {
+				t.Errorf("Expected %d documents during the migration, but got %d", len(expected), len(res))
+				return
+			}
+			id := int(reads.Add(1)) % len(shards[0])
+			if _, err := db.Get(id); err != nil {
+				t.Errorf("Failed to get doc ID %d during the migration, %v", id, err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; !stop.Load(); i++ {
+			id := 100000 + i
+			if err := db.Index(Document{ID: id, Text: fmt.Sprintf("written during the migration %d", i)}); err != nil {
+				t.Errorf("Failed to index doc ID %d during the migration, %v", id, err)
+				return
+			}
+			written.Add(1)
+			if err := db.Index(Document{ID: i % len(shards[0]), Text: "a duplicate"}); err == nil {
+				t.Errorf("Should have returned an error for duplicate doc ID %d", i%len(shards[0]))
+				return
+			}
+		}
+	}()
+
+	if err := db.AddShard("c", NewDB()); err != nil {
+		t.Fatalf("Failed to add shard, %v", err)
+	}
+	if err := db.RemoveShard("a"); err != nil {
+		t.Fatalf("Failed to remove shard, %v", err)
+	}
+	if err := db.Wait(); err != nil {
+		t.Fatalf("Failed to migrate, %v", err)
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	if shards := db.Shards(); len(shards) != 2 || shards[0] != "b" || shards[1] != "c" {
+		t.Errorf("Expected shards [b c], but got %v", shards)
+	}
+	if n := db.NumDocs(); n != len(shards[0])+int(written.Load()) {
+		t.Errorf("Expected %d documents, but got %d", len(shards[0])+int(written.Load()), n)
+	}
+	for name, shard := range db.shards {
+		s := shard.Snapshot()
+		for _, id := range s.ids() {
+			if owner := db.ring.Owner(id); owner != name {
+				t.Errorf("Expected doc ID %d on shard %s, but found it on %s", id, owner, name)
+			}
+		}
+		s.Release()
+	}
+	if res, _ := db.Query("alice"); len(res) != len(expected) {
+		t.Errorf("Expected %d documents, but got %d", len(expected), len(res))
+	}
+
+	errs := []error{
+		db.AddShard("b", NewDB()),
+		db.RemoveShard("a"),
+	}
+	db.RemoveShard("b")
+	db.Wait()
+	errs = append(errs, db.RemoveShard("c"))
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("Should have returned an error for change %d", i)
+		}
+	}
+}
+
+func TestShardedDBMigrateFailure(t *testing.T) {
+	a, b := NewDB(), NewDB()
+	db := NewShardedDB(50, map[string]*DB{"a": a, "b": b})
+	ids := map[string]int{}
+	for id := 0; len(ids) < 2; id++ {
+		if _, exists := ids[db.ring.Owner(id)]; !exists {
+			ids[db.ring.Owner(id)] = id
+		}
+	}
+
+	// the key is held on b, so the document with the same key on a cannot move there
+	db.Index(Document{ID: ids["a"], Key: "k", Text: "stuck"})
+	db.Index(Document{ID: ids["b"], Key: "k", Text: "blocking"})
+	if err := db.RemoveShard("a"); err != nil {
+		t.Fatalf("Failed to remove shard, %v", err)
+	}
+	if err := db.Wait(); err == nil {
+		t.Fatal("Should have returned an error for a document that failed to move")
+	}
+
+	stuck := ids["a"]
+	if doc, err := db.Get(stuck); err != nil || doc.Text != "stuck" {
+		t.Errorf("Expected the document left on the removed shard, but got %v, %v", doc, err)
+	}
+	if err := db.Index(Document{ID: stuck, Text: "a duplicate"}); err == nil {
+		t.Errorf("Should have returned an error for duplicate doc ID %d", stuck)
+	}
+	if res, _ := db.Query("stuck"); len(res) != 1 {
+		t.Errorf("Expected the document once, but got %v", res)
+	}
+	if err := db.AddShard("c", NewDB()); err == nil {
+		t.Error("Should have returned an error changing the ring before retrying")
+	}
+
+	if err := b.Delete(ids["b"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Retry(); err != nil {
+		t.Fatalf("Failed to retry the migration, %v", err)
+	}
+	if _, err := a.Get(stuck); err == nil {
+		t.Error("Expected the document to be deleted from the removed shard")
+	}
+	if _, exists := db.shards["a"]; exists {
+		t.Error("Expected the removed shard to be dropped once its documents moved")
+	}
+	if doc, err := b.Get(stuck); err != nil || doc.Text != "stuck" {
+		t.Errorf("Expected the document on shard b, but got %v, %v", doc, err)
+	}
+	if err := db.AddShard("c", NewDB()); err != nil {
+		t.Errorf("Failed to add shard after the retry, %v", err)
+	}
+	db.Wait()
+}
+
+func TestShardedDBMigrateDeleteFailure(t *testing.T) {
+	a, b := NewDB(), NewDB()
+	db := NewShardedDB(50, map[string]*DB{"a": a, "b": b})
+	var moving []int
+	for id := 0; len(moving) < 2; id++ {
+		if db.ring.Owner(id) == "a" {
+			moving = append(moving, id)
+			db.Index(Document{ID: id, Text: "moving"})
+		}
+	}
+
+	// the delete from the old shard fails for the first document
+	db.remove = func(src *DB, id int) error {
+		if id == moving[0] {
+			return fmt.Errorf("delete failed")
+		}
+		return src.Delete(id)
+	}
+	if err := db.RemoveShard("a"); err != nil {
+		t.Fatalf("Failed to remove shard, %v", err)
+	}
+	if err := db.Wait(); err == nil {
+		t.Fatal("Should have returned an error for a document that failed to move")
+	}
+	if _, err := b.Get(moving[0]); err == nil {
+		t.Error("Expected the copy on the new shard to be undone")
+	}
+	if res, _ := db.Query("moving"); len(res) != 2 || res[0].ID == res[1].ID {
+		t.Errorf("Expected each document once, but got %v", res)
+	}
+
+	// a copy left on both shards is still returned once
+	doc, _ := a.Get(moving[0])
+	b.Index(doc)
+	if res, _ := db.Query("moving"); len(res) != 2 || res[0].ID == res[1].ID {
+		t.Errorf("Expected each document once, but got %v", res)
+	}
+
+	db.remove = (*DB).Delete
+	if err := db.Retry(); err != nil {
+		t.Fatalf("Failed to retry the migration, %v", err)
+	}
+	if res, _ := db.Query("moving"); len(res) != 2 {
+		t.Errorf("Expected both documents after the retry, but got %v", res)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+)
+
+// checkInterval is the number of postings or documents processed between two checks of a context
+const checkInterval = 256
+
+// SearchResult holds the documents found by Search. Partial is set when the search was cut short because its context was done, Docs then holds the documents found up to that point.
+type SearchResult struct {
+	Docs    []Document
+	Partial bool
+}
+
+// Search runs the query string like Query but gives up as soon as the context is cancelled or its deadline passes. In that case the partial result is returned together with ctx.Err().
+func (d *DB) Search(ctx context.Context, term string) (SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return SearchResult{Partial: true}, err
+	}
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Search(ctx, term)
+}
+
+// Search runs a cancellable query against the snapshot, see DB.Search
+func (s *Snapshot) Search(ctx context.Context, term string) (SearchResult, error) {
+	var res SearchResult
+	uniqDocIds := make(map[int]struct{})
+
+	tokens := s.db.queryTokens(term)
+	for _, t := range tokens {
+		docs, err := s.docs(ctx, t)
+		for _, v := range docs {
+			if _, idExists := uniqDocIds[v.ID]; idExists {
+				continue
+			}
+			uniqDocIds[v.ID] = struct{}{}
+			res.Docs = append(res.Docs, v)
+		}
+		if err != nil {
+			res.Partial = true
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// IndexAll indexes the documents in order, checking the context before each one. It returns the number of documents indexed, which is less than len(docs) when indexing stopped early, and ctx.Err() if the context was done or the error of the document that failed to index.
+func (d *DB) IndexAll(ctx context.Context, docs []Document) (int, error) {
+	for i, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := d.Index(doc); err != nil {
+			return i, err
+		}
+	}
+	return len(docs), nil
+}
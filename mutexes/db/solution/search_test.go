@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countdownContext reports itself cancelled after its Err method was called n times
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestSearch(t *testing.T) {
+	db := NewDB()
+	numDocs := 4 * checkInterval
+	for i := 0; i < numDocs; i++ {
+		text := fmt.Sprintf("common line %d", i)
+		if i%2 == 0 {
+			text += " even"
+		}
+		if err := db.Index(Document{ID: i, Text: text}); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", i, err)
+		}
+	}
+
+	res, err := db.Search(context.Background(), "common")
+	if err != nil || res.Partial || len(res.Docs) != numDocs {
+		t.Errorf("Expected %d complete results, but got %d, partial %v, %v", numDocs, len(res.Docs), res.Partial, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err = db.Search(ctx, "common")
+	if err != context.Canceled || !res.Partial || len(res.Docs) != 0 {
+		t.Errorf("Expected an empty partial result with %v, but got %d results, partial %v, %v", context.Canceled, len(res.Docs), res.Partial, err)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err = db.Search(ctx, "common"); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, but got %v", context.DeadlineExceeded, err)
+	}
+
+	// the context runs out halfway through the posting list of the first term
+	s := db.Snapshot()
+	defer s.Release()
+	res, err = s.Search(&countdownContext{Context: context.Background(), n: 2}, "common even")
+	if err != context.Canceled || !res.Partial {
+		t.Errorf("Expected a partial result with %v, but got partial %v, %v", context.Canceled, res.Partial, err)
+	}
+	if len(res.Docs) != 2*checkInterval {
+		t.Errorf("Expected %d results before the cancellation, but got %d", 2*checkInterval, len(res.Docs))
+	}
+}
+
+func TestIndexAll(t *testing.T) {
+	docs := []Document{
+		{ID: 0, Text: "hello my name is blargh"},
+		{ID: 1, Text: "hi"},
+		{ID: 2, Text: "what is going    on?"},
+	}
+
+	db := NewDB()
+	if n, err := db.IndexAll(context.Background(), docs); n != len(docs) || err != nil {
+		t.Errorf("Expected %d documents to be indexed, but got %d, %v", len(docs), n, err)
+	}
+
+	if n, err := db.IndexAll(context.Background(), []Document{{ID: 3, Text: "new"}, docs[1]}); n != 1 || err == nil {
+		t.Errorf("Expected indexing to stop at the duplicate document, but got %d, %v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := NewDB().IndexAll(ctx, docs); n != 0 || err != context.Canceled {
+		t.Errorf("Expected no documents to be indexed with %v, but got %d, %v", context.Canceled, n, err)
+	}
+
+	db = NewDB()
+	if n, err := db.IndexAll(&countdownContext{Context: context.Background(), n: 2}, docs); n != 2 || err != context.Canceled {
+		t.Errorf("Expected 2 documents to be indexed with %v, but got %d, %v", context.Canceled, n, err)
+	}
+	if _, err := db.Get(2); err == nil {
+		t.Error("Should not have indexed the document after the cancellation")
+	}
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A segment is an immutable file holding the documents and inverted index of a snapshot. It is laid out so it can be memory mapped and queried in place:
+//
+//	header       segmentMagic
+//	doc data     per document: uvarint text length, text, uvarint source length, source, uvarint key length, key, uvarint expiry time in Unix nanoseconds or 0
+//	postings     per term: posting blocks of doc ordinals, see writePostings
+//	term data    per term in sorted order: uvarint term length, term, uvarint doc frequency, uvarint postings offset
+//	doc table    per document in ID order: int64 doc ID, uint64 doc data offset
+//	term table   per term in sorted order: uint64 term data offset
+//	footer       uint64 offsets of the five sections above and of the footer, uint64 number of documents and terms, uint32 CRC-32C of every section, uint32 CRC-32C of the footer so far, segmentMagic
+//
+// Offsets inside a section are relative to the start of the section and all integers are little endian. Doc ordinals are positions in the doc table.
+const segmentMagic = "GSDBSEG3"
+
+const (
+	// postingBlockSize is the largest number of doc ordinals in a posting block
+	postingBlockSize = 128
+	// numSections is the number of checksummed sections between the header and the footer
+	numSections = 5
+	// footerSize is the size of the footer in bytes
+	footerSize = (numSections+3)*8 + (numSections+1)*4 + len(segmentMagic)
+	// docEntrySize and termEntrySize are the sizes of doc table and term table entries in bytes
+	docEntrySize  = 16
+	termEntrySize = 8
+)
+
+// crcTable is the CRC-32C table used for segment checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteSegment writes the latest version of the db to a segment file at path. The file is written next to path first and renamed once complete, so path holds either the previous file or a complete segment.
+func (d *DB) WriteSegment(path string) error {
+	s := d.Snapshot()
+	defer s.Release()
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write segment: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := s.WriteSegmentTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("write segment: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("write segment: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write segment: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("write segment: %v", err)
+	}
+	return nil
+}
+
+// segmentWriter tracks the offset and checksum of the section being written
+type segmentWriter struct {
+	w   *bufio.Writer
+	off int64
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (sw *segmentWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = sw.w.Write(p)
+	sw.crc.Write(p)
+	sw.off += int64(len(p))
+}
+
+func (sw *segmentWriter) uvarint(v uint64) {
+	sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], v)])
+}
+
+func (sw *segmentWriter) uint64(v uint64) {
+	sw.write(binary.LittleEndian.AppendUint64(sw.buf[:0], v))
+}
+
+func (sw *segmentWriter) bytes(p []byte) {
+	sw.uvarint(uint64(len(p)))
+	sw.write(p)
+}
+
+// section ends the current section and returns its checksum
+func (sw *segmentWriter) section() uint32 {
+	sum := sw.crc.Sum32()
+	sw.crc.Reset()
+	return sum
+}
+
+// WriteSegmentTo writes the snapshot as a segment to w and returns the number of bytes written
+func (s *Snapshot) WriteSegmentTo(w io.Writer) (int64, error) {
+	sw := &segmentWriter{w: bufio.NewWriter(w), crc: crc32.New(crcTable)}
+	sw.write([]byte(segmentMagic))
+	sw.crc.Reset()
+
+	var offsets [numSections + 1]uint64
+	var sums [numSections]uint32
+
+	// doc data
+	offsets[0] = uint64(sw.off)
+	ids := s.ids()
+	ordinals := make(map[int]uint64, len(ids))
+	docOffsets := make([]uint64, len(ids))
+	for i, id := range ids {
+		doc, err := s.Get(id)
+		if err != nil {
+			return sw.off, err
+		}
+		ordinals[id] = uint64(i)
+		docOffsets[i] = uint64(sw.off) - offsets[0]
+		sw.bytes([]byte(doc.Text))
+		sw.bytes([]byte(doc.Source))
+		sw.bytes([]byte(doc.Key))
+		var expires uint64
+		if !doc.Expires.IsZero() {
+			expires = uint64(doc.Expires.UnixNano())
+		}
+		sw.uvarint(expires)
+	}
+	sums[0] = sw.section()
+
+	// postings
+	offsets[1] = uint64(sw.off)
+	terms := s.terms()
+	sort.Strings(terms)
+	type termEntry struct {
+		term     string
+		df       uint64
+		postings uint64
+	}
+	var entries []termEntry
+	for _, t := range terms {
+		var docs []uint64
+		for _, id := range s.postings(t) {
+			docs = append(docs, ordinals[id])
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		sort.Slice(docs, func(i, j int) bool { return docs[i] < docs[j] })
+		entries = append(entries, termEntry{term: t, df: uint64(len(docs)), postings: uint64(sw.off) - offsets[1]})
+		sw.writePostings(docs)
+	}
+	sums[1] = sw.section()
+
+	// term data
+	offsets[2] = uint64(sw.off)
+	termOffsets := make([]uint64, len(entries))
+	for i, e := range entries {
+		termOffsets[i] = uint64(sw.off) - offsets[2]
+		sw.bytes([]byte(e.term))
+		sw.uvarint(e.df)
+		sw.uvarint(e.postings)
+	}
+	sums[2] = sw.section()
+
+	// doc table
+	offsets[3] = uint64(sw.off)
+	for i, id := range ids {
+		sw.uint64(uint64(int64(id)))
+		sw.uint64(docOffsets[i])
+	}
+	sums[3] = sw.section()
+
+	// term table
+	offsets[4] = uint64(sw.off)
+	for _, off := range termOffsets {
+		sw.uint64(off)
+	}
+	sums[4] = sw.section()
+
+	// footer
+	offsets[5] = uint64(sw.off)
+	for _, off := range offsets {
+		sw.uint64(off)
+	}
+	sw.uint64(uint64(len(ids)))
+	sw.uint64(uint64(len(entries)))
+	for _, sum := range sums {
+		sw.write(binary.LittleEndian.AppendUint32(nil, sum))
+	}
+	sw.write(binary.LittleEndian.AppendUint32(nil, sw.section()))
+	sw.write([]byte(segmentMagic))
+
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.off, sw.err
+}
+
+// writePostings writes the sorted doc ordinals of a term as posting blocks. A block holds up to postingBlockSize ordinals: uvarint count, uvarint first ordinal, uvarint last ordinal, uvarint size of the deltas in bytes, followed by the uvarint deltas between consecutive ordinals. The header allows skipping a block without decoding it.
+func (sw *segmentWriter) writePostings(docs []uint64) {
+	var deltas []byte
+	for len(docs) > 0 {
+		block := docs[:min(len(docs), postingBlockSize)]
+		docs = docs[len(block):]
+
+		deltas = deltas[:0]
+		for i := 1; i < len(block); i++ {
+			deltas = binary.AppendUvarint(deltas, block[i]-block[i-1])
+		}
+		sw.uvarint(uint64(len(block)))
+		sw.uvarint(block[0])
+		sw.uvarint(block[len(block)-1])
+		sw.uvarint(uint64(len(deltas)))
+		sw.write(deltas)
+	}
+}
+
+// Segment is a read-only segment file opened with OpenSegment. Documents and posting lists are decoded from the mapped file on demand, so opening a segment costs the same regardless of its size. The checksum of a section is verified the first time it is read, Verify checks the whole file up front. A Segment is safe for concurrent use.
+type Segment struct {
+	// Analyzer tokenizes query strings, it must match the analyzer of the db the segment was written from. The default is a Unicode analyzer without accent folding.
+	Analyzer Analyzer
+	// Clock is the clock Get and Query check document expiry against. The default is time.Now.
+	Clock func() time.Time
+
+	mu       sync.RWMutex
+	data     []byte
+	unmap    func([]byte) error
+	sections [numSections + 1]uint64
+	numDocs  int
+	numTerms int
+
+	// checked verifies the checksum of each section once, checkErrs holds the outcome
+	checked   [numSections]sync.Once
+	checkErrs [numSections]error
+}
+
+// OpenSegment maps the segment file at path into memory and verifies its footer and section layout. The sections are not read until they are needed.
+func OpenSegment(path string) (*Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open segment: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("open segment: %v", err)
+	}
+	if info.Size() < int64(len(segmentMagic)+footerSize) {
+		return nil, fmt.Errorf("open segment: %s is too small to be a segment", path)
+	}
+	data, unmap, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("open segment: %v", err)
+	}
+
+	s := &Segment{Analyzer: NewUnicodeAnalyzer(false), Clock: time.Now, data: data, unmap: unmap}
+	if err := s.verify(); err != nil {
+		unmap(data)
+		return nil, fmt.Errorf("open segment: %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// verify checks the magic numbers, the footer checksum and the section layout
+func (s *Segment) verify() error {
+	if string(s.data[:len(segmentMagic)]) != segmentMagic {
+		return fmt.Errorf("not a segment file")
+	}
+	footer := s.data[len(s.data)-footerSize:]
+	if string(footer[footerSize-len(segmentMagic):]) != segmentMagic {
+		return fmt.Errorf("missing footer")
+	}
+	sumsAt := (numSections + 3) * 8
+	if crc32.Checksum(footer[:sumsAt+numSections*4], crcTable) != binary.LittleEndian.Uint32(footer[sumsAt+numSections*4:]) {
+		return fmt.Errorf("footer checksum mismatch")
+	}
+
+	prev := uint64(len(segmentMagic))
+	for i := range s.sections {
+		s.sections[i] = binary.LittleEndian.Uint64(footer[i*8:])
+		if i == 0 && s.sections[i] != prev || s.sections[i] < prev {
+			return fmt.Errorf("invalid section offsets")
+		}
+		prev = s.sections[i]
+	}
+	if s.sections[numSections] != uint64(len(s.data)-footerSize) {
+		return fmt.Errorf("invalid section offsets")
+	}
+	numDocs := binary.LittleEndian.Uint64(footer[(numSections+1)*8:])
+	numTerms := binary.LittleEndian.Uint64(footer[(numSections+2)*8:])
+	if s.sections[4]-s.sections[3] != numDocs*docEntrySize || s.sections[5]-s.sections[4] != numTerms*termEntrySize {
+		return fmt.Errorf("table sizes do not match the number of documents and terms")
+	}
+	s.numDocs, s.numTerms = int(numDocs), int(numTerms)
+	return nil
+}
+
+// check verifies the checksum of each of the sections the first time it is read. Callers must hold the read lock.
+func (s *Segment) check(sections ...int) error {
+	for _, i := range sections {
+		s.checked[i].Do(func() {
+			sum := binary.LittleEndian.Uint32(s.data[len(s.data)-footerSize+(numSections+3)*8+i*4:])
+			if crc32.Checksum(s.data[s.sections[i]:s.sections[i+1]], crcTable) != sum {
+				s.checkErrs[i] = fmt.Errorf("segment: checksum mismatch in section %d", i)
+			}
+		})
+		if err := s.checkErrs[i]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify checks the checksum of every section, reading the whole file
+func (s *Segment) Verify() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return fmt.Errorf("verify: segment is closed")
+	}
+	return s.check(0, 1, 2, 3, 4)
+}
+
+// Close unmaps the segment. Reads after Close return an error.
+func (s *Segment) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data == nil {
+		return nil
+	}
+	err := s.unmap(s.data)
+	s.data = nil
+	return err
+}
+
+// NumDocs returns the number of documents in the segment
+func (s *Segment) NumDocs() int {
+	return s.numDocs
+}
+
+// NumTerms returns the number of terms in the segment
+func (s *Segment) NumTerms() int {
+	return s.numTerms
+}
+
+// segmentReader decodes values from a section, remembering the first error
+type segmentReader struct {
+	b   []byte
+	off uint64
+	err error
+}
+
+func (r *segmentReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.off >= uint64(len(r.b)) {
+		r.err = fmt.Errorf("segment: offset %d out of range", r.off)
+		return 0
+	}
+	v, n := binary.Uvarint(r.b[r.off:])
+	if n <= 0 {
+		r.err = fmt.Errorf("segment: corrupt varint at offset %d", r.off)
+		return 0
+	}
+	r.off += uint64(n)
+	return v
+}
+
+func (r *segmentReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b))-r.off {
+		r.err = fmt.Errorf("segment: length %d at offset %d out of range", n, r.off)
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+// section returns a reader over section i starting at off. Callers must hold the read lock.
+func (s *Segment) section(i int, off uint64) *segmentReader {
+	return &segmentReader{b: s.data[s.sections[i]:s.sections[i+1]], off: off}
+}
+
+// docEntry returns the doc ID and doc data offset at the ordinal. Callers must hold the read lock.
+func (s *Segment) docEntry(ord int) (int, uint64) {
+	e := s.data[s.sections[3]+uint64(ord)*docEntrySize:]
+	return int(int64(binary.LittleEndian.Uint64(e))), binary.LittleEndian.Uint64(e[8:])
+}
+
+// doc decodes the document at the ordinal. Text, source and key are copied out of the mapping. Callers must hold the read lock.
+func (s *Segment) doc(ord int) (Document, error) {
+	id, off := s.docEntry(ord)
+	r := s.section(0, off)
+	text, source, key, expires := string(r.bytes()), string(r.bytes()), string(r.bytes()), r.uvarint()
+	if r.err != nil {
+		return Document{}, r.err
+	}
+	doc := Document{ID: id, Key: key, Text: text, Source: source}
+	if expires != 0 {
+		doc.Expires = time.Unix(0, int64(expires))
+	}
+	return doc, nil
+}
+
+// term decodes the term data entry at position i of the term table. Callers must hold the read lock.
+func (s *Segment) term(i int) (term []byte, df, postings uint64, err error) {
+	off := binary.LittleEndian.Uint64(s.data[s.sections[4]+uint64(i)*termEntrySize:])
+	r := s.section(2, off)
+	term, df, postings = r.bytes(), r.uvarint(), r.uvarint()
+	return term, df, postings, r.err
+}
+
+// findTerm binary searches the term dictionary. Callers must hold the read lock.
+func (s *Segment) findTerm(term string) (df, postings uint64, found bool, err error) {
+	i := sort.Search(s.numTerms, func(i int) bool {
+		t, _, _, terr := s.term(i)
+		if terr != nil {
+			err = terr
+			return true
+		}
+		return string(t) >= term
+	})
+	if err != nil || i == s.numTerms {
+		return 0, 0, false, err
+	}
+	t, df, postings, err := s.term(i)
+	if err != nil || string(t) != term {
+		return 0, 0, false, err
+	}
+	return df, postings, true, nil
+}
+
+// ordinals decodes the posting blocks of a term. Callers must hold the read lock.
+func (s *Segment) ordinals(df, postings uint64) ([]int, error) {
+	r := s.section(1, postings)
+	ords := make([]int, 0, min(df, uint64(s.numDocs)))
+	for uint64(len(ords)) < df && r.err == nil {
+		n, first, last, size := r.uvarint(), r.uvarint(), r.uvarint(), r.uvarint()
+		end := r.off + size
+		ord := first
+		ords = append(ords, int(ord))
+		for i := uint64(1); i < n && r.err == nil; i++ {
+			ord += r.uvarint()
+			ords = append(ords, int(ord))
+		}
+		if r.err == nil && (n == 0 || ord != last || r.off != end) {
+			r.err = fmt.Errorf("segment: corrupt posting block at offset %d", end-size)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, ord := range ords {
+		if ord >= s.numDocs {
+			return nil, fmt.Errorf("segment: doc ordinal %d out of range", ord)
+		}
+	}
+	return ords, nil
+}
+
+// Get retrieves the document with the specified doc ID. An error is returned if the document is not present or expired
+func (s *Segment) Get(id int) (Document, error) {
+	doc, exists, err := s.current(id)
+	if err != nil {
+		return Document{}, err
+	}
+	if !exists || doc.expiredAt(s.Clock()) {
+		return Document{}, fmt.Errorf("get: id %d not present", id)
+	}
+	return doc, nil
+}
+
+// current retrieves the document with the specified doc ID even when it expired
+func (s *Segment) current(id int) (Document, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return Document{}, false, fmt.Errorf("get: segment is closed")
+	}
+	if err := s.check(3, 0); err != nil {
+		return Document{}, false, err
+	}
+
+	if ord, exists := s.ordinal(id); exists {
+		doc, err := s.doc(ord)
+		return doc, err == nil, err
+	}
+	return Document{}, false, nil
+}
+
+// Has reports whether the segment holds the document with the specified doc ID, even when it expired. It reports false if the doc table is corrupt.
+func (s *Segment) Has(id int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil || s.check(3) != nil {
+		return false
+	}
+
+	_, exists := s.ordinal(id)
+	return exists
+}
+
+// ordinal binary searches the doc table for the doc ID. Callers must hold the read lock.
+func (s *Segment) ordinal(id int) (int, bool) {
+	ord := sort.Search(s.numDocs, func(i int) bool {
+		docID, _ := s.docEntry(i)
+		return docID >= id
+	})
+	if ord < s.numDocs {
+		if docID, _ := s.docEntry(ord); docID == id {
+			return ord, true
+		}
+	}
+	return 0, false
+}
+
+// Docs iterates over every document of the segment in doc ID order, including expired ones. Iteration stops after the first error.
+func (s *Segment) Docs() iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		for ord := 0; ord < s.numDocs; ord++ {
+			s.mu.RLock()
+			var doc Document
+			err := fmt.Errorf("docs: segment is closed")
+			if s.data != nil {
+				if err = s.check(3, 0); err == nil {
+					doc, err = s.doc(ord)
+				}
+			}
+			s.mu.RUnlock()
+
+			if !yield(doc, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// DocFreq returns the number of documents containing the term
+func (s *Segment) DocFreq(term string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return 0, fmt.Errorf("doc freq: segment is closed")
+	}
+	if err := s.check(4, 2); err != nil {
+		return 0, err
+	}
+
+	df, _, _, err := s.findTerm(term)
+	return int(df), err
+}
+
+// Query runs the query string through the segment analyzer and returns the unique unexpired documents containing any of its tokens in doc ID order, like DB.Query
+func (s *Segment) Query(term string) ([]Document, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return []Document{}, fmt.Errorf("query: segment is closed")
+	}
+	if err := s.check(0, 1, 2, 3, 4); err != nil {
+		return []Document{}, err
+	}
+
+	seen := make(map[int]struct{})
+	var ords []int
+	for _, t := range s.Analyzer.Analyze(term) {
+		df, postings, found, err := s.findTerm(t)
+		if err != nil {
+			return []Document{}, err
+		}
+		if !found {
+			continue
+		}
+		list, err := s.ordinals(df, postings)
+		if err != nil {
+			return []Document{}, err
+		}
+		for _, ord := range list {
+			if _, exists := seen[ord]; !exists {
+				seen[ord] = struct{}{}
+				ords = append(ords, ord)
+			}
+		}
+	}
+	sort.Ints(ords)
+
+	now := s.Clock()
+	res := []Document{}
+	for _, ord := range ords {
+		doc, err := s.doc(ord)
+		if err != nil {
+			return []Document{}, err
+		}
+		if !doc.expiredAt(now) {
+			res = append(res, doc)
+		}
+	}
+	return res, nil
+}
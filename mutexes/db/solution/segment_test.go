@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSegment(t *testing.T) {
+	db := NewDB()
+	for i := -5; i < 300; i++ {
+		text := fmt.Sprintf("line %d of the book", i)
+		if i%7 == 0 {
+			text += " with the white rabbit"
+		}
+		db.Index(Document{ID: i, Text: text})
+	}
+	db.Delete(14)
+	db.IndexJSON(1000, []byte(`{"title": "Alice", "year": 1865}`))
+	db.Add(Document{Key: "alice.txt:1", Text: "a keyed line"})
+
+	path := filepath.Join(t.TempDir(), "db.seg")
+	if err := db.WriteSegment(path); err != nil {
+		t.Fatalf("Failed to write segment, %v", err)
+	}
+	seg, err := OpenSegment(path)
+	if err != nil {
+		t.Fatalf("Failed to open segment, %v", err)
+	}
+	defer seg.Close()
+
+	if seg.NumDocs() != 306 {
+		t.Errorf("Expected 306 documents, but got %d", seg.NumDocs())
+	}
+
+	for _, query := range []string{"rabbit", "book", "white 42", "alice", "missing", "year:1865"} {
+		expected, _ := db.Query(query)
+		sort.Slice(expected, func(i, j int) bool { return expected[i].ID < expected[j].ID })
+		res, err := seg.Query(query)
+		if err != nil || len(res) != len(expected) {
+			t.Errorf("Expected %d results for %s, but got %d, %v", len(expected), query, len(res), err)
+			continue
+		}
+		for i := range res {
+			if res[i] != expected[i] {
+				t.Errorf("Expected %v for %s, but got %v", expected[i], query, res[i])
+			}
+		}
+	}
+
+	for _, id := range []int{-5, 0, 299, 1000, 1001} {
+		expected, _ := db.Get(id)
+		if doc, err := seg.Get(id); err != nil || doc != expected {
+			t.Errorf("Expected %v, but got %v, %v", expected, doc, err)
+		}
+	}
+	if _, err := seg.Get(14); err == nil {
+		t.Error("Should have returned an error for a deleted document")
+	}
+	if df, err := seg.DocFreq("rabbit"); err != nil || df != 42 {
+		t.Errorf("Expected rabbit in 42 documents, but got %d, %v", df, err)
+	}
+
+	seg.Close()
+	if _, err := seg.Query("rabbit"); err == nil {
+		t.Error("Should have returned an error querying a closed segment")
+	}
+}
+
+func TestSegmentCorrupt(t *testing.T) {
+	db := NewDB()
+	db.Index(Document{ID: 1, Text: "the queen of hearts"})
+	db.Index(Document{ID: 2, Text: "the knave of hearts"})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.seg")
+	if err := db.WriteSegment(path); err != nil {
+		t.Fatalf("Failed to write segment, %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flipping any single byte is caught by a magic number or checksum, when opening or once its section is read
+	for i := range data {
+		corrupt := append([]byte(nil), data...)
+		corrupt[i] ^= 0x40
+		p := filepath.Join(dir, "corrupt.seg")
+		if err := os.WriteFile(p, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		seg, err := OpenSegment(p)
+		if err != nil {
+			continue
+		}
+		if err := seg.Verify(); err == nil {
+			t.Errorf("Should have returned an error for a flipped byte at offset %d", i)
+		}
+		if _, err := seg.Query("hearts"); err == nil {
+			t.Errorf("Should have returned an error querying with a flipped byte at offset %d", i)
+		}
+		seg.Close()
+	}
+
+	// a flipped byte in the doc data is only noticed once a document is read
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(segmentMagic)] ^= 0x40
+	p := filepath.Join(dir, "lazy.seg")
+	os.WriteFile(p, corrupt, 0o644)
+	seg, err := OpenSegment(p)
+	if err != nil {
+		t.Fatalf("Expected the sections to be verified lazily, but got %v", err)
+	}
+	if df, err := seg.DocFreq("hearts"); err != nil || df != 2 {
+		t.Errorf("Expected hearts in 2 documents, but got %d, %v", df, err)
+	}
+	if _, err := seg.Get(1); err == nil {
+		t.Error("Should have returned an error reading a corrupt document")
+	}
+	seg.Close()
+
+	p = filepath.Join(dir, "short.seg")
+	os.WriteFile(p, data[:len(data)-1], 0o644)
+	if _, err := OpenSegment(p); err == nil {
+		t.Error("Should have returned an error for a truncated segment")
+	}
+
+	// an empty db is a valid segment
+	empty := filepath.Join(dir, "empty.seg")
+	if err := NewDB().WriteSegment(empty); err != nil {
+		t.Fatalf("Failed to write segment, %v", err)
+	}
+	seg, err = OpenSegment(empty)
+	if err != nil {
+		t.Fatalf("Failed to open empty segment, %v", err)
+	}
+	defer seg.Close()
+	if res, err := seg.Query("hearts"); err != nil || len(res) != 0 {
+		t.Errorf("Expected no results, but got %v, %v", res, err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// vacuumThreshold is the number of deleted document versions collected before a vacuum runs automatically
+const vacuumThreshold = 1024
+
+// posting is an entry of a posting list. It refers to the version of the document created at version created.
+type posting struct {
+	id      int
+	created uint64
+}
+
+// docVersion is one version of a stored document. deleted is zero while the version is live. vec is the hashed vector of the document when vectors are enabled.
+type docVersion struct {
+	doc     Document
+	fields  Fields
+	vec     *docVector
+	created uint64
+	deleted uint64
+}
+
+// visibleAt reports whether the version exists at the given db version
+func (v docVersion) visibleAt(version uint64) bool {
+	return v.created <= version && (v.deleted == 0 || v.deleted > version)
+}
+
+// Snapshot is a point-in-time view of the DB. Reads through a snapshot see every write committed before it was taken and none committed after, while writers carry on. Documents are expired as of the time the snapshot was taken. A snapshot must be released once it is no longer needed so old document versions can be reclaimed.
+type Snapshot struct {
+	db       *DB
+	version  uint64
+	now      time.Time
+	released bool
+}
+
+// Snapshot acquires a read view of the latest committed version
+func (d *DB) Snapshot() *Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.snapshots[d.version]++
+	return &Snapshot{db: d, version: d.version, now: d.now()}
+}
+
+// Version returns the db version seen by the snapshot
+func (s *Snapshot) Version() uint64 {
+	return s.version
+}
+
+// Release gives up the snapshot. Releasing a snapshot more than once has no effect.
+func (s *Snapshot) Release() {
+	d := s.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	if d.snapshots[s.version]--; d.snapshots[s.version] <= 0 {
+		delete(d.snapshots, s.version)
+	}
+	d.maybeVacuum()
+}
+
+// Query runs the query string against the snapshot, see DB.Query
+func (s *Snapshot) Query(term string) ([]Document, error) {
+	res, err := s.Search(context.Background(), term)
+	return res.Docs, err
+}
+
+// Get retrieves the document with the specified doc ID as of the snapshot. An error is returned if the document is not present
+func (s *Snapshot) Get(id int) (Document, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	if v, exists := s.lookup(id); exists {
+		return v.doc, nil
+	}
+	return Document{}, fmt.Errorf("get: id %d not present", id)
+}
+
+// lookup finds the version of the document visible to the snapshot. Expired documents are not visible. Callers must hold the read lock.
+func (s *Snapshot) lookup(id int) (docVersion, bool) {
+	v, exists := s.current(id)
+	if !exists || v.doc.expiredAt(s.now) {
+		return docVersion{}, false
+	}
+	return v, true
+}
+
+// current finds the version of the document live at the snapshot version, even when it expired. Callers must hold the read lock.
+func (s *Snapshot) current(id int) (docVersion, bool) {
+	versions := s.db.data[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].visibleAt(s.version) {
+			return versions[i], true
+		}
+	}
+	return docVersion{}, false
+}
+
+// holds reports whether a version of the document is live at the snapshot version, even when it expired. An expired version still shadows older generations of a SegmentedDB.
+func (s *Snapshot) holds(id int) bool {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	_, exists := s.current(id)
+	return exists
+}
+
+// expired returns the IDs of the documents that are live at the snapshot version but expired as of the snapshot
+func (s *Snapshot) expired() []int {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var ids []int
+	for id := range s.db.data {
+		if v, exists := s.current(id); exists && v.doc.expiredAt(s.now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// visible reports whether the posting belongs to the document version visible to the snapshot. Callers must hold the read lock.
+func (s *Snapshot) visible(p posting) bool {
+	if p.created > s.version {
+		return false
+	}
+	v, exists := s.lookup(p.id)
+	return exists && v.created == p.created
+}
+
+// postings returns the IDs of the documents containing the term in posting list order
+func (s *Snapshot) postings(term string) []int {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var ids []int
+	for _, p := range s.db.index[term] {
+		if s.visible(p) {
+			ids = append(ids, p.id)
+		}
+	}
+	return ids
+}
+
+// docs returns the documents containing the term in posting list order. The context is checked every checkInterval postings and the documents found so far are returned with its error once it is done.
+func (s *Snapshot) docs(ctx context.Context, term string) ([]Document, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var docs []Document
+	for i, p := range s.db.index[term] {
+		if i%checkInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return docs, err
+			}
+		}
+		if v, exists := s.lookup(p.id); exists && v.created == p.created {
+			docs = append(docs, v.doc)
+		}
+	}
+	return docs, nil
+}
+
+// docFreq returns the number of documents containing the term
+func (s *Snapshot) docFreq(term string) int {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var n int
+	for _, p := range s.db.index[term] {
+		if s.visible(p) {
+			n++
+		}
+	}
+	return n
+}
+
+// ids returns the IDs of all documents in the snapshot in ascending order
+func (s *Snapshot) ids() []int {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var ids []int
+	for id := range s.db.data {
+		if _, exists := s.lookup(id); exists {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// currentDocs returns the documents live at the snapshot version in doc ID order, including the expired ones that were not removed yet
+func (s *Snapshot) currentDocs() []Document {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var docs []Document
+	for id := range s.db.data {
+		if v, exists := s.current(id); exists {
+			docs = append(docs, v.doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	return docs
+}
+
+// numDocs returns the number of documents in the snapshot
+func (s *Snapshot) numDocs() int {
+	return len(s.ids())
+}
+
+// terms returns every term in the index. Terms whose documents are all invisible to the snapshot may be included.
+func (s *Snapshot) terms() []string {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	terms := make([]string, 0, len(s.db.index))
+	for t := range s.db.index {
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// Vacuum reclaims every deleted document version that no open snapshot can see and returns how many were reclaimed. Vacuum runs automatically once enough deleted versions pile up.
+func (d *DB) Vacuum() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.vacuum()
+}
+
+// maybeVacuum runs vacuum once enough garbage piled up since the last run. Callers must hold the write lock.
+func (d *DB) maybeVacuum() {
+	if len(d.garbage) >= max(d.nextVacuum, vacuumThreshold) {
+		d.vacuum()
+	}
+}
+
+// oldestSnapshot returns the lowest version any open snapshot may read. Callers must hold the lock.
+func (d *DB) oldestSnapshot() uint64 {
+	oldest := d.version
+	for v := range d.snapshots {
+		oldest = min(oldest, v)
+	}
+	return oldest
+}
+
+// vacuum drops deleted document versions and their postings once no snapshot can see them. Callers must hold the write lock.
+func (d *DB) vacuum() int {
+	oldest := d.oldestSnapshot()
+
+	var reclaimed int
+	var keep []posting
+	for _, g := range d.garbage {
+		versions := d.data[g.id]
+		i := findVersion(versions, g.created)
+		if i < 0 {
+			continue
+		}
+		if versions[i].deleted > oldest {
+			keep = append(keep, g)
+			continue
+		}
+
+		doc := versions[i].doc
+		for _, t := range d.documentTokens(doc, versions[i].fields) {
+			d.index[t] = removePosting(d.index[t], g)
+			if len(d.index[t]) == 0 {
+				delete(d.index, t)
+			}
+		}
+
+		if doc.Key != "" {
+			d.keys[doc.Key] = removePosting(d.keys[doc.Key], g)
+			if len(d.keys[doc.Key]) == 0 {
+				delete(d.keys, doc.Key)
+			}
+		}
+
+		if d.vectors != nil {
+			d.vectors.reclaim(versions[i].vec, g)
+		}
+
+		versions = append(versions[:i], versions[i+1:]...)
+		if len(versions) == 0 {
+			delete(d.data, g.id)
+		} else {
+			d.data[g.id] = versions
+		}
+		if d.grams != nil {
+			d.removeGrams(doc, versions)
+		}
+		reclaimed++
+	}
+	d.garbage = keep
+	d.nextVacuum = len(keep) + vacuumThreshold
+
+	if d.completions != nil {
+		d.completions.refresh()
+	}
+	return reclaimed
+}
+
+// findVersion returns the position of the version created at the given version or -1
+func findVersion(versions []docVersion, created uint64) int {
+	for i, v := range versions {
+		if v.created == created {
+			return i
+		}
+	}
+	return -1
+}
+
+// removePosting returns a copy of the posting list without p. Entries of a posting list are never modified in place, so a reader holding on to an older slice of the list can keep walking it without the lock.
+func removePosting(list []posting, p posting) []posting {
+	res := make([]posting, 0, len(list))
+	for _, q := range list {
+		if q != p {
+			res = append(res, q)
+		}
+	}
+	return res
+}
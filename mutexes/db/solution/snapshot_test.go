@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	db := NewDB(WithNGrams(3), WithCompletion(1))
+	docs := []Document{
+		{ID: 0, Text: "the queen of hearts"},
+		{ID: 1, Text: "the knave of hearts"},
+		{ID: 2, Text: "the king of hearts"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	s := db.Snapshot()
+	if err := db.Delete(1); err != nil {
+		t.Fatalf("Failed to delete doc ID 1, %v", err)
+	}
+	if err := db.Index(Document{ID: 1, Text: "the knave stole the tarts"}); err != nil {
+		t.Fatalf("Failed to reindex doc ID 1, %v", err)
+	}
+	if err := db.Index(Document{ID: 3, Text: "the hearts were painted"}); err != nil {
+		t.Fatalf("Failed to index doc ID 3, %v", err)
+	}
+
+	testData := []struct {
+		query    string
+		snapshot int
+		latest   int
+	}{
+		{"hearts", 3, 3},
+		{"tarts", 0, 1},
+		{"knave", 1, 1},
+		{"painted", 0, 1},
+	}
+
+	for _, d := range testData {
+		res, _ := s.Query(d.query)
+		if len(res) != d.snapshot {
+			t.Errorf("Expected %d snapshot results for %s, but got %d, res: %v", d.snapshot, d.query, len(res), res)
+		}
+		res, _ = db.Query(d.query)
+		if len(res) != d.latest {
+			t.Errorf("Expected %d latest results for %s, but got %d, res: %v", d.latest, d.query, len(res), res)
+		}
+	}
+
+	if doc, err := s.Get(1); err != nil || doc.Text != "the knave of hearts" {
+		t.Errorf("Expected the old version of doc ID 1 in the snapshot, but got %v, %v", doc, err)
+	}
+	if doc, err := db.Get(1); err != nil || doc.Text != "the knave stole the tarts" {
+		t.Errorf("Expected the new version of doc ID 1, but got %v, %v", doc, err)
+	}
+	if _, err := s.Get(3); err == nil {
+		t.Error("Should not find doc ID 3 in a snapshot taken before it was indexed")
+	}
+	if res, _ := s.QuerySubstring("knave of"); len(res) != 1 {
+		t.Errorf("Expected 1 substring result in the snapshot, but got %v", res)
+	}
+
+	// the old version is held by the snapshot until it is released
+	if n := db.Vacuum(); n != 0 {
+		t.Errorf("Expected nothing to be reclaimed while the snapshot is open, but got %d", n)
+	}
+	s.Release()
+	s.Release()
+	if n := db.Vacuum(); n != 1 {
+		t.Errorf("Expected 1 version to be reclaimed, but got %d", n)
+	}
+	if len(db.data[1]) != 1 {
+		t.Errorf("Expected a single version of doc ID 1, but got %v", db.data[1])
+	}
+	for _, p := range db.index["hearts"] {
+		if p.id == 1 {
+			t.Errorf("Expected the postings of the old version to be reclaimed, but found %v", p)
+		}
+	}
+	if res, _ := db.QuerySubstring("knave of"); len(res) != 0 {
+		t.Errorf("Expected no substring results after the update, but got %v", res)
+	}
+	if res := db.Complete("kn", 5); len(res) != 1 || res[0].Freq != 1 {
+		t.Errorf("Expected knave in a single document, but got %v", res)
+	}
+
+	if err := db.Delete(42); err == nil {
+		t.Error("Should have returned an error deleting a missing document")
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	db := NewDB()
+	numWriters, numDocs := 4, 200
+
+	var wg sync.WaitGroup
+	wg.Add(numWriters)
+	for w := 0; w < numWriters; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < numDocs; i++ {
+				id := w*numDocs + i
+				db.Index(Document{ID: id, Text: fmt.Sprintf("common doc%d", id)})
+				if i%3 == 0 {
+					db.Delete(id)
+				}
+			}
+		}(w)
+	}
+
+	// every document contains "common" so a consistent view always matches all of its documents
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			s := db.Snapshot()
+			res, _ := s.Query("common")
+			if n := s.numDocs(); len(res) != n {
+				t.Errorf("Expected %d documents at version %d, but got %d", n, s.Version(), len(res))
+			}
+			s.Release()
+		}
+	}()
+
+	wg.Wait()
+	<-done
+
+	res, _ := db.Query("common")
+	expected := numWriters * (numDocs - (numDocs+2)/3)
+	if len(res) != expected {
+		t.Errorf("Expected %d documents, but got %d", expected, len(res))
+	}
+}
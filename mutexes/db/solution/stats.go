@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultMinCollocationCount is the number of times an n-gram must occur to be scored as a collocation when StatsOptions leaves it out. PMI overrates rare n-grams, so n-grams seen once or twice are dropped.
+const defaultMinCollocationCount = 3
+
+// defaultGrowthStep is the number of tokens between two points of the vocabulary growth curve when StatsOptions leaves it out
+const defaultGrowthStep = 1000
+
+// ngramSep joins the words of an n-gram into a map key
+const ngramSep = "\x00"
+
+// StatsOptions configures Stats
+type StatsOptions struct {
+	// MinCount is the number of times an n-gram must occur to be returned as a collocation
+	MinCount int
+	// Size is the number of top words and collocations returned, every one when zero
+	Size int
+	// GrowthStep is the number of tokens between two points of the vocabulary growth curve
+	GrowthStep int
+}
+
+// WordStat holds the frequencies of a token. Count is the number of times it occurs and DocFreq the number of documents it occurs in.
+type WordStat struct {
+	Word    string
+	Count   int
+	DocFreq int
+}
+
+// Collocation is a run of adjacent tokens that occurs more often than its words on their own would suggest. PMI is the pointwise mutual information log2(P(w1..wn) / (P(w1)...P(wn))) in bits.
+type Collocation struct {
+	Words []string
+	Count int
+	PMI   float64
+}
+
+// GrowthPoint is a point of the vocabulary growth curve: Vocabulary distinct tokens were seen in the first Tokens tokens, which span Docs documents
+type GrowthPoint struct {
+	Docs       int
+	Tokens     int
+	Vocabulary int
+}
+
+// CorpusStats are word statistics over the documents of a snapshot. Words are sorted by count, collocations by PMI, most first.
+type CorpusStats struct {
+	Docs     int
+	Tokens   int
+	Words    []WordStat
+	Bigrams  []Collocation
+	Trigrams []Collocation
+	Growth   []GrowthPoint
+}
+
+// Stats computes word frequencies, document frequencies, bigram and trigram collocations and the vocabulary growth curve over every document. Documents are read in doc ID order as tokens written to the index, and n-grams never span two documents.
+func (d *DB) Stats(opts StatsOptions) CorpusStats {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Stats(opts)
+}
+
+// Stats computes the statistics of the snapshot, see DB.Stats
+func (s *Snapshot) Stats(opts StatsOptions) CorpusStats {
+	if opts.MinCount <= 0 {
+		opts.MinCount = defaultMinCollocationCount
+	}
+	if opts.GrowthStep <= 0 {
+		opts.GrowthStep = defaultGrowthStep
+	}
+
+	var stats CorpusStats
+	words := make(map[string]*WordStat)
+	bigrams := make(map[string]int)
+	trigrams := make(map[string]int)
+
+	ids := s.ids()
+	stats.Docs = len(ids)
+	for i, id := range ids {
+		s.db.mu.RLock()
+		v, exists := s.lookup(id)
+		s.db.mu.RUnlock()
+		if !exists {
+			continue
+		}
+
+		tokens := s.db.docTokens(v.doc.Text)
+		seen := make(map[string]struct{})
+		for j, t := range tokens {
+			w, exists := words[t]
+			if !exists {
+				w = &WordStat{Word: t}
+				words[t] = w
+			}
+			w.Count++
+			if _, exists := seen[t]; !exists {
+				seen[t] = struct{}{}
+				w.DocFreq++
+			}
+
+			stats.Tokens++
+			if stats.Tokens%opts.GrowthStep == 0 {
+				stats.Growth = append(stats.Growth, GrowthPoint{Docs: i + 1, Tokens: stats.Tokens, Vocabulary: len(words)})
+			}
+
+			if j >= 1 {
+				bigrams[tokens[j-1]+ngramSep+t]++
+			}
+			if j >= 2 {
+				trigrams[tokens[j-2]+ngramSep+tokens[j-1]+ngramSep+t]++
+			}
+		}
+	}
+	if n := len(stats.Growth); stats.Tokens > 0 && (n == 0 || stats.Growth[n-1].Tokens != stats.Tokens) {
+		stats.Growth = append(stats.Growth, GrowthPoint{Docs: stats.Docs, Tokens: stats.Tokens, Vocabulary: len(words)})
+	}
+
+	for _, w := range words {
+		stats.Words = append(stats.Words, *w)
+	}
+	sort.Slice(stats.Words, func(i, j int) bool {
+		if stats.Words[i].Count != stats.Words[j].Count {
+			return stats.Words[i].Count > stats.Words[j].Count
+		}
+		return stats.Words[i].Word < stats.Words[j].Word
+	})
+	if opts.Size > 0 && len(stats.Words) > opts.Size {
+		stats.Words = stats.Words[:opts.Size]
+	}
+
+	stats.Bigrams = collocations(bigrams, words, stats.Tokens, opts)
+	stats.Trigrams = collocations(trigrams, words, stats.Tokens, opts)
+	return stats
+}
+
+// collocations scores the n-grams occurring at least MinCount times by PMI. The probability of an n-gram is its count over the number of n-grams, that of a word its count over the number of tokens.
+func collocations(ngrams map[string]int, words map[string]*WordStat, tokens int, opts StatsOptions) []Collocation {
+	var total int
+	for _, c := range ngrams {
+		total += c
+	}
+
+	var res []Collocation
+	for ngram, c := range ngrams {
+		if c < opts.MinCount {
+			continue
+		}
+		col := Collocation{Words: strings.Split(ngram, ngramSep), Count: c}
+		col.PMI = math.Log2(float64(c) / float64(total))
+		for _, w := range col.Words {
+			col.PMI -= math.Log2(float64(words[w].Count) / float64(tokens))
+		}
+		res = append(res, col)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].PMI != res[j].PMI {
+			return res[i].PMI > res[j].PMI
+		}
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return strings.Join(res[i].Words, ngramSep) < strings.Join(res[j].Words, ngramSep)
+	})
+	if opts.Size > 0 && len(res) > opts.Size {
+		res = res[:opts.Size]
+	}
+	return res
+}
+
+// WriteWordsCSV writes the word frequencies as CSV rows of word, count and doc_freq after a header row
+func WriteWordsCSV(w io.Writer, words []WordStat) error {
+	rows := [][]string{{"word", "count", "doc_freq"}}
+	for _, ws := range words {
+		rows = append(rows, []string{ws.Word, strconv.Itoa(ws.Count), strconv.Itoa(ws.DocFreq)})
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteCollocationsCSV writes the collocations as CSV rows of the space separated ngram, count and pmi after a header row
+func WriteCollocationsCSV(w io.Writer, cols []Collocation) error {
+	rows := [][]string{{"ngram", "count", "pmi"}}
+	for _, c := range cols {
+		rows = append(rows, []string{strings.Join(c.Words, " "), strconv.Itoa(c.Count), strconv.FormatFloat(c.PMI, 'f', 4, 64)})
+	}
+	return writeCSV(w, rows)
+}
+
+// WriteGrowthCSV writes the vocabulary growth curve as CSV rows of docs, tokens and vocabulary after a header row
+func WriteGrowthCSV(w io.Writer, growth []GrowthPoint) error {
+	rows := [][]string{{"docs", "tokens", "vocabulary"}}
+	for _, p := range growth {
+		rows = append(rows, []string{strconv.Itoa(p.Docs), strconv.Itoa(p.Tokens), strconv.Itoa(p.Vocabulary)})
+	}
+	return writeCSV(w, rows)
+}
+
+// writeCSV writes the rows and returns the first write error
+func writeCSV(w io.Writer, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
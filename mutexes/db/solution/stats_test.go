@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	db := NewDB()
+	texts := []string{
+		"the white rabbit ran",
+		"the white rabbit hid",
+		"the white rabbit and the queen",
+		"rabbit rabbit",
+	}
+	for i, text := range texts {
+		if err := db.Index(Document{ID: i, Text: text}); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", i, err)
+		}
+	}
+	db.Index(Document{ID: 4, Text: "deleted white rabbit"})
+	db.Delete(4)
+
+	stats := db.Stats(StatsOptions{MinCount: 2, GrowthStep: 5})
+	if stats.Docs != 4 || stats.Tokens != 16 {
+		t.Errorf("Expected 4 documents and 16 tokens, but got %d and %d", stats.Docs, stats.Tokens)
+	}
+	expectedWords := []WordStat{
+		{"rabbit", 5, 4}, {"the", 4, 3}, {"white", 3, 3},
+		{"and", 1, 1}, {"hid", 1, 1}, {"queen", 1, 1}, {"ran", 1, 1},
+	}
+	if !slices.Equal(stats.Words, expectedWords) {
+		t.Errorf("Expected words %v, but got %v", expectedWords, stats.Words)
+	}
+
+	// 12 bigrams and 8 trigrams: "white rabbit" occurs 3 times out of 12, "white" 3 and "rabbit" 5 times out of 16 tokens
+	testData := []struct {
+		cols     []Collocation
+		words    []string
+		count    int
+		expected float64
+	}{
+		{stats.Bigrams, []string{"white", "rabbit"}, 3, math.Log2(3.0/12) - math.Log2(3.0/16) - math.Log2(5.0/16)},
+		{stats.Bigrams, []string{"the", "white"}, 3, math.Log2(3.0/12) - math.Log2(4.0/16) - math.Log2(3.0/16)},
+		{stats.Trigrams, []string{"the", "white", "rabbit"}, 3, math.Log2(3.0/8) - math.Log2(4.0/16) - math.Log2(3.0/16) - math.Log2(5.0/16)},
+	}
+	for _, d := range testData {
+		i := slices.IndexFunc(d.cols, func(c Collocation) bool { return slices.Equal(c.Words, d.words) })
+		if i < 0 {
+			t.Errorf("Expected collocation %q, but got %v", d.words, d.cols)
+			continue
+		}
+		if c := d.cols[i]; c.Count != d.count || math.Abs(c.PMI-d.expected) > 1e-9 {
+			t.Errorf("Expected %q to occur %d times with PMI %.4f, but got %d and %.4f", d.words, d.count, d.expected, c.Count, c.PMI)
+		}
+	}
+	if len(stats.Bigrams) != 2 || stats.Bigrams[0].PMI < stats.Bigrams[1].PMI {
+		t.Errorf("Expected 2 bigrams sorted by PMI, but got %v", stats.Bigrams)
+	}
+	if len(stats.Trigrams) != 1 {
+		t.Errorf("Expected a single trigram occurring twice, but got %v", stats.Trigrams)
+	}
+
+	expectedGrowth := []GrowthPoint{{2, 5, 4}, {3, 10, 5}, {4, 15, 7}, {4, 16, 7}}
+	if !slices.Equal(stats.Growth, expectedGrowth) {
+		t.Errorf("Expected growth %v, but got %v", expectedGrowth, stats.Growth)
+	}
+
+	if top := db.Stats(StatsOptions{Size: 1, MinCount: 1}); len(top.Words) != 1 || len(top.Bigrams) != 1 || len(top.Trigrams) != 1 {
+		t.Errorf("Expected a single word and collocation of each size, but got %v", top)
+	}
+	if empty := NewDB().Stats(StatsOptions{}); empty.Tokens != 0 || empty.Words != nil || empty.Growth != nil {
+		t.Errorf("Expected no statistics for an empty db, but got %v", empty)
+	}
+}
+
+func TestStatsCSV(t *testing.T) {
+	db := NewDB()
+	shards, err := splitTextFile("../alice-in-wonderland.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, doc := range shards[0] {
+		doc.ID = i
+		db.Index(doc)
+	}
+	stats := db.Stats(StatsOptions{Size: 20})
+	if stats.Words[0].Word != "the" {
+		t.Errorf("Expected the most frequent word to be the, but got %v", stats.Words[0])
+	}
+	if !slices.ContainsFunc(stats.Bigrams, func(c Collocation) bool { return slices.Equal(c.Words, []string{"march", "hare"}) }) {
+		t.Errorf("Expected march hare among the top collocations, but got %v", stats.Bigrams)
+	}
+	for i := 1; i < len(stats.Growth); i++ {
+		if prev, p := stats.Growth[i-1], stats.Growth[i]; p.Vocabulary < prev.Vocabulary || p.Tokens-prev.Tokens > defaultGrowthStep {
+			t.Errorf("Expected a growing vocabulary, but got %v after %v", p, prev)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCollocationsCSV(&buf, stats.Bigrams); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(stats.Bigrams)+1 || !slices.Equal(rows[0], []string{"ngram", "count", "pmi"}) || rows[1][0] != strings.Join(stats.Bigrams[0].Words, " ") {
+		t.Errorf("Expected a header and a row per bigram, but got %v", rows)
+	}
+
+	var stdout bytes.Buffer
+	for _, table := range []string{"words", "bigrams", "trigrams", "growth"} {
+		stdout.Reset()
+		if err := run([]string{"stats", "-table", table, "-size", "3", "../alice-in-wonderland.txt"}, &stdout); err != nil {
+			t.Fatalf("Failed to write the %s table, %v", table, err)
+		}
+		if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); len(lines) < 2 {
+			t.Errorf("Expected a header and rows for the %s table, but got %q", table, lines)
+		}
+	}
+	if err := run([]string{"stats", "-table", "letters", "../alice-in-wonderland.txt"}, &stdout); err == nil {
+		t.Error("Should have returned an error for an unknown table")
+	}
+}
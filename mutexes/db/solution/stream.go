@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"sort"
+)
+
+// streamChunk is the number of postings resolved to documents per read lock
+const streamChunk = 64
+
+// Stream returns an iterator over the documents matching the query string, in the same order as Query. Posting lists are walked lazily a chunk at a time, so memory stays flat however many documents match and breaking out of the loop stops the work. A snapshot is held while the iteration runs. When the context is done the iterator yields ctx.Err() and stops.
+func (d *DB) Stream(ctx context.Context, term string) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		s := d.Snapshot()
+		defer s.Release()
+		for doc, err := range s.Stream(ctx, term) {
+			if !yield(doc, err) {
+				return
+			}
+		}
+	}
+}
+
+// Stream returns an iterator over the documents of the snapshot matching the query string, see DB.Stream
+func (s *Snapshot) Stream(ctx context.Context, term string) iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		tokens := s.db.queryTokens(term)
+		for i, t := range tokens {
+			for doc := range s.termDocs(t) {
+				if err := ctx.Err(); err != nil {
+					yield(Document{}, err)
+					return
+				}
+				// a document containing an earlier term was already yielded for it
+				if i > 0 && containsAny(s.db.indexTokens(doc.Text), tokens[:i]) {
+					continue
+				}
+				if !yield(doc, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// termDocs returns an iterator over the documents containing the term in posting list order. The posting list is captured once and resolved a chunk at a time, holding the read lock only while a chunk is looked up.
+func (s *Snapshot) termDocs(term string) iter.Seq[Document] {
+	return func(yield func(Document) bool) {
+		s.db.mu.RLock()
+		list := s.db.index[term]
+		s.db.mu.RUnlock()
+
+		chunk := make([]Document, 0, streamChunk)
+		for start := 0; start < len(list); start += streamChunk {
+			chunk = chunk[:0]
+			s.db.mu.RLock()
+			for _, p := range list[start:min(start+streamChunk, len(list))] {
+				if v, exists := s.lookup(p.id); exists && v.created == p.created {
+					chunk = append(chunk, v.doc)
+				}
+			}
+			s.db.mu.RUnlock()
+
+			for _, doc := range chunk {
+				if !yield(doc) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// containsAny reports whether any of terms is found in the sorted tokens
+func containsAny(tokens []string, terms []string) bool {
+	for _, t := range terms {
+		if i := sort.SearchStrings(tokens, t); i < len(tokens) && tokens[i] == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 500; i++ {
+		text := fmt.Sprintf("line %d", i)
+		if i%3 == 0 {
+			text += " fizz"
+		}
+		if i%5 == 0 {
+			text += " buzz"
+		}
+		if err := db.Index(Document{ID: i, Text: text}); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", i, err)
+		}
+	}
+
+	for _, q := range []string{"fizz", "buzz", "fizz buzz", "line", "missing"} {
+		expected, _ := db.Query(q)
+		var res []Document
+		for doc, err := range db.Stream(context.Background(), q) {
+			if err != nil {
+				t.Fatalf("Got an error while streaming %s, %v", q, err)
+			}
+			res = append(res, doc)
+		}
+		if len(res) != len(expected) {
+			t.Errorf("Expected %d documents for %s, but got %d", len(expected), q, len(res))
+			continue
+		}
+		for i := range res {
+			if res[i] != expected[i] {
+				t.Errorf("Expected %v at position %d for %s, but got %v", expected[i], i, q, res[i])
+			}
+		}
+	}
+
+	// stopping early releases the snapshot held by the iterator
+	var n int
+	for range db.Stream(context.Background(), "line") {
+		if n++; n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Errorf("Expected to stop after 10 documents, but got %d", n)
+	}
+	if len(db.snapshots) != 0 {
+		t.Errorf("Expected no open snapshots, but got %v", db.snapshots)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n = 0
+	var lastErr error
+	for _, err := range db.Stream(ctx, "line") {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		if n++; n == 5 {
+			cancel()
+		}
+	}
+	if lastErr != context.Canceled || n != 5 {
+		t.Errorf("Expected %v after 5 documents, but got %v after %d", context.Canceled, lastErr, n)
+	}
+}
+
+func TestStreamConcurrentWrites(t *testing.T) {
+	db := NewDB()
+	for i := 0; i < 1000; i++ {
+		db.Index(Document{ID: i, Text: "stream me"})
+	}
+
+	s := db.Snapshot()
+	defer s.Release()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			db.Delete(i)
+			db.Index(Document{ID: 1000 + i, Text: "stream me too"})
+			if i%100 == 0 {
+				db.Vacuum()
+			}
+		}
+	}()
+
+	var n int
+	for _, err := range s.Stream(context.Background(), "stream") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	wg.Wait()
+
+	if n != 1000 {
+		t.Errorf("Expected the snapshot to stream 1000 documents, but got %d", n)
+	}
+}
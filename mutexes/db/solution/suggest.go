@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// maxSuggestTerms caps how many candidate corrections are kept per query token
+	maxSuggestTerms = 3
+	// suggestBeamWidth is the number of partial corrections kept per query token when fewer suggestions are asked for
+	suggestBeamWidth = 10
+	// maxSuggestQueries caps the number of corrected queries run to count their hits, whatever the number of suggestions asked for
+	maxSuggestQueries = 50
+)
+
+// Suggestion is a corrected query string built from terms present in the index. Distance is the total edit distance from the original query and Hits is the number of documents the corrected query matches.
+type Suggestion struct {
+	Text     string
+	Distance int
+	Hits     int
+}
+
+// partialSuggestion is a combination of corrections for the first tokens of a query. freq sums the document frequencies of its terms.
+type partialSuggestion struct {
+	terms []string
+	dist  int
+	freq  int
+}
+
+// correction is a candidate replacement for a single query token
+type correction struct {
+	term string
+	dist int
+	freq int
+}
+
+// Suggest proposes up to n corrected versions of the query string. Each token of the query is compared against the indexed vocabulary and replaced by terms within a small edit distance, preferring closer and more frequent terms. Tokens already present in the index are kept as is. Corrections are combined token by token keeping only the best partial combinations, so at most maxSuggestQueries corrected queries run however many tokens are misspelled. An empty slice is returned when no token could be corrected.
+func (d *DB) Suggest(query string, n int) []Suggestion {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Suggest(query, n)
+}
+
+// Suggest proposes corrected versions of the query string from the vocabulary of the snapshot, see DB.Suggest
+func (s *Snapshot) Suggest(query string, n int) []Suggestion {
+	if n <= 0 {
+		return []Suggestion{}
+	}
+
+	// keep the query tokens in the order the user typed them
+	tokens := s.db.analyzer.Tokens(query)
+
+	var changed bool
+	candidates := make([][]correction, len(tokens))
+	for i, t := range tokens {
+		if df := s.docFreq(t); df > 0 {
+			candidates[i] = []correction{{term: t, freq: df}}
+			continue
+		}
+		candidates[i] = s.corrections(t)
+		if len(candidates[i]) == 0 {
+			// nothing close enough, leave the token alone
+			candidates[i] = []correction{{term: t}}
+			continue
+		}
+		changed = true
+	}
+	if !changed {
+		return []Suggestion{}
+	}
+
+	// keep only the best partial corrections at every position so the number of queries stays bounded
+	width := min(max(n, suggestBeamWidth), maxSuggestQueries)
+	beam := []partialSuggestion{{}}
+	for _, cands := range candidates {
+		next := make([]partialSuggestion, 0, len(beam)*len(cands))
+		for _, p := range beam {
+			for _, cand := range cands {
+				terms := make([]string, len(p.terms), len(p.terms)+1)
+				copy(terms, p.terms)
+				next = append(next, partialSuggestion{terms: append(terms, cand.term), dist: p.dist + cand.dist, freq: p.freq + cand.freq})
+			}
+		}
+		sort.Slice(next, func(i, j int) bool {
+			if next[i].dist != next[j].dist {
+				return next[i].dist < next[j].dist
+			}
+			if next[i].freq != next[j].freq {
+				return next[i].freq > next[j].freq
+			}
+			return strings.Join(next[i].terms, " ") < strings.Join(next[j].terms, " ")
+		})
+		beam = next[:min(len(next), width)]
+	}
+
+	suggestions := make([]Suggestion, 0, len(beam))
+	for _, p := range beam {
+		sug := Suggestion{Text: strings.Join(p.terms, " "), Distance: p.dist}
+		if res, err := s.Query(sug.Text); err == nil {
+			sug.Hits = len(res)
+		}
+		suggestions = append(suggestions, sug)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Distance != suggestions[j].Distance {
+			return suggestions[i].Distance < suggestions[j].Distance
+		}
+		if suggestions[i].Hits != suggestions[j].Hits {
+			return suggestions[i].Hits > suggestions[j].Hits
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+
+	if len(suggestions) > n {
+		suggestions = suggestions[:n]
+	}
+	return suggestions
+}
+
+// QueryWithSuggestions runs Query and, when fewer than minHits documents are found, also returns up to 5 spelling suggestions for the query string. Both run against the same snapshot.
+func (d *DB) QueryWithSuggestions(term string, minHits int) ([]Document, []Suggestion, error) {
+	s := d.Snapshot()
+	defer s.Release()
+
+	res, err := s.Query(term)
+	if err != nil {
+		return res, nil, err
+	}
+	if len(res) >= minHits {
+		return res, []Suggestion{}, nil
+	}
+	return res, s.Suggest(term, 5), nil
+}
+
+// corrections finds the indexed terms closest to the token. Short tokens allow a single edit while longer tokens allow two. Results are ordered by edit distance and then by document frequency.
+func (s *Snapshot) corrections(token string) []correction {
+	maxDist := 1
+	if len([]rune(token)) > 4 {
+		maxDist = 2
+	}
+
+	// a single scan of the vocabulary under one lock
+	var res []correction
+	s.db.mu.RLock()
+	for term, list := range s.db.index {
+		if isFieldTerm(term) {
+			continue
+		}
+		dist := editDistance(token, term, maxDist)
+		if dist > maxDist {
+			continue
+		}
+		var df int
+		for _, p := range list {
+			if s.visible(p) {
+				df++
+			}
+		}
+		if df > 0 {
+			res = append(res, correction{term: term, dist: dist, freq: df})
+		}
+	}
+	s.db.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].dist != res[j].dist {
+			return res[i].dist < res[j].dist
+		}
+		if res[i].freq != res[j].freq {
+			return res[i].freq > res[j].freq
+		}
+		return res[i].term < res[j].term
+	})
+
+	if len(res) > maxSuggestTerms {
+		res = res[:maxSuggestTerms]
+	}
+	return res
+}
+
+// editDistance computes the Damerau-Levenshtein distance (with adjacent transpositions) between a and b. Computation stops early once the distance is known to exceed max, in which case max+1 is returned.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+
+	// three rolling rows are enough to account for transpositions
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	if prev[len(rb)] > max {
+		return max + 1
+	}
+	return prev[len(rb)]
+}
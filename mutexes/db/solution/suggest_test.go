@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	testData := []struct {
+		a, b     string
+		max      int
+		expected int
+	}{
+		{"alice", "alice", 2, 0},
+		{"alise", "alice", 2, 1},
+		{"alcie", "alice", 2, 1},
+		{"alce", "alice", 2, 1},
+		{"wonderlnad", "wonderland", 2, 1},
+		{"wundrland", "wonderland", 2, 2},
+		{"queen", "alice", 2, 3},
+		{"a", "abcd", 1, 2},
+	}
+
+	for _, d := range testData {
+		if res := editDistance(d.a, d.b, d.max); res != d.expected {
+			t.Errorf("Expected distance %d between %s and %s, but got %d", d.expected, d.a, d.b, res)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	db := NewDB()
+	docs := []Document{
+		{ID: 0, Text: "alice was beginning to get very tired"},
+		{ID: 1, Text: "alice in wonderland"},
+		{ID: 2, Text: "the queen of hearts"},
+		{ID: 3, Text: "the queer little rabbit"},
+		{ID: 4, Text: "off with her head said the queen"},
+	}
+	for _, doc := range docs {
+		if err := db.Index(doc); err != nil {
+			t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+		}
+	}
+
+	testData := []struct {
+		query    string
+		expected string
+	}{
+		{"alise", "alice"},
+		{"Alice wondreland", "alice wonderland"},
+		{"queem", "queen"},
+		{"hearts qeen", "hearts queen"},
+	}
+
+	for _, d := range testData {
+		res := db.Suggest(d.query, 3)
+		if len(res) == 0 {
+			t.Errorf("Expected suggestions for %s, but got none", d.query)
+			continue
+		}
+		if res[0].Text != d.expected {
+			t.Errorf("Expected top suggestion %s for %s, but got %v", d.expected, d.query, res)
+		}
+		if res[0].Hits == 0 {
+			t.Errorf("Expected suggestion %s to match documents", res[0].Text)
+		}
+	}
+
+	if res := db.Suggest("alice queen", 3); len(res) != 0 {
+		t.Errorf("Expected no suggestions for a correctly spelled query, but got %v", res)
+	}
+
+	// every misspelled token has several candidates, only the best combinations are kept and queried
+	long := strings.Repeat("queem alise hearst ", 6)
+	res := db.Suggest(long, 100)
+	if len(res) == 0 || len(res) > maxSuggestQueries {
+		t.Fatalf("Expected between 1 and %d suggestions, but got %d", maxSuggestQueries, len(res))
+	}
+	if res[0].Distance != 18 || res[0].Hits == 0 {
+		t.Errorf("Expected a top suggestion correcting every token with a single edit, but got %v", res[0])
+	}
+	for i := 1; i < len(res); i++ {
+		if res[i].Distance < res[i-1].Distance {
+			t.Errorf("Expected suggestions sorted by distance, but got %v", res)
+		}
+	}
+
+	docs, suggestions, err := db.QueryWithSuggestions("wonderlnad", 1)
+	if err != nil {
+		t.Fatalf("Got an error while querying, %v", err)
+	}
+	if len(docs) != 0 {
+		t.Errorf("Expected no documents, but got %v", docs)
+	}
+	if len(suggestions) == 0 || suggestions[0].Text != "wonderland" {
+		t.Errorf("Expected wonderland as top suggestion, but got %v", suggestions)
+	}
+}
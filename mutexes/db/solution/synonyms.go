@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SynonymMode selects whether synonyms are expanded when documents are indexed or when queries are analyzed
+type SynonymMode int
+
+const (
+	// SynonymsAtQuery expands query strings, the index stays untouched and rules can change without reindexing
+	SynonymsAtQuery SynonymMode = iota
+	// SynonymsAtIndex expands document text before it is written to the index
+	SynonymsAtIndex
+)
+
+// SynonymMap holds synonym rules keyed by the analyzed token sequence they match
+type SynonymMap struct {
+	rules  map[string][][]string
+	maxLen int
+}
+
+// NewSynonymMap creates an empty SynonymMap
+func NewSynonymMap() *SynonymMap {
+	return &SynonymMap{rules: make(map[string][][]string)}
+}
+
+// LoadSynonymFile reads synonym rules from a file, see ParseSynonyms for the format
+func LoadSynonymFile(filename string, a Analyzer) (*SynonymMap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSynonyms(f, a)
+}
+
+// ParseSynonyms reads synonym rules, one per line. A comma separated list such as "rabbit, hare, bunny" declares equivalent terms that all expand to each other. A rule such as "white rabbit => hare, bunny" is one-way: the terms on the left are replaced by the terms on the right. Terms may span several words. Blank lines and lines starting with # are ignored. Each term is tokenized with the analyzer so that rules match the tokens produced for documents and queries.
+func ParseSynonyms(r io.Reader, a Analyzer) (*SynonymMap, error) {
+	m := NewSynonymMap()
+	sc := bufio.NewScanner(r)
+	var lineNum int
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if lhs, rhs, oneWay := strings.Cut(line, "=>"); oneWay {
+			from, err := parseSynonymTerms(lhs, a)
+			if err != nil {
+				return nil, fmt.Errorf("synonyms: line %d: %v", lineNum, err)
+			}
+			to, err := parseSynonymTerms(rhs, a)
+			if err != nil {
+				return nil, fmt.Errorf("synonyms: line %d: %v", lineNum, err)
+			}
+			for _, f := range from {
+				m.Add(f, to...)
+			}
+			continue
+		}
+
+		terms, err := parseSynonymTerms(line, a)
+		if err != nil {
+			return nil, fmt.Errorf("synonyms: line %d: %v", lineNum, err)
+		}
+		if len(terms) < 2 {
+			return nil, fmt.Errorf("synonyms: line %d: equivalence rule needs at least two terms", lineNum)
+		}
+		for _, t := range terms {
+			m.Add(t, terms...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseSynonymTerms splits a comma separated list of terms and analyzes each of them
+func parseSynonymTerms(list string, a Analyzer) ([][]string, error) {
+	var terms [][]string
+	for _, raw := range strings.Split(list, ",") {
+		tokens := a.Tokens(raw)
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("empty term in %q", strings.TrimSpace(list))
+		}
+		terms = append(terms, tokens)
+	}
+	return terms, nil
+}
+
+// Add registers a rule where the token sequence from is replaced by each of the token sequences in to. Include from in to to keep the original tokens.
+func (m *SynonymMap) Add(from []string, to ...[]string) {
+	key := strings.Join(from, " ")
+	for _, t := range to {
+		if !containsTokens(m.rules[key], t) {
+			m.rules[key] = append(m.rules[key], t)
+		}
+	}
+	if len(from) > m.maxLen {
+		m.maxLen = len(from)
+	}
+}
+
+// containsTokens reports whether list already holds the token sequence t
+func containsTokens(list [][]string, t []string) bool {
+	for _, l := range list {
+		if strings.Join(l, " ") == strings.Join(t, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter is a TokenFilter that replaces every token sequence matching a rule with its synonyms. The longest matching rule at each position wins and tokens not covered by any rule pass through unchanged.
+func (m *SynonymMap) Filter(tokens []string) []string {
+	var res []string
+	for i := 0; i < len(tokens); {
+		matched := false
+		for n := min(m.maxLen, len(tokens)-i); n > 0; n-- {
+			if to, exists := m.rules[strings.Join(tokens[i:i+n], " ")]; exists {
+				for _, t := range to {
+					res = append(res, t...)
+				}
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			res = append(res, tokens[i])
+			i++
+		}
+	}
+	return res
+}
+
+// WithSynonyms expands tokens with the synonym rules in m either at index time or at query time
+func WithSynonyms(m *SynonymMap, mode SynonymMode) Option {
+	return func(d *DB) {
+		d.synonyms = m
+		d.synonymMode = mode
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSynonyms = `
+# animals
+rabbit, hare, bunny
+guinea pig, cavy
+looking glass => mirror
+Dodo => dodo, bird
+`
+
+func TestParseSynonyms(t *testing.T) {
+	m, err := ParseSynonyms(strings.NewReader(testSynonyms), NewUnicodeAnalyzer(false))
+	if err != nil {
+		t.Fatalf("Failed to parse synonyms, %v", err)
+	}
+
+	testData := []struct {
+		tokens   []string
+		expected []string
+	}{
+		{[]string{"the", "hare"}, []string{"the", "rabbit", "hare", "bunny"}},
+		{[]string{"a", "guinea", "pig", "ran"}, []string{"a", "guinea", "pig", "cavy", "ran"}},
+		{[]string{"cavy"}, []string{"guinea", "pig", "cavy"}},
+		{[]string{"the", "looking", "glass"}, []string{"the", "mirror"}},
+		{[]string{"looking", "queen"}, []string{"looking", "queen"}},
+		{[]string{"dodo"}, []string{"dodo", "bird"}},
+		{[]string{"bird"}, []string{"bird"}},
+	}
+
+	for _, d := range testData {
+		if res := m.Filter(d.tokens); !reflect.DeepEqual(res, d.expected) {
+			t.Errorf("Expected %v for %v, but got %v", d.expected, d.tokens, res)
+		}
+	}
+
+	invalid := []string{"rabbit", "rabbit, , hare", "=> hare"}
+	for _, rules := range invalid {
+		if _, err := ParseSynonyms(strings.NewReader(rules), NewUnicodeAnalyzer(false)); err == nil {
+			t.Errorf("Should have returned an error for rules %q", rules)
+		}
+	}
+}
+
+func TestSynonymQuery(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "synonyms.txt")
+	if err := os.WriteFile(filename, []byte(testSynonyms), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadSynonymFile(filename, NewUnicodeAnalyzer(false))
+	if err != nil {
+		t.Fatalf("Failed to load synonyms, %v", err)
+	}
+
+	docs := []Document{
+		{ID: 0, Text: "the White Rabbit was late"},
+		{ID: 1, Text: "the March Hare"},
+		{ID: 2, Text: "a guinea pig was suppressed"},
+		{ID: 3, Text: "the Dodo said"},
+	}
+
+	testData := []struct {
+		query    string
+		expected int
+	}{
+		{"bunny", 2},
+		{"rabbit", 2},
+		{"hare", 2},
+		{"cavy", 1},
+		{"dodo", 1},
+	}
+
+	for _, mode := range []SynonymMode{SynonymsAtQuery, SynonymsAtIndex} {
+		db := NewDB(WithSynonyms(m, mode))
+		for _, doc := range docs {
+			if err := db.Index(doc); err != nil {
+				t.Fatalf("Failed to index doc ID %d, %v", doc.ID, err)
+			}
+		}
+		for _, d := range testData {
+			res, err := db.Query(d.query)
+			if err != nil {
+				t.Errorf("Got an error while querying %s, %v", d.query, err)
+			}
+			if len(res) != d.expected {
+				t.Errorf("Expected %d results for %s in mode %d, but got %d, res: %v", d.expected, d.query, mode, len(res), res)
+			}
+		}
+	}
+}
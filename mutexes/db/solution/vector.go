@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// VectorOptions configures the document vectors enabled with WithVectors
+type VectorOptions struct {
+	// Dims is the number of hash buckets tokens are folded into, the dimension of the vectors
+	Dims int
+	// Bands is the number of LSH tables. More bands find more of the true neighbours at the cost of more candidates to score.
+	Bands int
+	// Rows is the number of random projections combined into the key of a table, at most 64. More rows make every table more selective.
+	Rows int
+}
+
+// DefaultVectorOptions finds most neighbours with a cosine similarity above 0.7 while scoring a few percent of unrelated documents
+var DefaultVectorOptions = VectorOptions{
+	Dims:  4096,
+	Bands: 16,
+	Rows:  8,
+}
+
+// WithVectors keeps a hashed tf-idf vector of every document and a random projection LSH index over them for Nearest. Zero options take their value from DefaultVectorOptions.
+func WithVectors(opts VectorOptions) Option {
+	return func(d *DB) {
+		if opts.Dims <= 0 {
+			opts.Dims = DefaultVectorOptions.Dims
+		}
+		if opts.Bands <= 0 {
+			opts.Bands = DefaultVectorOptions.Bands
+		}
+		if opts.Rows <= 0 || opts.Rows > 64 {
+			opts.Rows = DefaultVectorOptions.Rows
+		}
+		v := &vectorIndex{opts: opts, df: make([]int, opts.Dims), tables: make([]map[uint64][]posting, opts.Bands)}
+		for i := range v.tables {
+			v.tables[i] = make(map[uint64][]posting)
+		}
+		d.vectors = v
+	}
+}
+
+// docVector is the hashed term frequency vector of a document version: the sorted buckets its tokens fall into, the number of tokens in each bucket and the key of the document in every LSH table. Vectors are stored with the document version and never modified.
+type docVector struct {
+	buckets []int
+	tf      []int
+	keys    []uint64
+}
+
+// vectorIndex holds the LSH tables of the document vectors and the number of live documents with a token in each bucket, used for idf. Unlike the vectors the document frequencies are not versioned, snapshots weigh their vectors with the current ones.
+type vectorIndex struct {
+	opts   VectorOptions
+	df     []int
+	live   int
+	tables []map[uint64][]posting
+}
+
+// bucket hashes a token to its bucket
+func (x *vectorIndex) bucket(token string) int {
+	h := fnv.New64a()
+	h.Write([]byte(token))
+	return int(h.Sum64() % uint64(x.opts.Dims))
+}
+
+// vectorize builds the vector of a token stream
+func (x *vectorIndex) vectorize(tokens []string) *docVector {
+	counts := make(map[int]int)
+	for _, t := range tokens {
+		counts[x.bucket(t)]++
+	}
+	v := &docVector{buckets: make([]int, 0, len(counts))}
+	for b := range counts {
+		v.buckets = append(v.buckets, b)
+	}
+	sort.Ints(v.buckets)
+	v.tf = make([]int, len(v.buckets))
+	for i, b := range v.buckets {
+		v.tf[i] = counts[b]
+	}
+	v.keys = x.lshKeys(v)
+	return v
+}
+
+// projection returns the component of random hyperplane p along bucket b, either 1 or -1. Components are derived by hashing so the hyperplanes are never stored and every db draws the same ones.
+func projection(p, b int) float64 {
+	if mix64(uint64(p)<<32^uint64(b))&1 == 0 {
+		return -1
+	}
+	return 1
+}
+
+// mix64 scrambles the bits of z with the splitmix64 finalizer
+func mix64(z uint64) uint64 {
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// lshKeys computes the key of the vector in every table: one bit per hyperplane telling on which side of it the vector lies. Keys are computed on the sublinear term frequencies so they stay valid as document frequencies change.
+func (x *vectorIndex) lshKeys(v *docVector) []uint64 {
+	keys := make([]uint64, x.opts.Bands)
+	for t := range keys {
+		for r := 0; r < x.opts.Rows; r++ {
+			p := t*x.opts.Rows + r
+			var dot float64
+			for i, b := range v.buckets {
+				dot += projection(p, b) * (1 + math.Log(float64(v.tf[i])))
+			}
+			if dot > 0 {
+				keys[t] |= 1 << r
+			}
+		}
+	}
+	return keys
+}
+
+// add vectorizes a new live document version and adds it to the tables. Callers must hold the write lock.
+func (x *vectorIndex) add(tokens []string, p posting) *docVector {
+	v := x.vectorize(tokens)
+	for _, b := range v.buckets {
+		x.df[b]++
+	}
+	x.live++
+	for t, key := range v.keys {
+		x.tables[t][key] = append(x.tables[t][key], p)
+	}
+	return v
+}
+
+// deleted takes a deleted document version out of the document frequencies. Callers must hold the write lock.
+func (x *vectorIndex) deleted(v *docVector) {
+	for _, b := range v.buckets {
+		x.df[b]--
+	}
+	x.live--
+}
+
+// reclaim removes a reclaimed document version from the tables. Callers must hold the write lock.
+func (x *vectorIndex) reclaim(v *docVector, p posting) {
+	for t, key := range v.keys {
+		x.tables[t][key] = removePosting(x.tables[t][key], p)
+		if len(x.tables[t][key]) == 0 {
+			delete(x.tables[t], key)
+		}
+	}
+}
+
+// weightedVector is a vector weighted by tf-idf together with its euclidean norm
+type weightedVector struct {
+	buckets []int
+	weights []float64
+	norm    float64
+}
+
+// weigh applies sublinear tf and the current idf of every bucket. Callers must hold the lock.
+func (x *vectorIndex) weigh(v *docVector) weightedVector {
+	w := weightedVector{buckets: v.buckets, weights: make([]float64, len(v.buckets))}
+	for i, b := range v.buckets {
+		w.weights[i] = (1 + math.Log(float64(v.tf[i]))) * idf(x.df[b], x.live)
+		w.norm += w.weights[i] * w.weights[i]
+	}
+	w.norm = math.Sqrt(w.norm)
+	return w
+}
+
+// cosine returns the cosine similarity of two weighted vectors
+func cosine(a, b weightedVector) float64 {
+	if a.norm == 0 || b.norm == 0 {
+		return 0
+	}
+	var dot float64
+	for i, j := 0, 0; i < len(a.buckets) && j < len(b.buckets); {
+		switch {
+		case a.buckets[i] < b.buckets[j]:
+			i++
+		case a.buckets[i] > b.buckets[j]:
+			j++
+		default:
+			dot += a.weights[i] * b.weights[j]
+			i++
+			j++
+		}
+	}
+	return dot / (a.norm * b.norm)
+}
+
+// Nearest returns up to k documents most similar to the text by cosine similarity of their hashed tf-idf vectors, ranked by similarity as the score. Only documents sharing a key with the text in at least one LSH table are scored, so close neighbours may be missed and fewer than k documents returned. See NearestExact.
+func (d *DB) Nearest(text string, k int) ([]Hit, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Nearest(text, k)
+}
+
+// Nearest runs an approximate nearest neighbour search against the snapshot, see DB.Nearest
+func (s *Snapshot) Nearest(text string, k int) ([]Hit, error) {
+	return s.nearest("nearest", text, k, true)
+}
+
+// NearestExact is Nearest without the LSH index: every document is scored, which finds the true nearest neighbours at a cost linear in the size of the db
+func (d *DB) NearestExact(text string, k int) ([]Hit, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.NearestExact(text, k)
+}
+
+// NearestExact runs an exact nearest neighbour search against the snapshot, see DB.NearestExact
+func (s *Snapshot) NearestExact(text string, k int) ([]Hit, error) {
+	return s.nearest("nearest exact", text, k, false)
+}
+
+// nearest scores the candidates of the LSH tables, or every document when approximate is not set, against the text and returns the k best
+func (s *Snapshot) nearest(op, text string, k int, approximate bool) ([]Hit, error) {
+	x := s.db.vectors
+	if x == nil {
+		return []Hit{}, fmt.Errorf("%s: vectors are not enabled", op)
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	q := x.vectorize(s.db.docTokens(text))
+	qw := x.weigh(q)
+
+	var candidates []int
+	if approximate {
+		seen := make(map[int]struct{})
+		for t, key := range q.keys {
+			for _, p := range x.tables[t][key] {
+				if _, exists := seen[p.id]; !exists && s.visible(p) {
+					seen[p.id] = struct{}{}
+					candidates = append(candidates, p.id)
+				}
+			}
+		}
+	} else {
+		for id := range s.db.data {
+			candidates = append(candidates, id)
+		}
+	}
+
+	hits := []Hit{}
+	for _, id := range candidates {
+		v, exists := s.lookup(id)
+		if !exists || v.vec == nil {
+			continue
+		}
+		if score := cosine(qw, x.weigh(v.vec)); score > 0 {
+			hits = append(hits, Hit{Document: v.doc, Score: score})
+		}
+	}
+	sortHits(hits)
+	if len(hits) > k {
+		hits = hits[:max(k, 0)]
+	}
+	return hits, nil
+}
+
+// Vector returns the hashed tf-idf vector of the document with the specified doc ID, normalized to unit length. Every token counts towards dimension FNV-1a(token) mod Dims.
+func (d *DB) Vector(id int) ([]float64, error) {
+	s := d.Snapshot()
+	defer s.Release()
+	return s.Vector(id)
+}
+
+// Vector returns the vector of the document as of the snapshot, see DB.Vector
+func (s *Snapshot) Vector(id int) ([]float64, error) {
+	x := s.db.vectors
+	if x == nil {
+		return nil, fmt.Errorf("vector: vectors are not enabled")
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	v, exists := s.lookup(id)
+	if !exists {
+		return nil, fmt.Errorf("vector: id %d not present", id)
+	}
+	w := x.weigh(v.vec)
+	dense := make([]float64, x.opts.Dims)
+	for i, b := range w.buckets {
+		if w.norm > 0 {
+			dense[b] = w.weights[i] / w.norm
+		}
+	}
+	return dense, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNearest(t *testing.T) {
+	shards, err := splitTextFile("../alice-in-wonderland.txt", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := NewDB(WithVectors(DefaultVectorOptions))
+	b := db.NewBatch()
+	for _, doc := range shards[0] {
+		b.Add(doc)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	ids := b.IDs()
+
+	// a line of the book is its own nearest neighbour
+	var found, total int
+	for i := 0; i < len(ids); i += 97 {
+		doc, _ := db.Get(ids[i])
+		if len(db.analyzer.Tokens(doc.Text)) < 5 {
+			continue
+		}
+		hits, err := db.Nearest(doc.Text, 1)
+		if err != nil {
+			t.Fatalf("Failed to search, %v", err)
+		}
+		if len(hits) == 0 || hits[0].Text != doc.Text || math.Abs(hits[0].Score-1) > 1e-9 {
+			t.Errorf("Expected %q with score 1, but got %v", doc.Text, hits)
+		}
+
+		// the LSH index finds most of the exact neighbours
+		exact, _ := db.NearestExact(doc.Text, 5)
+		approx, _ := db.Nearest(doc.Text, 5)
+		inApprox := make(map[int]struct{})
+		for _, h := range approx {
+			inApprox[h.ID] = struct{}{}
+		}
+		for _, h := range exact {
+			if h.Score < 0.5 {
+				continue
+			}
+			total++
+			if _, exists := inApprox[h.ID]; exists {
+				found++
+			}
+		}
+	}
+	if total == 0 || float64(found)/float64(total) < 0.8 {
+		t.Errorf("Expected a recall of at least 0.8 for close neighbours, but found %d of %d", found, total)
+	}
+
+	hits, _ := db.Nearest("the Queen of Hearts, she made some tarts", 3)
+	if len(hits) != 3 || hits[0].Score < hits[1].Score || hits[1].Score < hits[2].Score {
+		t.Errorf("Expected 3 ranked hits, but got %v", hits)
+	}
+}
+
+func TestVector(t *testing.T) {
+	db := NewDB(WithVectors(VectorOptions{Dims: 64}))
+	db.Index(Document{ID: 1, Text: "the white rabbit"})
+	db.Index(Document{ID: 2, Text: "the white queen"})
+	db.Index(Document{ID: 3, Text: "a mad tea party"})
+
+	v, err := db.Vector(1)
+	if err != nil || len(v) != 64 {
+		t.Fatalf("Expected a vector of 64 dimensions, but got %v, %v", v, err)
+	}
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	if math.Abs(norm-1) > 1e-9 {
+		t.Errorf("Expected a unit vector, but got norm %f", norm)
+	}
+
+	hits, _ := db.NearestExact("white rabbit", 10)
+	if len(hits) != 2 || hits[0].ID != 1 || hits[1].ID != 2 {
+		t.Errorf("Expected doc IDs 1 and 2, but got %v", hits)
+	}
+
+	// deleted documents leave the results and the tables once reclaimed
+	s := db.Snapshot()
+	db.Delete(1)
+	if hits, _ := db.NearestExact("the white rabbit", 10); len(hits) != 1 || hits[0].ID != 2 {
+		t.Errorf("Expected doc ID 2, but got %v", hits)
+	}
+	if hits, _ := s.Nearest("the white rabbit", 10); len(hits) == 0 || hits[0].ID != 1 {
+		t.Errorf("Expected the snapshot to find doc ID 1, but got %v", hits)
+	}
+	s.Release()
+	db.Delete(2)
+	db.Delete(3)
+	db.Vacuum()
+	for i, table := range db.vectors.tables {
+		if len(table) != 0 {
+			t.Errorf("Expected table %d to be empty, but got %v", i, table)
+		}
+	}
+	if db.vectors.live != 0 {
+		t.Errorf("Expected no live vectors, but got %d", db.vectors.live)
+	}
+
+	if _, err := NewDB().Nearest("rabbit", 1); err == nil {
+		t.Error("Should have returned an error without vectors")
+	}
+	if _, err := db.Vector(1); err == nil {
+		t.Error("Should have returned an error for a missing document")
+	}
+}